@@ -8,18 +8,18 @@ import (
 	"strings"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipni/go-libipni/maurl"
 	mh "github.com/multiformats/go-multihash"
 	"github.com/storacha/go-ucanto/did"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/storacha/go-libstoracha/capabilities/assert"
 	"github.com/storacha/go-libstoracha/digestutil"
+	"github.com/storacha/go-libstoracha/metadata"
 )
 
 const (
-	BlobUrlPlaceholder    = "{blob}"
-	BlobCIDUrlPlaceholder = "{blobCID}"
+	BlobUrlPlaceholder    = metadata.BlobPlaceholder
+	BlobCIDUrlPlaceholder = metadata.BlobCIDPlaceholder
 )
 
 // Encode canonically encodes ContextID data.
@@ -30,22 +30,8 @@ func EncodeContextID(space did.DID, digest mh.Multihash) ([]byte, error) {
 // ShardCID extracts an alternate shard CID from the provider & location URLs in a location claim
 func ShardCID(provider peer.AddrInfo, caveats assert.LocationCaveats) (*cid.Cid, error) {
 
-	// analyze each provider address
-	for _, addr := range provider.Addrs {
-		// first, attempt to convert the addr to a url scheme
-		url, err := maurl.ToURL(addr)
-		// if it can't be converted, skip
-		if err != nil {
-			continue
-		}
-		// must be an http url
-		if !(url.Scheme == "http" || url.Scheme == "https") {
-			continue
-		}
-		// if it does not have replaceable components in a location url, skip
-		if !strings.Contains(url.Path, BlobUrlPlaceholder) && !strings.Contains(url.Path, BlobCIDUrlPlaceholder) {
-			continue
-		}
+	// analyze each provider http url with replaceable components in a location url
+	for _, url := range metadata.TemplateURLs(provider, BlobUrlPlaceholder, BlobCIDUrlPlaceholder) {
 		// generate a regex to capture matching components of the url
 		urlRegex, err := urlToRegex(url)
 		if err != nil {
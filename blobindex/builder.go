@@ -0,0 +1,172 @@
+package blobindex
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+
+	carv2 "github.com/ipld/go-car/v2"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	dm "github.com/storacha/go-libstoracha/blobindex/datamodel"
+	"github.com/storacha/go-ucanto/core/ipld"
+)
+
+// Progress reports the progress of indexing a shard.
+type Progress struct {
+	// Shard is the number of shards added to the builder before this one.
+	Shard int
+	// Bytes is the number of bytes of the shard read so far.
+	Bytes uint64
+	// Blocks is the number of blocks of the shard indexed so far.
+	Blocks int
+	// Digest is the multihash of the shard, set once it has been read in full.
+	Digest mh.Multihash
+}
+
+// BuilderOption is an option configuring a [Builder].
+type BuilderOption func(b *Builder)
+
+// WithProgress configures a function called after each block of a shard is
+// indexed, and once the shard has been read in full.
+func WithProgress(progress func(Progress)) BuilderOption {
+	return func(b *Builder) {
+		b.progress = progress
+	}
+}
+
+// Builder builds a sharded DAG index incrementally from shards streamed from
+// readers, so that shards do not need to be held in memory. Shards are hashed
+// with SHA2_256 while they are read.
+type Builder struct {
+	index    ShardedDagIndexView
+	progress func(Progress)
+	shards   int
+}
+
+// NewBuilder creates a builder for an index of the passed content.
+func NewBuilder(content ipld.Link, opts ...BuilderOption) *Builder {
+	b := &Builder{index: NewShardedDagIndexView(content, -1)}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Index returns the index built so far.
+func (b *Builder) Index() ShardedDagIndexView {
+	return b.index
+}
+
+// AddCAR indexes the blocks of a CARv1 or CARv2 shard read from r, returning
+// the multihash of the shard. The reader is read to the end, so that the
+// digest covers the whole shard. Positions are relative to the start of the
+// shard, including for blocks in the data payload of a CARv2.
+func (b *Builder) AddCAR(r io.Reader) (mh.Multihash, error) {
+	sr := b.newShardReader(r)
+	br, err := carv2.NewBlockReader(sr)
+	if err != nil {
+		return nil, fmt.Errorf("reading CAR header: %w", err)
+	}
+	var shardSlices []dm.BlobSliceModel
+	for {
+		md, err := br.SkipNext()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("reading CAR block: %w", err)
+		}
+		cidLen := uint64(md.Cid.ByteLen())
+		offset := md.SourceOffset + uint64(varint.UvarintSize(cidLen+md.Size)) + cidLen
+		shardSlices = append(shardSlices, dm.BlobSliceModel{
+			Multihash: md.Cid.Hash(),
+			Position:  Position{Offset: offset, Length: md.Size},
+		})
+		sr.reportBlock()
+	}
+	return b.finish(sr, shardSlices)
+}
+
+// AddBlob indexes a raw blob read from r, given the positions of the blocks it
+// contains. Each block is hashed with SHA2_256 while it is read, and bytes
+// outside of blocks are skipped. Positions must not overlap. The multihash of
+// the blob is returned.
+func (b *Builder) AddBlob(r io.Reader, blocks []Position) (mh.Multihash, error) {
+	blocks = slices.Clone(blocks)
+	slices.SortFunc(blocks, func(a, b Position) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	sr := b.newShardReader(r)
+	shardSlices := make([]dm.BlobSliceModel, 0, len(blocks))
+	for _, pos := range blocks {
+		if pos.Offset < sr.read {
+			return nil, fmt.Errorf("block at %d overlaps previous block ending at %d", pos.Offset, sr.read)
+		}
+		if _, err := io.CopyN(io.Discard, sr, int64(pos.Offset-sr.read)); err != nil {
+			return nil, fmt.Errorf("reading blob: %w", err)
+		}
+		h := sha256.New()
+		if _, err := io.CopyN(h, sr, int64(pos.Length)); err != nil {
+			return nil, fmt.Errorf("reading block at %d: %w", pos.Offset, err)
+		}
+		digest, err := mh.Encode(h.Sum(nil), mh.SHA2_256)
+		if err != nil {
+			return nil, err
+		}
+		shardSlices = append(shardSlices, dm.BlobSliceModel{Multihash: digest, Position: pos})
+		sr.reportBlock()
+	}
+	return b.finish(sr, shardSlices)
+}
+
+// finish reads the rest of the shard and adds its slices to the index.
+func (b *Builder) finish(sr *shardReader, shardSlices []dm.BlobSliceModel) (mh.Multihash, error) {
+	if _, err := io.Copy(io.Discard, sr); err != nil {
+		return nil, fmt.Errorf("reading shard: %w", err)
+	}
+	digest, err := mh.Encode(sr.hash.Sum(nil), mh.SHA2_256)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range shardSlices {
+		b.index.SetSlice(digest, s.Multihash, s.Position)
+	}
+	if b.progress != nil {
+		b.progress(Progress{Shard: b.shards, Bytes: sr.read, Blocks: sr.blocks, Digest: digest})
+	}
+	b.shards++
+	return digest, nil
+}
+
+// shardReader hashes and counts the bytes read from a shard.
+type shardReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	read     uint64
+	blocks   int
+	shard    int
+	progress func(Progress)
+}
+
+func (b *Builder) newShardReader(r io.Reader) *shardReader {
+	return &shardReader{r: r, hash: sha256.New(), shard: b.shards, progress: b.progress}
+}
+
+func (sr *shardReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.hash.Write(p[:n])
+	sr.read += uint64(n)
+	return n, err
+}
+
+func (sr *shardReader) reportBlock() {
+	sr.blocks++
+	if sr.progress != nil {
+		sr.progress(Progress{Shard: sr.shard, Bytes: sr.read, Blocks: sr.blocks})
+	}
+}
@@ -0,0 +1,79 @@
+package blobindex_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	carv2 "github.com/ipld/go-car/v2"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/blobindex"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	requireSlices := func(t *testing.T, shard []byte, slices blobindex.MultihashMap[blobindex.Position]) {
+		require.NotZero(t, slices.Size())
+		for slice, pos := range slices.Iterator() {
+			digest, err := mh.Sum(shard[pos.Offset:pos.Offset+pos.Length], mh.SHA2_256, -1)
+			require.NoError(t, err)
+			require.Equal(t, slice, digest)
+		}
+	}
+
+	t.Run("CARv1", func(t *testing.T) {
+		root, _, shard := testutil.RandomCAR(t, 1024)
+		var progress []blobindex.Progress
+		builder := blobindex.NewBuilder(root, blobindex.WithProgress(func(p blobindex.Progress) {
+			progress = append(progress, p)
+		}))
+		digest, err := builder.AddCAR(bytes.NewReader(shard))
+		require.NoError(t, err)
+		require.Equal(t, testutil.Must(mh.Sum(shard, mh.SHA2_256, -1))(t), digest)
+
+		expected, err := blobindex.FromShardArchives(root, [][]byte{shard})
+		require.NoError(t, err)
+		testutil.RequireEqualIndex(t, expected, builder.Index())
+
+		require.NotEmpty(t, progress)
+		last := progress[len(progress)-1]
+		require.Equal(t, digest, last.Digest)
+		require.Equal(t, uint64(len(shard)), last.Bytes)
+		require.Equal(t, builder.Index().Shards().Get(digest).Size(), last.Blocks)
+	})
+
+	t.Run("CARv2", func(t *testing.T) {
+		root, _, v1 := testutil.RandomCAR(t, 1024)
+		var v2 bytes.Buffer
+		require.NoError(t, carv2.WrapV1(bytes.NewReader(v1), &v2))
+		shard := v2.Bytes()
+
+		builder := blobindex.NewBuilder(root)
+		digest, err := builder.AddCAR(bytes.NewReader(shard))
+		require.NoError(t, err)
+		require.Equal(t, testutil.Must(mh.Sum(shard, mh.SHA2_256, -1))(t), digest)
+		requireSlices(t, shard, builder.Index().Shards().Get(digest))
+	})
+
+	t.Run("raw blob", func(t *testing.T) {
+		blob := make([]byte, 256)
+		_, err := rand.Read(blob)
+		require.NoError(t, err)
+		blocks := []blobindex.Position{{Offset: 100, Length: 50}, {Offset: 10, Length: 90}, {Offset: 200, Length: 10}}
+
+		builder := blobindex.NewBuilder(testutil.RandomCID(t))
+		digest, err := builder.AddBlob(bytes.NewReader(blob), blocks)
+		require.NoError(t, err)
+		require.Equal(t, testutil.Must(mh.Sum(blob, mh.SHA2_256, -1))(t), digest)
+		slices := builder.Index().Shards().Get(digest)
+		require.Equal(t, len(blocks), slices.Size())
+		requireSlices(t, blob, slices)
+
+		_, err = builder.AddBlob(bytes.NewReader(blob), []blobindex.Position{{Offset: 0, Length: 20}, {Offset: 10, Length: 20}})
+		require.Error(t, err)
+		_, err = builder.AddBlob(bytes.NewReader(blob), []blobindex.Position{{Offset: 250, Length: 20}})
+		require.Error(t, err)
+		require.Equal(t, 1, builder.Index().Shards().Size())
+	})
+}
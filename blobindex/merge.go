@@ -0,0 +1,139 @@
+package blobindex
+
+import (
+	"fmt"
+	"strings"
+
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/digestutil"
+)
+
+// MergeConflict is a slice found at different positions of the same shard in
+// the indexes being merged.
+type MergeConflict struct {
+	Shard mh.Multihash
+	Slice mh.Multihash
+	// A is the position of the slice in the first index.
+	A Position
+	// B is the position of the slice in the second index.
+	B Position
+}
+
+// MergeConflictError is returned when indexes cannot be merged because they
+// disagree on the position of slices.
+type MergeConflictError struct {
+	Conflicts []MergeConflict
+}
+
+func (e MergeConflictError) Error() string {
+	descs := make([]string, 0, len(e.Conflicts))
+	for _, c := range e.Conflicts {
+		descs = append(descs, fmt.Sprintf("slice %s in shard %s at %d+%d and %d+%d",
+			digestutil.Format(c.Slice), digestutil.Format(c.Shard), c.A.Offset, c.A.Length, c.B.Offset, c.B.Length))
+	}
+	return fmt.Sprintf("merge conflict: %s", strings.Join(descs, ", "))
+}
+
+// Merge returns an index containing the shards and slices of both indexes,
+// which must pertain to the same content. A slice may be in several shards,
+// but if a slice is at different positions of the same shard in the two
+// indexes a [MergeConflictError] listing every such slice is returned.
+func Merge(a, b ShardedDagIndex) (ShardedDagIndexView, error) {
+	if a.Content().String() != b.Content().String() {
+		return nil, fmt.Errorf("cannot merge indexes of different content: %s and %s", a.Content(), b.Content())
+	}
+	merged := NewShardedDagIndexView(a.Content(), a.Shards().Size())
+	for shard, slices := range a.Shards().Iterator() {
+		copied := NewMultihashMap[Position](slices.Size())
+		for slice, pos := range slices.Iterator() {
+			copied.Set(slice, pos)
+		}
+		merged.Shards().Set(shard, copied)
+	}
+	var conflicts []MergeConflict
+	for shard, slices := range b.Shards().Iterator() {
+		existing := merged.Shards().Get(shard)
+		if existing == nil {
+			existing = NewMultihashMap[Position](slices.Size())
+			merged.Shards().Set(shard, existing)
+		}
+		for slice, pos := range slices.Iterator() {
+			if existing.Has(slice) && existing.Get(slice) != pos {
+				conflicts = append(conflicts, MergeConflict{Shard: shard, Slice: slice, A: existing.Get(slice), B: pos})
+				continue
+			}
+			existing.Set(slice, pos)
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, MergeConflictError{Conflicts: conflicts}
+	}
+	return merged, nil
+}
+
+// IndexDiff describes the changes from one index to another.
+type IndexDiff struct {
+	// Added are the slices in the second index but not in the first. Slices
+	// that moved within a shard are both added and removed.
+	Added ShardedDagIndexView
+	// Removed are the slices in the first index but not in the second.
+	Removed ShardedDagIndexView
+	// AddedShards are the shards only in the second index.
+	AddedShards []mh.Multihash
+	// RemovedShards are the shards only in the first index.
+	RemovedShards []mh.Multihash
+}
+
+// Diff compares index a to index b. The added and removed indexes pertain to
+// the content of b and a respectively.
+func Diff(a, b ShardedDagIndex) IndexDiff {
+	diff := IndexDiff{
+		Added:   difference(b, a),
+		Removed: difference(a, b),
+	}
+	for shard := range b.Shards().Iterator() {
+		if !a.Shards().Has(shard) {
+			diff.AddedShards = append(diff.AddedShards, shard)
+		}
+	}
+	for shard := range a.Shards().Iterator() {
+		if !b.Shards().Has(shard) {
+			diff.RemovedShards = append(diff.RemovedShards, shard)
+		}
+	}
+	return diff
+}
+
+// difference returns an index of the slices in a that are not at the same
+// position of the same shard in b.
+func difference(a, b ShardedDagIndex) ShardedDagIndexView {
+	diff := NewShardedDagIndexView(a.Content(), -1)
+	for shard, slices := range a.Shards().Iterator() {
+		other := b.Shards().Get(shard)
+		for slice, pos := range slices.Iterator() {
+			if other != nil && other.Has(slice) && other.Get(slice) == pos {
+				continue
+			}
+			diff.SetSlice(shard, slice, pos)
+		}
+	}
+	return diff
+}
+
+// Subset returns an index containing only the passed shards of the index. It
+// returns an error if any of the shards is not in the index.
+func Subset(index ShardedDagIndex, shards ...mh.Multihash) (ShardedDagIndexView, error) {
+	subset := NewShardedDagIndexView(index.Content(), len(shards))
+	for _, shard := range shards {
+		slices := index.Shards().Get(shard)
+		if slices == nil {
+			return nil, fmt.Errorf("shard not in index: %s", digestutil.Format(shard))
+		}
+		copied := NewMultihashMap[Position](slices.Size())
+		for slice, pos := range slices.Iterator() {
+			copied.Set(slice, pos)
+		}
+		subset.Shards().Set(shard, copied)
+	}
+	return subset, nil
+}
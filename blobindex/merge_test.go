@@ -0,0 +1,111 @@
+package blobindex_test
+
+import (
+	"testing"
+
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/blobindex"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeDiffSubset(t *testing.T) {
+	content := testutil.RandomCID(t)
+	shards := testutil.RandomMultihashes(t, 3)
+	slices := testutil.RandomMultihashes(t, 4)
+	pos := func(offset uint64) blobindex.Position {
+		return blobindex.Position{Offset: offset, Length: 10}
+	}
+
+	a := blobindex.NewShardedDagIndexView(content, -1)
+	a.SetSlice(shards[0], slices[0], pos(0))
+	a.SetSlice(shards[0], slices[1], pos(10))
+	a.SetSlice(shards[1], slices[2], pos(0))
+
+	b := blobindex.NewShardedDagIndexView(content, -1)
+	b.SetSlice(shards[0], slices[1], pos(10))
+	b.SetSlice(shards[2], slices[2], pos(0))
+	b.SetSlice(shards[2], slices[3], pos(10))
+
+	requireSlices := func(t *testing.T, index blobindex.ShardedDagIndex, expected map[string]map[string]blobindex.Position) {
+		actual := map[string]map[string]blobindex.Position{}
+		for shard, shardSlices := range index.Shards().Iterator() {
+			actual[shard.String()] = map[string]blobindex.Position{}
+			for slice, p := range shardSlices.Iterator() {
+				actual[shard.String()][slice.String()] = p
+			}
+		}
+		require.Equal(t, expected, actual)
+	}
+	key := func(h mh.Multihash) string { return h.String() }
+
+	t.Run("merge", func(t *testing.T) {
+		merged, err := blobindex.Merge(a, b)
+		require.NoError(t, err)
+		requireSlices(t, merged, map[string]map[string]blobindex.Position{
+			key(shards[0]): {key(slices[0]): pos(0), key(slices[1]): pos(10)},
+			key(shards[1]): {key(slices[2]): pos(0)},
+			key(shards[2]): {key(slices[2]): pos(0), key(slices[3]): pos(10)},
+		})
+		// the merged index can be archived and extracted
+		r, err := merged.Archive()
+		require.NoError(t, err)
+		extracted, err := blobindex.Extract(r)
+		require.NoError(t, err)
+		testutil.RequireEqualIndex(t, merged, extracted)
+
+		c := blobindex.NewShardedDagIndexView(content, -1)
+		c.SetSlice(shards[0], slices[1], pos(20))
+		_, err = blobindex.Merge(a, c)
+		var conflictErr blobindex.MergeConflictError
+		require.ErrorAs(t, err, &conflictErr)
+		require.Equal(t, []blobindex.MergeConflict{{Shard: shards[0], Slice: slices[1], A: pos(10), B: pos(20)}}, conflictErr.Conflicts)
+		// empty shards of either index are kept
+		empty := testutil.RandomMultihashes(t, 2)
+		d := blobindex.NewShardedDagIndexView(content, -1)
+		d.Shards().Set(empty[0], blobindex.NewMultihashMap[blobindex.Position](-1))
+		e := blobindex.NewShardedDagIndexView(content, -1)
+		e.Shards().Set(empty[1], blobindex.NewMultihashMap[blobindex.Position](-1))
+		merged, err = blobindex.Merge(d, e)
+		require.NoError(t, err)
+		requireSlices(t, merged, map[string]map[string]blobindex.Position{
+			key(empty[0]): {},
+			key(empty[1]): {},
+		})
+
+		_, err = blobindex.Merge(a, blobindex.NewShardedDagIndexView(testutil.RandomCID(t), -1))
+		require.ErrorContains(t, err, "different content")
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		diff := blobindex.Diff(a, b)
+		requireSlices(t, diff.Added, map[string]map[string]blobindex.Position{
+			key(shards[2]): {key(slices[2]): pos(0), key(slices[3]): pos(10)},
+		})
+		requireSlices(t, diff.Removed, map[string]map[string]blobindex.Position{
+			key(shards[0]): {key(slices[0]): pos(0)},
+			key(shards[1]): {key(slices[2]): pos(0)},
+		})
+		require.Equal(t, []mh.Multihash{shards[2]}, diff.AddedShards)
+		require.Equal(t, []mh.Multihash{shards[1]}, diff.RemovedShards)
+
+		diff = blobindex.Diff(a, a)
+		require.Zero(t, diff.Added.Shards().Size())
+		require.Zero(t, diff.Removed.Shards().Size())
+	})
+
+	t.Run("subset", func(t *testing.T) {
+		subset, err := blobindex.Subset(a, shards[1])
+		require.NoError(t, err)
+		require.Equal(t, content, subset.Content())
+		requireSlices(t, subset, map[string]map[string]blobindex.Position{
+			key(shards[1]): {key(slices[2]): pos(0)},
+		})
+		// changes to the subset do not affect the index
+		subset.SetSlice(shards[1], slices[3], pos(10))
+		require.False(t, a.Shards().Get(shards[1]).Has(slices[3]))
+
+		_, err = blobindex.Subset(a, shards[2])
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,138 @@
+package blobindex
+
+import (
+	"bytes"
+	"cmp"
+	"iter"
+	"slices"
+
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Slice is a slice of a shard, as described by a sharded DAG index.
+type Slice struct {
+	Shard    mh.Multihash
+	Slice    mh.Multihash
+	Position Position
+}
+
+// End returns the offset of the byte following the slice.
+func (s Slice) End() uint64 {
+	return s.Position.Offset + s.Position.Length
+}
+
+// Overlap is a pair of slices of the same shard that share bytes.
+type Overlap struct {
+	// First is the slice starting first.
+	First Slice
+	// Second is the slice starting within First.
+	Second Slice
+}
+
+// Coverage describes how the slices in an index cover a shard.
+type Coverage struct {
+	// Gaps are the byte ranges of the shard not covered by any slice, in byte
+	// order.
+	Gaps []Position
+	// Overlaps are the slices sharing bytes with a slice starting before them,
+	// in byte order. Each slice is reported once, with the earlier slice that
+	// extends furthest.
+	Overlaps []Overlap
+}
+
+// SliceIndex answers queries on a sharded DAG index that require a full scan
+// of [ShardedDagIndex.Shards], such as finding the shards containing a slice.
+// It is a snapshot: changes to the index after it is built are not reflected.
+type SliceIndex struct {
+	bySlice MultihashMap[[]Slice]
+	byShard MultihashMap[[]Slice]
+}
+
+// NewSliceIndex builds a slice index for the passed sharded DAG index.
+func NewSliceIndex(index ShardedDagIndex) *SliceIndex {
+	si := &SliceIndex{
+		bySlice: NewMultihashMap[[]Slice](-1),
+		byShard: NewMultihashMap[[]Slice](index.Shards().Size()),
+	}
+	for shard, positions := range index.Shards().Iterator() {
+		shardSlices := make([]Slice, 0, positions.Size())
+		for slice, pos := range positions.Iterator() {
+			s := Slice{Shard: shard, Slice: slice, Position: pos}
+			shardSlices = append(shardSlices, s)
+			si.bySlice.Set(slice, append(si.bySlice.Get(slice), s))
+		}
+		slices.SortFunc(shardSlices, compareSlices)
+		si.byShard.Set(shard, shardSlices)
+	}
+	for slice, locations := range si.bySlice.Iterator() {
+		slices.SortFunc(locations, func(a, b Slice) int {
+			return bytes.Compare(a.Shard, b.Shard)
+		})
+		si.bySlice.Set(slice, locations)
+	}
+	return si
+}
+
+// compareSlices orders slices by offset, then length, then multihash.
+func compareSlices(a, b Slice) int {
+	return cmp.Or(
+		cmp.Compare(a.Position.Offset, b.Position.Offset),
+		cmp.Compare(a.Position.Length, b.Position.Length),
+		bytes.Compare(a.Slice, b.Slice),
+	)
+}
+
+// Lookup returns the locations of the slice in the index, ordered by shard
+// multihash. A slice is usually in a single shard, but may be repeated.
+func (si *SliceIndex) Lookup(slice mh.Multihash) []Slice {
+	return slices.Clone(si.bySlice.Get(slice))
+}
+
+// Blocks iterates over the slices of the shard in byte order.
+func (si *SliceIndex) Blocks(shard mh.Multihash) iter.Seq[Slice] {
+	return slices.Values(si.byShard.Get(shard))
+}
+
+// Range returns the slices of the shard overlapping the byte range, in byte
+// order.
+func (si *SliceIndex) Range(shard mh.Multihash, pos Position) []Slice {
+	end := pos.Offset + pos.Length
+	var overlapping []Slice
+	for _, s := range si.byShard.Get(shard) {
+		if s.Position.Offset >= end {
+			break
+		}
+		if s.End() > pos.Offset && s.Position.Length > 0 {
+			overlapping = append(overlapping, s)
+		}
+	}
+	return overlapping
+}
+
+// Coverage checks how the slices of the shard cover its bytes. The size of
+// the shard is used to report a gap at the end of the shard. Pass zero if it
+// is not known.
+func (si *SliceIndex) Coverage(shard mh.Multihash, size uint64) Coverage {
+	var cov Coverage
+	var furthest *Slice
+	var covered uint64
+	for _, s := range si.byShard.Get(shard) {
+		if s.Position.Length == 0 {
+			continue
+		}
+		if s.Position.Offset > covered {
+			cov.Gaps = append(cov.Gaps, Position{Offset: covered, Length: s.Position.Offset - covered})
+		}
+		if furthest != nil && s.Position.Offset < furthest.End() {
+			cov.Overlaps = append(cov.Overlaps, Overlap{First: *furthest, Second: s})
+		}
+		if furthest == nil || s.End() > furthest.End() {
+			furthest = &s
+			covered = s.End()
+		}
+	}
+	if size > covered {
+		cov.Gaps = append(cov.Gaps, Position{Offset: covered, Length: size - covered})
+	}
+	return cov
+}
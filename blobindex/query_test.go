@@ -0,0 +1,60 @@
+package blobindex_test
+
+import (
+	"slices"
+	"testing"
+
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/blobindex"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSliceIndex(t *testing.T) {
+	shards := testutil.RandomMultihashes(t, 2)
+	hashes := testutil.RandomMultihashes(t, 4)
+	positions := []blobindex.Position{
+		{Offset: 0, Length: 10},
+		{Offset: 12, Length: 8},
+		{Offset: 20, Length: 10},
+		{Offset: 25, Length: 10},
+	}
+	index := blobindex.NewShardedDagIndexView(testutil.RandomCID(t), 2)
+	// add out of byte order
+	for _, i := range []int{2, 0, 3, 1} {
+		index.SetSlice(shards[0], hashes[i], positions[i])
+	}
+	index.SetSlice(shards[1], hashes[0], blobindex.Position{Offset: 5, Length: 10})
+	si := blobindex.NewSliceIndex(index)
+
+	slice := func(shard mh.Multihash, i int) blobindex.Slice {
+		return blobindex.Slice{Shard: shard, Slice: hashes[i], Position: positions[i]}
+	}
+
+	t.Run("lookup", func(t *testing.T) {
+		require.Equal(t, []blobindex.Slice{slice(shards[0], 1)}, si.Lookup(hashes[1]))
+		require.Len(t, si.Lookup(hashes[0]), 2)
+		require.Empty(t, si.Lookup(testutil.RandomMultihash(t)))
+	})
+
+	t.Run("blocks", func(t *testing.T) {
+		blocks := slices.Collect(si.Blocks(shards[0]))
+		require.Equal(t, []blobindex.Slice{slice(shards[0], 0), slice(shards[0], 1), slice(shards[0], 2), slice(shards[0], 3)}, blocks)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		require.Equal(t, []blobindex.Slice{slice(shards[0], 1), slice(shards[0], 2)}, si.Range(shards[0], blobindex.Position{Offset: 18, Length: 4}))
+		require.Equal(t, []blobindex.Slice{slice(shards[0], 0)}, si.Range(shards[0], blobindex.Position{Offset: 9, Length: 3}))
+		require.Empty(t, si.Range(shards[0], blobindex.Position{Offset: 10, Length: 2}))
+	})
+
+	t.Run("coverage", func(t *testing.T) {
+		cov := si.Coverage(shards[0], 40)
+		require.Equal(t, []blobindex.Position{{Offset: 10, Length: 2}, {Offset: 35, Length: 5}}, cov.Gaps)
+		require.Equal(t, []blobindex.Overlap{{First: slice(shards[0], 2), Second: slice(shards[0], 3)}}, cov.Overlaps)
+
+		cov = si.Coverage(shards[1], 0)
+		require.Equal(t, []blobindex.Position{{Offset: 0, Length: 5}}, cov.Gaps)
+		require.Empty(t, cov.Overlaps)
+	})
+}
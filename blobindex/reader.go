@@ -0,0 +1,283 @@
+package blobindex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	mh "github.com/multiformats/go-multihash"
+	dm "github.com/storacha/go-libstoracha/blobindex/datamodel"
+	"github.com/storacha/go-ucanto/core/ipld"
+	"github.com/storacha/go-ucanto/core/ipld/codec/cbor"
+)
+
+// DefaultReaderCacheSize is the default number of decoded blob index shard
+// blocks kept in memory by a [Reader].
+const DefaultReaderCacheSize = 16
+
+// ErrSliceNotFound is returned when a slice is not in the index.
+var ErrSliceNotFound = errors.New("slice not found in index")
+
+// BlockGetter provides random access to the blocks of an index, for example a
+// CAR blockstore.
+type BlockGetter interface {
+	Get(ctx context.Context, c cid.Cid) (blocks.Block, error)
+}
+
+// ReaderOption is an option configuring a [Reader].
+type ReaderOption func(cfg *readerConfig)
+
+type readerConfig struct {
+	cacheSize   int
+	lookupIndex bool
+}
+
+// WithCacheSize configures the number of decoded blob index shard blocks kept
+// in memory. If not configured, or not a positive number,
+// [DefaultReaderCacheSize] is used.
+func WithCacheSize(size int) ReaderOption {
+	return func(cfg *readerConfig) {
+		cfg.cacheSize = size
+	}
+}
+
+// WithLookupIndex configures the reader to index the shard of every slice on
+// the first lookup, reading each blob index block once, so that later lookups
+// read only the block of the shard containing the slice. The index keeps a
+// 64-bit hash of each slice in memory, and is worthwhile when there are more
+// shards than the cache holds and many lookups are made.
+func WithLookupIndex() ReaderOption {
+	return func(cfg *readerConfig) {
+		cfg.lookupIndex = true
+	}
+}
+
+// Reader reads a sharded DAG index lazily, loading the blob index block for a
+// shard only when it is needed to answer a lookup.
+type Reader struct {
+	blocks  BlockGetter
+	content ipld.Link
+	shards  []ipld.Link
+	cache   *lru.Cache[string, readerShard]
+
+	lookupIndex bool
+	indexMutex  sync.Mutex
+	index       *sliceIndex
+}
+
+// sliceIndex maps hashes of slices to the indexes of the shards containing
+// them. Slices whose hash is shared with a slice in another shard, or that are
+// in more than one shard, have their further shards in more.
+type sliceIndex struct {
+	seed  maphash.Seed
+	first map[uint64]int
+	more  map[uint64][]int
+}
+
+func (idx *sliceIndex) add(slice mh.Multihash, shard int) {
+	h := maphash.Bytes(idx.seed, slice)
+	first, ok := idx.first[h]
+	if !ok {
+		idx.first[h] = shard
+		return
+	}
+	more := idx.more[h]
+	if first != shard && (len(more) == 0 || more[len(more)-1] != shard) {
+		idx.more[h] = append(more, shard)
+	}
+}
+
+// shards returns the indexes of the shards that may contain the slice, in
+// order.
+func (idx *sliceIndex) shards(slice mh.Multihash) []int {
+	h := maphash.Bytes(idx.seed, slice)
+	first, ok := idx.first[h]
+	if !ok {
+		return nil
+	}
+	return append([]int{first}, idx.more[h]...)
+}
+
+type readerShard struct {
+	shard  mh.Multihash
+	slices MultihashMap[Position]
+}
+
+// NewReader creates a reader for the sharded DAG index with the passed root,
+// reading blocks from the block getter. Only the root block is read.
+func NewReader(ctx context.Context, root ipld.Link, blocks BlockGetter, opts ...ReaderOption) (*Reader, error) {
+	cfg := readerConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.cacheSize <= 0 {
+		cfg.cacheSize = DefaultReaderCacheSize
+	}
+	rootCID := root.(cidlink.Link).Cid
+	if rootCID.Prefix().Codec != cid.DagCBOR {
+		return nil, NewUnknownFormatError(fmt.Errorf("unexpected root CID codec: %x", rootCID.Prefix().Codec))
+	}
+	rootBlock, err := blocks.Get(ctx, rootCID)
+	if err != nil {
+		return nil, NewDecodeFailureError(fmt.Errorf("missing root block: %s: %w", root, err))
+	}
+	var shardedDagIndexData dm.ShardedDagIndexModel
+	err = cbor.Decode(rootBlock.RawData(), &shardedDagIndexData, dm.ShardedDagIndexSchema())
+	if err != nil {
+		return nil, NewDecodeFailureError(err)
+	}
+	if shardedDagIndexData.DagO_1 == nil {
+		return nil, NewUnknownFormatError(fmt.Errorf("unknown index version"))
+	}
+	cache, err := lru.New[string, readerShard](cfg.cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		blocks:  blocks,
+		content: shardedDagIndexData.DagO_1.Content,
+		shards:  shardedDagIndexData.DagO_1.Shards,
+		cache:   cache,
+
+		lookupIndex: cfg.lookupIndex,
+	}, nil
+}
+
+// OpenReader creates a reader for a sharded DAG index archived in a CARv1 or
+// CARv2 file. CARv2 files are read using their index, while CARv1 files are
+// indexed when opened, without decoding their blocks.
+func OpenReader(ctx context.Context, r io.ReaderAt, opts ...ReaderOption) (*Reader, error) {
+	bs, err := carblockstore.NewReadOnly(r, nil)
+	if err != nil {
+		return nil, NewUnknownFormatError(err)
+	}
+	roots, err := bs.Roots()
+	if err != nil {
+		return nil, NewUnknownFormatError(err)
+	}
+	if len(roots) == 0 {
+		return nil, NewUnknownFormatError(errors.New("missing root block"))
+	}
+	return NewReader(ctx, cidlink.Link{Cid: roots[0]}, bs, opts...)
+}
+
+// Content returns the DAG root CID that the index pertains to.
+func (r *Reader) Content() ipld.Link {
+	return r.content
+}
+
+// Len returns the number of shards in the index.
+func (r *Reader) Len() int {
+	return len(r.shards)
+}
+
+// Shard returns the multihash of the i-th shard and the positions of the
+// slices within it. The positions are a copy, which the caller may modify.
+func (r *Reader) Shard(ctx context.Context, i int) (mh.Multihash, MultihashMap[Position], error) {
+	if i < 0 || i >= len(r.shards) {
+		return nil, nil, fmt.Errorf("shard index out of range: %d", i)
+	}
+	s, err := r.load(ctx, r.shards[i])
+	if err != nil {
+		return nil, nil, err
+	}
+	slices := NewMultihashMap[Position](s.slices.Size())
+	for slice, pos := range s.slices.Iterator() {
+		slices.Set(slice, pos)
+	}
+	return s.shard, slices, nil
+}
+
+// Lookup finds the shard containing the slice and its position within the
+// shard. It returns [ErrSliceNotFound] if the slice is not in the index.
+//
+// Blob index blocks are loaded in order until the slice is found, so lookups
+// of slices in the first shards read the fewest blocks. When the index has
+// more shards than the cache holds, a lookup may read and decode every block,
+// which is O(total slices). Configure [WithLookupIndex] to index the shard of
+// every slice on the first lookup instead.
+func (r *Reader) Lookup(ctx context.Context, slice mh.Multihash) (mh.Multihash, Position, error) {
+	shards, err := r.candidates(ctx, slice)
+	if err != nil {
+		return nil, Position{}, err
+	}
+	for _, i := range shards {
+		s, err := r.load(ctx, r.shards[i])
+		if err != nil {
+			return nil, Position{}, err
+		}
+		if s.slices.Has(slice) {
+			return s.shard, s.slices.Get(slice), nil
+		}
+	}
+	return nil, Position{}, ErrSliceNotFound
+}
+
+// candidates returns the indexes of the shards that may contain the slice, in
+// order: those found in the lookup index if configured, or else every shard.
+func (r *Reader) candidates(ctx context.Context, slice mh.Multihash) ([]int, error) {
+	if !r.lookupIndex {
+		shards := make([]int, len(r.shards))
+		for i := range shards {
+			shards[i] = i
+		}
+		return shards, nil
+	}
+	idx, err := r.sliceIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return idx.shards(slice), nil
+}
+
+// sliceIndex returns the lookup index, building it if this is the first
+// lookup. If building fails it is retried on the next lookup.
+func (r *Reader) sliceIndex(ctx context.Context) (*sliceIndex, error) {
+	r.indexMutex.Lock()
+	defer r.indexMutex.Unlock()
+	if r.index != nil {
+		return r.index, nil
+	}
+	idx := &sliceIndex{seed: maphash.MakeSeed(), first: map[uint64]int{}, more: map[uint64][]int{}}
+	for i, link := range r.shards {
+		s, err := r.load(ctx, link)
+		if err != nil {
+			return nil, err
+		}
+		for slice := range s.slices.Iterator() {
+			idx.add(slice, i)
+		}
+	}
+	r.index = idx
+	return idx, nil
+}
+
+func (r *Reader) load(ctx context.Context, link ipld.Link) (readerShard, error) {
+	if s, ok := r.cache.Get(link.Binary()); ok {
+		return s, nil
+	}
+	blk, err := r.blocks.Get(ctx, link.(cidlink.Link).Cid)
+	if err != nil {
+		return readerShard{}, NewDecodeFailureError(fmt.Errorf("missing shard block: %s: %w", link, err))
+	}
+	var blobIndexData dm.BlobIndexModel
+	if err := blobIndexData.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+		return readerShard{}, NewDecodeFailureError(err)
+	}
+	slices := NewMultihashMap[Position](len(blobIndexData.Slices))
+	for _, blobSlice := range blobIndexData.Slices {
+		slices.Set(blobSlice.Multihash, blobSlice.Position)
+	}
+	s := readerShard{shard: blobIndexData.Multihash, slices: slices}
+	r.cache.Add(link.Binary(), s)
+	return s, nil
+}
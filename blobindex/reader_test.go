@@ -0,0 +1,121 @@
+package blobindex_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	carblockstore "github.com/ipld/go-car/v2/blockstore"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/storacha/go-libstoracha/blobindex"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	root, _, shard0 := testutil.RandomCAR(t, 32)
+	_, _, shard1 := testutil.RandomCAR(t, 32)
+	index, err := blobindex.FromShardArchives(root, [][]byte{shard0, shard1})
+	require.NoError(t, err)
+	r, err := index.Archive()
+	require.NoError(t, err)
+	v1, err := io.ReadAll(r)
+	require.NoError(t, err)
+	var v2 bytes.Buffer
+	require.NoError(t, carv2.WrapV1(bytes.NewReader(v1), &v2))
+
+	for name, archive := range map[string][]byte{"CARv1": v1, "CARv2": v2.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			reader, err := blobindex.OpenReader(context.Background(), bytes.NewReader(archive), blobindex.WithCacheSize(1))
+			require.NoError(t, err)
+			require.Equal(t, root.String(), reader.Content().String())
+			require.Equal(t, index.Shards().Size(), reader.Len())
+
+			for shard, slices := range index.Shards().Iterator() {
+				for slice, pos := range slices.Iterator() {
+					s, p, err := reader.Lookup(context.Background(), slice)
+					require.NoError(t, err)
+					require.Equal(t, shard, s)
+					require.Equal(t, pos, p)
+				}
+			}
+
+			_, _, err = reader.Lookup(context.Background(), testutil.RandomMultihash(t))
+			require.ErrorIs(t, err, blobindex.ErrSliceNotFound)
+		})
+	}
+}
+
+type countingGetter struct {
+	blobindex.BlockGetter
+	gets int
+}
+
+func (g *countingGetter) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	g.gets++
+	return g.BlockGetter.Get(ctx, c)
+}
+
+func TestReaderLookupIndex(t *testing.T) {
+	root, _, _ := testutil.RandomCAR(t, 32)
+	var archives [][]byte
+	for range 4 {
+		_, _, shard := testutil.RandomCAR(t, 32)
+		archives = append(archives, shard)
+	}
+	index, err := blobindex.FromShardArchives(root, archives)
+	require.NoError(t, err)
+	r, err := index.Archive()
+	require.NoError(t, err)
+	archive, err := io.ReadAll(r)
+	require.NoError(t, err)
+	bs, err := carblockstore.NewReadOnly(bytes.NewReader(archive), nil)
+	require.NoError(t, err)
+	roots, err := bs.Roots()
+	require.NoError(t, err)
+
+	t.Run("lookups read one block", func(t *testing.T) {
+		getter := &countingGetter{BlockGetter: bs}
+		reader, err := blobindex.NewReader(context.Background(), cidlink.Link{Cid: roots[0]}, getter, blobindex.WithCacheSize(1), blobindex.WithLookupIndex())
+		require.NoError(t, err)
+
+		_, _, err = reader.Lookup(context.Background(), testutil.RandomMultihash(t))
+		require.ErrorIs(t, err, blobindex.ErrSliceNotFound)
+		// the root block and every blob index block
+		require.Equal(t, 1+index.Shards().Size(), getter.gets)
+
+		for shard, slices := range index.Shards().Iterator() {
+			for slice, pos := range slices.Iterator() {
+				gets := getter.gets
+				s, p, err := reader.Lookup(context.Background(), slice)
+				require.NoError(t, err)
+				require.Equal(t, shard, s)
+				require.Equal(t, pos, p)
+				require.LessOrEqual(t, getter.gets-gets, 1)
+			}
+		}
+	})
+
+	t.Run("shard is a copy", func(t *testing.T) {
+		reader, err := blobindex.NewReader(context.Background(), cidlink.Link{Cid: roots[0]}, bs)
+		require.NoError(t, err)
+		_, slices, err := reader.Shard(context.Background(), 0)
+		require.NoError(t, err)
+		size := slices.Size()
+		for slice := range slices.Iterator() {
+			slices.Delete(slice)
+		}
+
+		_, slices, err = reader.Shard(context.Background(), 0)
+		require.NoError(t, err)
+		require.Equal(t, size, slices.Size())
+		for slice := range slices.Iterator() {
+			_, _, err := reader.Lookup(context.Background(), slice)
+			require.NoError(t, err)
+		}
+	})
+}
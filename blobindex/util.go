@@ -10,6 +10,7 @@ import (
 )
 
 // FromShardArchives creates a sharded DAG index by indexing blocks in the passed CAR shards.
+// Use a [Builder] to index shards streamed from readers instead.
 func FromShardArchives(content ipld.Link, shards [][]byte) (ShardedDagIndexView, error) {
 	index := NewShardedDagIndexView(content, len(shards))
 	for _, s := range shards {
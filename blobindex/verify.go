@@ -0,0 +1,253 @@
+package blobindex
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/digestutil"
+)
+
+// ShardReader provides random access to the bytes of a shard, for example a
+// [bytes.Reader] or an [io.SectionReader] wrapped with [NopShardCloser].
+type ShardReader interface {
+	io.ReaderAt
+	io.Closer
+	// Size returns the size of the shard in bytes.
+	Size() int64
+}
+
+// ShardOpener opens a shard of an index for reading. The reader is closed once
+// the shard has been verified.
+type ShardOpener func(ctx context.Context, shard mh.Multihash) (ShardReader, error)
+
+type sizedReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+type nopShardCloser struct {
+	sizedReaderAt
+}
+
+func (nopShardCloser) Close() error { return nil }
+
+// NopShardCloser returns a ShardReader with a no-op Close method wrapping r,
+// for readers that hold no resources, such as a [bytes.Reader].
+func NopShardCloser(r interface {
+	io.ReaderAt
+	Size() int64
+}) ShardReader {
+	return nopShardCloser{r}
+}
+
+// MismatchKind identifies the type of mismatch found when verifying an index
+// against the bytes of its shards.
+type MismatchKind string
+
+const (
+	// MismatchShardUnavailable indicates a shard could not be opened.
+	MismatchShardUnavailable MismatchKind = "shard-unavailable"
+	// MismatchShardDigest indicates the bytes of a shard do not hash to the
+	// shard multihash.
+	MismatchShardDigest MismatchKind = "shard-digest"
+	// MismatchOutOfRange indicates the position of a slice extends beyond the
+	// end of the shard.
+	MismatchOutOfRange MismatchKind = "out-of-range"
+	// MismatchSliceDigest indicates the bytes at the position of a slice do not
+	// hash to the slice multihash.
+	MismatchSliceDigest MismatchKind = "slice-digest"
+	// MismatchReadError indicates the bytes of a shard could not be read or
+	// hashed.
+	MismatchReadError MismatchKind = "read-error"
+)
+
+// Mismatch is a difference found between an index and the bytes of a shard.
+type Mismatch struct {
+	Kind  MismatchKind
+	Shard mh.Multihash
+	// Slice and Position identify the slice the mismatch relates to. Slice is
+	// nil for mismatches about the whole shard.
+	Slice    mh.Multihash
+	Position Position
+	// Actual is the multihash of the bytes read, for digest mismatches.
+	Actual mh.Multihash
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (m Mismatch) String() string {
+	s := fmt.Sprintf("%s shard=%s", m.Kind, digestutil.Format(m.Shard))
+	if m.Slice != nil {
+		s += fmt.Sprintf(" slice=%s position=%d+%d", digestutil.Format(m.Slice), m.Position.Offset, m.Position.Length)
+	}
+	if m.Actual != nil {
+		s += fmt.Sprintf(" actual=%s", digestutil.Format(m.Actual))
+	}
+	if m.Err != nil {
+		s += fmt.Sprintf(": %s", m.Err)
+	}
+	return s
+}
+
+// IndexVerifyReport is the result of verifying an index against the bytes of
+// its shards.
+type IndexVerifyReport struct {
+	// Shards is the number of shards checked.
+	Shards int
+	// Slices is the number of slices checked, which is less than the number of
+	// slices in the index when sampling.
+	Slices int
+	// Mismatches are the mismatches found, ordered by shard and by position
+	// within each shard.
+	Mismatches []Mismatch
+}
+
+// OK returns true if no mismatches were found.
+func (r IndexVerifyReport) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// IndexVerifyOption is an option configuring index verification.
+type IndexVerifyOption func(cfg *indexVerifyConfig)
+
+type indexVerifyConfig struct {
+	sampleSize      int
+	skipShardDigest bool
+	rand            *rand.Rand
+}
+
+// WithSampleSize checks at most n slices of each shard, chosen at random,
+// instead of every slice. A size of zero or less checks every slice.
+func WithSampleSize(n int) IndexVerifyOption {
+	return func(cfg *indexVerifyConfig) {
+		cfg.sampleSize = n
+	}
+}
+
+// WithSampleSource configures the source of randomness used to choose the
+// slices checked when sampling, so that samples can be reproduced.
+func WithSampleSource(src rand.Source) IndexVerifyOption {
+	return func(cfg *indexVerifyConfig) {
+		cfg.rand = rand.New(src)
+	}
+}
+
+// WithoutShardDigest skips hashing whole shards, which requires reading every
+// byte of every shard.
+func WithoutShardDigest() IndexVerifyOption {
+	return func(cfg *indexVerifyConfig) {
+		cfg.skipShardDigest = true
+	}
+}
+
+// VerifyIndex checks that the index is truthful: that each position of a shard
+// contains a block hashing to the slice multihash, and that the bytes of each
+// shard hash to the shard multihash. Slices and shards are hashed with the
+// hash functions of their multihashes. Shards are checked in multihash order.
+//
+// Mismatches are reported rather than returned as errors. An error is only
+// returned if the context is canceled.
+func VerifyIndex(ctx context.Context, index ShardedDagIndex, open ShardOpener, opts ...IndexVerifyOption) (IndexVerifyReport, error) {
+	cfg := indexVerifyConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.rand == nil {
+		cfg.rand = rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+
+	shards := make([]mh.Multihash, 0, index.Shards().Size())
+	for shard := range index.Shards().Iterator() {
+		shards = append(shards, shard)
+	}
+	slices.SortFunc(shards, func(a, b mh.Multihash) int { return bytes.Compare(a, b) })
+
+	var report IndexVerifyReport
+	for _, shard := range shards {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Shards++
+		r, err := open(ctx, shard)
+		if err != nil {
+			report.Mismatches = append(report.Mismatches, Mismatch{Kind: MismatchShardUnavailable, Shard: shard, Err: err})
+			continue
+		}
+		err = cfg.verifyShard(ctx, &report, shard, r, index.Shards().Get(shard))
+		r.Close()
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// verifyShard adds the mismatches found in a shard to the report.
+func (cfg *indexVerifyConfig) verifyShard(ctx context.Context, report *IndexVerifyReport, shard mh.Multihash, r ShardReader, positions MultihashMap[Position]) error {
+	if !cfg.skipShardDigest {
+		if m, ok := verifyDigest(io.NewSectionReader(r, 0, r.Size()), shard); !ok {
+			m.Kind = cmp.Or(m.Kind, MismatchShardDigest)
+			m.Shard = shard
+			report.Mismatches = append(report.Mismatches, m)
+		}
+	}
+	for _, s := range cfg.sample(positions) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		report.Slices++
+		m := Mismatch{Shard: shard, Slice: s.Slice, Position: s.Position}
+		if s.End() > uint64(r.Size()) || s.End() < s.Position.Offset {
+			m.Kind = MismatchOutOfRange
+			report.Mismatches = append(report.Mismatches, m)
+			continue
+		}
+		if sm, ok := verifyDigest(io.NewSectionReader(r, int64(s.Position.Offset), int64(s.Position.Length)), s.Slice); !ok {
+			m.Kind = cmp.Or(sm.Kind, MismatchSliceDigest)
+			m.Actual = sm.Actual
+			m.Err = sm.Err
+			report.Mismatches = append(report.Mismatches, m)
+		}
+	}
+	return nil
+}
+
+// sample returns the slices to check in byte order.
+func (cfg *indexVerifyConfig) sample(positions MultihashMap[Position]) []Slice {
+	all := make([]Slice, 0, positions.Size())
+	for slice, pos := range positions.Iterator() {
+		all = append(all, Slice{Slice: slice, Position: pos})
+	}
+	if cfg.sampleSize > 0 && cfg.sampleSize < len(all) {
+		// sort first so that samples only depend on the source of randomness
+		slices.SortFunc(all, compareSlices)
+		cfg.rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+		all = all[:cfg.sampleSize]
+	}
+	slices.SortFunc(all, compareSlices)
+	return all
+}
+
+// verifyDigest hashes the bytes read from r with the hash function of the
+// expected multihash. It returns a mismatch without a kind if they differ, or
+// a read error mismatch if they could not be hashed.
+func verifyDigest(r io.Reader, expected mh.Multihash) (Mismatch, bool) {
+	decoded, err := mh.Decode(expected)
+	if err != nil {
+		return Mismatch{Kind: MismatchReadError, Err: err}, false
+	}
+	actual, err := mh.SumStream(r, decoded.Code, decoded.Length)
+	if err != nil {
+		return Mismatch{Kind: MismatchReadError, Err: err}, false
+	}
+	if !bytes.Equal(actual, expected) {
+		return Mismatch{Actual: actual}, false
+	}
+	return Mismatch{}, true
+}
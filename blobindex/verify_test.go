@@ -0,0 +1,156 @@
+package blobindex_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/blobindex"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyIndex(t *testing.T) {
+	blob := make([]byte, 1000)
+	_, err := rand.Read(blob)
+	require.NoError(t, err)
+	var blocks []blobindex.Position
+	for i := range 10 {
+		blocks = append(blocks, blobindex.Position{Offset: uint64(i * 100), Length: 100})
+	}
+	root, _, car := testutil.RandomCAR(t, 128)
+	builder := blobindex.NewBuilder(root)
+	blobDigest, err := builder.AddBlob(bytes.NewReader(blob), blocks)
+	require.NoError(t, err)
+	carDigest, err := builder.AddCAR(bytes.NewReader(car))
+	require.NoError(t, err)
+	index := builder.Index()
+
+	opener := func(shards map[string][]byte) blobindex.ShardOpener {
+		return func(ctx context.Context, shard mh.Multihash) (blobindex.ShardReader, error) {
+			b, ok := shards[string(shard)]
+			if !ok {
+				return nil, errors.New("not found")
+			}
+			return blobindex.NopShardCloser(bytes.NewReader(b)), nil
+		}
+	}
+	kinds := func(r blobindex.IndexVerifyReport) []blobindex.MismatchKind {
+		var ks []blobindex.MismatchKind
+		for _, m := range r.Mismatches {
+			ks = append(ks, m.Kind)
+		}
+		return ks
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		report, err := blobindex.VerifyIndex(context.Background(), index, opener(map[string][]byte{
+			string(blobDigest): blob,
+			string(carDigest):  car,
+		}))
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Mismatches)
+		require.Equal(t, 2, report.Shards)
+		require.Equal(t, 10+index.Shards().Get(carDigest).Size(), report.Slices)
+	})
+
+	t.Run("mismatches", func(t *testing.T) {
+		corrupt := bytes.Clone(blob)
+		corrupt[250] ^= 0xff
+		index := blobindex.NewShardedDagIndexView(root, -1)
+		for slice, pos := range builder.Index().Shards().Get(blobDigest).Iterator() {
+			index.SetSlice(blobDigest, slice, pos)
+		}
+		outOfRange := testutil.RandomMultihash(t)
+		index.SetSlice(blobDigest, outOfRange, blobindex.Position{Offset: 950, Length: 100})
+		index.SetSlice(carDigest, testutil.RandomMultihash(t), blobindex.Position{Offset: 0, Length: 1})
+
+		report, err := blobindex.VerifyIndex(context.Background(), index, opener(map[string][]byte{
+			string(blobDigest): corrupt,
+		}))
+		require.NoError(t, err)
+		byShard := map[string][]blobindex.MismatchKind{}
+		for _, m := range report.Mismatches {
+			byShard[string(m.Shard)] = append(byShard[string(m.Shard)], m.Kind)
+		}
+		require.Equal(t, []blobindex.MismatchKind{
+			blobindex.MismatchShardDigest,
+			blobindex.MismatchSliceDigest,
+			blobindex.MismatchOutOfRange,
+		}, byShard[string(blobDigest)])
+		require.Equal(t, []blobindex.MismatchKind{blobindex.MismatchShardUnavailable}, byShard[string(carDigest)])
+		for _, m := range report.Mismatches {
+			if m.Kind == blobindex.MismatchSliceDigest {
+				require.Equal(t, blocks[2], m.Position)
+				require.NotNil(t, m.Actual)
+			}
+		}
+
+		report, err = blobindex.VerifyIndex(context.Background(), index, opener(map[string][]byte{
+			string(blobDigest): corrupt,
+		}), blobindex.WithoutShardDigest())
+		require.NoError(t, err)
+		require.NotContains(t, kinds(report), blobindex.MismatchShardDigest)
+	})
+
+	t.Run("sampling", func(t *testing.T) {
+		report, err := blobindex.VerifyIndex(context.Background(), index, opener(map[string][]byte{
+			string(blobDigest): blob,
+			string(carDigest):  car,
+		}), blobindex.WithSampleSize(3))
+		require.NoError(t, err)
+		require.True(t, report.OK())
+		require.Equal(t, 3+min(3, index.Shards().Get(carDigest).Size()), report.Slices)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := blobindex.VerifyIndex(ctx, index, opener(nil))
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("closes readers", func(t *testing.T) {
+		shards := map[string][]byte{
+			string(blobDigest): blob,
+			string(carDigest):  car,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var opened, closed int
+		open := func(cancelOnOpen bool) blobindex.ShardOpener {
+			return func(ctx context.Context, shard mh.Multihash) (blobindex.ShardReader, error) {
+				opened++
+				if cancelOnOpen {
+					cancel()
+				}
+				return &closeCounter{blobindex.NopShardCloser(bytes.NewReader(shards[string(shard)])), &closed}, nil
+			}
+		}
+
+		report, err := blobindex.VerifyIndex(ctx, index, open(false))
+		require.NoError(t, err)
+		require.True(t, report.OK())
+		require.Equal(t, 2, opened)
+		require.Equal(t, 2, closed)
+
+		// the reader is closed when verification stops part way through a shard
+		_, err = blobindex.VerifyIndex(ctx, index, open(true))
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, 3, opened)
+		require.Equal(t, 3, closed)
+	})
+}
+
+type closeCounter struct {
+	blobindex.ShardReader
+	closed *int
+}
+
+func (c *closeCounter) Close() error {
+	*c.closed++
+	return c.ShardReader.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"path"
 	"strings"
 	"sync"
@@ -119,6 +120,52 @@ func (s *S3Bucket) Put(ctx context.Context, k ds.Key, value []byte) error {
 	return err
 }
 
+// Replace atomically writes value to k only when the current value equals old,
+// or when old is nil and k does not exist, using S3 conditional writes. It
+// returns false when the current value does not match, including when a
+// concurrent conditional write to k wins.
+func (s *S3Bucket) Replace(ctx context.Context, k ds.Key, old, value []byte) (bool, error) {
+	req, _ := s.S3.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.s3Path(k.String())),
+		Body:   bytes.NewReader(value),
+	})
+	req.SetContext(ctx)
+
+	if old == nil {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	} else {
+		resp, err := s.S3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(s.s3Path(k.String())),
+		})
+		if err != nil {
+			if isNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		cur, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(cur, old) || resp.ETag == nil {
+			return false, nil
+		}
+		// The write only succeeds if the object was not changed since it was read.
+		req.HTTPRequest.Header.Set("If-Match", *resp.ETag)
+	}
+
+	if err := req.Send(); err != nil {
+		if isConditionFailed(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func (s *S3Bucket) Sync(ctx context.Context, prefix ds.Key) error {
 	return nil
 }
@@ -274,6 +321,16 @@ func isNotFound(err error) bool {
 	return ok && s3Err.Code() == s3.ErrCodeNoSuchKey
 }
 
+// isConditionFailed returns true if a conditional write failed because the
+// condition did not hold (412) or a concurrent conditional write won (409).
+func isConditionFailed(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		return false
+	}
+	return reqErr.StatusCode() == http.StatusPreconditionFailed || reqErr.StatusCode() == http.StatusConflict
+}
+
 type s3Batch struct {
 	s          *S3Bucket
 	ops        map[string]batchOp
@@ -0,0 +1,178 @@
+package s3_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/storacha/go-libstoracha/datastore/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an S3 server holding objects in memory, supporting the conditional
+// writes used by Replace.
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	etags   map[string]string
+	version int
+	// beforePut is called before a write is applied, e.g. to simulate a
+	// concurrent write.
+	beforePut func(path string)
+	// conflict makes conditional writes fail as if a concurrent conditional
+	// write won.
+	conflict bool
+}
+
+func (f *fakeS3) set(path string, value []byte) {
+	f.version++
+	f.objects[path] = value
+	f.etags[path] = fmt.Sprintf(`"%d"`, f.version)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		f.mutex.Lock()
+		value, ok := f.objects[r.URL.Path]
+		etag := f.etags[r.URL.Path]
+		f.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(value)
+	case http.MethodPut:
+		value, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if f.beforePut != nil {
+			f.beforePut(r.URL.Path)
+		}
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		conditional := r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+		if conditional && f.conflict {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `<Error><Code>ConditionalRequestConflict</Code><Message>conflict</Message></Error>`)
+			return
+		}
+		_, exists := f.objects[r.URL.Path]
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(r.Header.Get("If-Match") != "" && r.Header.Get("If-Match") != f.etags[r.URL.Path]) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `<Error><Code>PreconditionFailed</Code><Message>precondition failed</Message></Error>`)
+			return
+		}
+		f.set(r.URL.Path, value)
+		w.Header().Set("ETag", f.etags[r.URL.Path])
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	ctx := context.Background()
+	key := ds.NewKey("head")
+
+	newBucket := func(t *testing.T) (*s3.S3Bucket, *fakeS3) {
+		fake := &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+		ts := httptest.NewServer(fake)
+		t.Cleanup(ts.Close)
+		bucket, err := s3.NewS3Datastore(s3.Config{
+			AccessKey:      "access",
+			SecretKey:      "secret",
+			Bucket:         "bucket",
+			Region:         "us-east-1",
+			RegionEndpoint: ts.URL,
+			ForcePathStyle: true,
+		})
+		require.NoError(t, err)
+		return bucket, fake
+	}
+
+	t.Run("create", func(t *testing.T) {
+		bucket, _ := newBucket(t)
+		ok, err := bucket.Replace(ctx, key, nil, []byte("a"))
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// If-None-Match fails now that the key exists
+		ok, err = bucket.Replace(ctx, key, nil, []byte("b"))
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		value, err := bucket.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("a"), value)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		bucket, _ := newBucket(t)
+		require.NoError(t, bucket.Put(ctx, key, []byte("a")))
+
+		ok, err := bucket.Replace(ctx, key, []byte("a"), []byte("b"))
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// the current value no longer matches
+		ok, err = bucket.Replace(ctx, key, []byte("a"), []byte("c"))
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		value, err := bucket.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("b"), value)
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		bucket, _ := newBucket(t)
+		ok, err := bucket.Replace(ctx, key, []byte("a"), []byte("b"))
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("changed since read", func(t *testing.T) {
+		bucket, fake := newBucket(t)
+		require.NoError(t, bucket.Put(ctx, key, []byte("a")))
+
+		// the value is rewritten after it was read, changing the ETag, so the
+		// If-Match condition fails
+		fake.beforePut = func(path string) {
+			fake.beforePut = nil
+			fake.mutex.Lock()
+			defer fake.mutex.Unlock()
+			fake.set(path, []byte("a"))
+		}
+		ok, err := bucket.Replace(ctx, key, []byte("a"), []byte("b"))
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		value, err := bucket.Get(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, []byte("a"), value)
+	})
+
+	t.Run("concurrent conditional write", func(t *testing.T) {
+		bucket, fake := newBucket(t)
+		fake.conflict = true
+
+		ok, err := bucket.Replace(ctx, key, nil, []byte("a"))
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		require.NoError(t, bucket.Put(ctx, key, []byte("a")))
+		ok, err = bucket.Replace(ctx, key, []byte("a"), []byte("b"))
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+}
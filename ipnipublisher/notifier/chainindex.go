@@ -0,0 +1,161 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+)
+
+// chainIndex caches the positions of adverts in the local chain, so that the
+// lag of every remote IPNI node can be calculated without walking the chain
+// on each poll. The chain is append-only, so only adverts published since the
+// last walk, and older adverts not yet needed, are read from the chain. At
+// most maxWalk adverts are cached, from the local head back.
+type chainIndex struct {
+	chain   AdvertChain
+	maxWalk int
+
+	mutex sync.Mutex
+	// links are the walked adverts, oldest first, ending with the local head.
+	links []ipld.Link
+	// base is the position of links[0].
+	base      int
+	positions map[string]int
+	// next is the advert preceding links[0] that has not been walked yet, or
+	// nil if the start of the chain was reached.
+	next ipld.Link
+}
+
+func newChainIndex(chain AdvertChain, maxWalk int) *chainIndex {
+	return &chainIndex{chain: chain, maxWalk: maxWalk, positions: map[string]int{}}
+}
+
+// lag returns the number of adverts in the local chain after the remote head,
+// or [UnknownLag] if the remote head is not within maxWalk adverts of the
+// local head.
+func (c *chainIndex) lag(ctx context.Context, localHead, remoteHead ipld.Link) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.advance(ctx, localHead); err != nil {
+		return UnknownLag, err
+	}
+	remote := remoteHead.String()
+	if _, ok := c.positions[remote]; !ok {
+		if err := c.extend(ctx, remote); err != nil {
+			return UnknownLag, err
+		}
+	}
+	lag := UnknownLag
+	if pos, ok := c.positions[remote]; ok {
+		lag = c.base + len(c.links) - 1 - pos
+	} else if c.next != nil && c.next.String() == remote {
+		// the remote head precedes every walked advert
+		lag = len(c.links)
+	}
+	if lag >= c.maxWalk {
+		return UnknownLag, nil
+	}
+	return lag, nil
+}
+
+// advance walks the adverts published since the cached local head. If the
+// new local head does not descend from a cached advert the cache is reset.
+func (c *chainIndex) advance(ctx context.Context, localHead ipld.Link) error {
+	if len(c.links) == 0 {
+		c.next = localHead
+		return nil
+	}
+	if c.links[len(c.links)-1].String() == localHead.String() {
+		return nil
+	}
+	added, next, err := c.walk(ctx, localHead, c.maxWalk, func(l ipld.Link) bool {
+		_, ok := c.positions[l.String()]
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+	slices.Reverse(added)
+	if next == nil {
+		c.reset(added, nil)
+		return nil
+	}
+	pos, ok := c.positions[next.String()]
+	if !ok {
+		c.reset(added, next)
+		return nil
+	}
+	// drop cached adverts replaced in the new chain, such as a re-signed head
+	for _, l := range c.links[pos-c.base+1:] {
+		delete(c.positions, l.String())
+	}
+	c.links = c.links[:pos-c.base+1]
+	for _, l := range added {
+		c.positions[l.String()] = c.base + len(c.links)
+		c.links = append(c.links, l)
+	}
+	if evict := len(c.links) - c.maxWalk; evict > 0 {
+		for _, l := range c.links[:evict] {
+			delete(c.positions, l.String())
+		}
+		c.next = c.links[evict-1]
+		c.links = append(c.links[:0], c.links[evict:]...)
+		c.base += evict
+	}
+	return nil
+}
+
+// extend walks back from the oldest cached advert until the remote head is
+// next, the start of the chain is reached or maxWalk adverts are cached.
+func (c *chainIndex) extend(ctx context.Context, remote string) error {
+	older, next, err := c.walk(ctx, c.next, c.maxWalk-len(c.links), func(l ipld.Link) bool {
+		return l.String() == remote
+	})
+	if err != nil {
+		return err
+	}
+	for i, l := range older {
+		c.positions[l.String()] = c.base - 1 - i
+	}
+	c.base -= len(older)
+	slices.Reverse(older)
+	c.links = append(older, c.links...)
+	c.next = next
+	return nil
+}
+
+func (c *chainIndex) reset(links []ipld.Link, next ipld.Link) {
+	c.links = links
+	c.base = 0
+	c.positions = make(map[string]int, len(links))
+	for i, l := range links {
+		c.positions[l.String()] = i
+	}
+	c.next = next
+}
+
+// walk visits at most limit adverts from the passed link back towards the
+// genesis advert, stopping before an advert for which stop returns true. It
+// returns the visited adverts, newest first, and the advert to visit next, or
+// nil if the start of the chain was reached.
+func (c *chainIndex) walk(ctx context.Context, from ipld.Link, limit int, stop func(ipld.Link) bool) ([]ipld.Link, ipld.Link, error) {
+	var visited []ipld.Link
+	cur := from
+	for cur != nil && len(visited) < limit && !stop(cur) {
+		ad, err := c.chain.Advert(ctx, cur)
+		if err != nil {
+			if store.IsNotFound(err) {
+				// the chain is not available beyond this advert
+				return append(visited, cur), nil, nil
+			}
+			return nil, nil, fmt.Errorf("reading advert %s: %w", cur, err)
+		}
+		visited = append(visited, cur)
+		cur = ad.PreviousID
+	}
+	return visited, cur, nil
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/ipfs/go-cid"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
@@ -15,8 +16,10 @@ import (
 const remoteHeadPrefix = "head/remote/"
 
 type HeadState struct {
-	ds     store.SimpleStore
-	hdkey  string
+	ds    store.SimpleStore
+	hdkey string
+
+	mutex  sync.RWMutex
 	cached ipld.Link
 }
 
@@ -44,10 +47,14 @@ func NewHeadState(ds store.SimpleStore, hostname string) (*HeadState, error) {
 }
 
 func (h *HeadState) Get(ctx context.Context) ipld.Link {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
 	return h.cached
 }
 
 func (h *HeadState) Set(ctx context.Context, head ipld.Link) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 	err := h.ds.Put(ctx, h.hdkey, uint64(len(head.Binary())), bytes.NewReader([]byte(head.Binary())))
 	if err != nil {
 		return fmt.Errorf("saving remote IPNI sync'd head: %w", err)
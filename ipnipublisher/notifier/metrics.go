@@ -0,0 +1,35 @@
+package notifier
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var meter = otel.Meter("github.com/storacha/go-libstoracha/ipnipublisher/notifier")
+
+var (
+	lagGauge         metric.Int64Gauge
+	pollErrorCounter metric.Int64Counter
+)
+
+func init() {
+	var err error
+	lagGauge, err = meter.Int64Gauge(
+		"ipnipublisher.notifier.lag",
+		metric.WithDescription("Number of adverts in the local chain a remote IPNI node has not synced, or -1 if unknown"),
+		metric.WithUnit("{advert}"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create lag gauge: %w", err))
+	}
+	pollErrorCounter, err = meter.Int64Counter(
+		"ipnipublisher.notifier.poll.errors",
+		metric.WithDescription("Number of failed polls of remote IPNI nodes"),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create poll error counter: %w", err))
+	}
+}
@@ -0,0 +1,434 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ipld/go-ipld-prime"
+	ipnifind "github.com/ipni/go-libipni/find/client"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// DefaultMaxChainWalk is the default maximum number of adverts walked in the
+// local chain when calculating lag or finding adverts that became synced.
+const DefaultMaxChainWalk = 10_000
+
+// UnknownLag is the lag reported for an indexer whose last advertisement
+// could not be found within the walked portion of the local chain.
+const UnknownLag = -1
+
+// NotifyAdvertSyncFunc is a function that is called for each advert that a
+// remote IPNI node has been seen to sync.
+type NotifyAdvertSyncFunc func(ctx context.Context, indexer string, advert ipld.Link)
+
+// AdvertChain provides read access to the local advertisement chain.
+type AdvertChain interface {
+	store.AdvertReadable
+	HeadLink(ctx context.Context) (ipld.Link, error)
+}
+
+type headStoreChain struct {
+	store.AdvertReadable
+	heads store.HeadStore
+}
+
+func (c headStoreChain) HeadLink(ctx context.Context) (ipld.Link, error) {
+	hd, err := c.heads.Head(ctx)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hd.Head, nil
+}
+
+// ChainFromStore creates an [AdvertChain] from a publisher store.
+func ChainFromStore(s store.PublisherStore) AdvertChain {
+	return headStoreChain{AdvertReadable: s, heads: s}
+}
+
+// IndexerStatus is the sync status of a remote IPNI node.
+type IndexerStatus struct {
+	// URL is the address of the remote IPNI node.
+	URL string
+	// Head is the last advertisement the node has synced.
+	Head ipld.Link
+	// Lag is the number of adverts in the local chain that the node has not
+	// yet synced, or [UnknownLag] if it could not be determined.
+	Lag int
+	// LastPoll is the time of the last successful poll of the node.
+	LastPoll time.Time
+	// LastSync is the time the node was last seen to sync.
+	LastSync time.Time
+	// LastError is the error from the last poll, or nil if it succeeded.
+	LastError error
+}
+
+// IndexerOption is an option configuring how a remote IPNI node is tracked.
+type IndexerOption func(*indexerConfig)
+
+type indexerConfig struct {
+	pollInterval time.Duration
+	maxBackoff   time.Duration
+	head         NotifierHead
+}
+
+// WithPollInterval sets the interval at which the node is polled. If not set,
+// [NotifierPollInterval] is used.
+func WithPollInterval(interval time.Duration) IndexerOption {
+	return func(c *indexerConfig) {
+		c.pollInterval = interval
+	}
+}
+
+// WithMaxBackoff sets the maximum interval between polls when polling fails.
+// The interval doubles after each consecutive failure, up to this maximum. If
+// not set, it is 10 times the poll interval.
+func WithMaxBackoff(backoff time.Duration) IndexerOption {
+	return func(c *indexerConfig) {
+		c.maxBackoff = backoff
+	}
+}
+
+// WithHead sets the storage for the last seen head of the node. If not set,
+// the head is kept in memory only.
+func WithHead(head NotifierHead) IndexerOption {
+	return func(c *indexerConfig) {
+		c.head = head
+	}
+}
+
+type indexerTracker struct {
+	url    string
+	client *ipnifind.Client
+	config indexerConfig
+
+	mutex  sync.Mutex
+	status IndexerStatus
+}
+
+// MultiNotifier tracks the sync status of many remote IPNI nodes, calculating
+// how many adverts each is behind the local chain and notifying for every
+// advert that a node has been seen to sync.
+type MultiNotifier struct {
+	provider     peer.ID
+	chain        AdvertChain
+	maxChainWalk int
+	// index caches the walk of the local chain shared by every indexer
+	index *chainIndex
+
+	mutex    sync.Mutex
+	indexers []*indexerTracker
+	// subscribers are notified of synced adverts
+	subscribers []advertSubscriber
+	nextID      uint64
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// MultiNotifierOption is an option configuring a [MultiNotifier].
+type MultiNotifierOption func(*MultiNotifier)
+
+// WithMaxChainWalk sets the maximum number of adverts walked in the local
+// chain when calculating lag or finding adverts that became synced. If not
+// set, [DefaultMaxChainWalk] is used.
+func WithMaxChainWalk(max int) MultiNotifierOption {
+	return func(n *MultiNotifier) {
+		n.maxChainWalk = max
+	}
+}
+
+// NewMultiNotifier creates a notifier for the adverts published by the passed
+// publisher identity to the passed local chain. Only the peer ID is needed, so
+// the key may be held outside the process (see signer.Signer). Remote IPNI
+// nodes to track are added with AddIndexer.
+func NewMultiNotifier(provider peer.ID, chain AdvertChain, opts ...MultiNotifierOption) (*MultiNotifier, error) {
+	if err := provider.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid IPNI publisher peer ID: %w", err)
+	}
+	n := &MultiNotifier{
+		provider:     provider,
+		chain:        chain,
+		maxChainWalk: DefaultMaxChainWalk,
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.index = newChainIndex(chain, n.maxChainWalk)
+	return n, nil
+}
+
+// AddIndexer adds a remote IPNI node to track. Indexers must be added before
+// the notifier is started.
+func (n *MultiNotifier) AddIndexer(addr string, opts ...IndexerOption) error {
+	cfg := indexerConfig{pollInterval: NotifierPollInterval}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.maxBackoff == 0 {
+		cfg.maxBackoff = 10 * cfg.pollInterval
+	}
+	if cfg.head == nil {
+		cfg.head = &memoryHead{}
+	}
+	c, err := ipnifind.New(addr)
+	if err != nil {
+		return err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.cancel != nil {
+		return errors.New("cannot add indexer to started notifier")
+	}
+	n.indexers = append(n.indexers, &indexerTracker{
+		url:    addr,
+		client: c,
+		config: cfg,
+		status: IndexerStatus{URL: addr, Lag: UnknownLag},
+	})
+	return nil
+}
+
+type advertSubscriber struct {
+	id uint64
+	f  NotifyAdvertSyncFunc
+}
+
+// Notify adds a function that is called for every advert a remote IPNI node
+// has been seen to sync, oldest first. When the previous head of a node is not
+// known, only its current head is notified.
+func (n *MultiNotifier) Notify(f NotifyAdvertSyncFunc) {
+	n.Subscribe(f)
+}
+
+// Subscribe is like Notify, but returns a function that removes the
+// subscription.
+func (n *MultiNotifier) Subscribe(f NotifyAdvertSyncFunc) (unsubscribe func()) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	id := n.nextID
+	n.nextID++
+	n.subscribers = append(n.subscribers, advertSubscriber{id: id, f: f})
+	return func() {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		n.subscribers = slices.DeleteFunc(n.subscribers, func(s advertSubscriber) bool {
+			return s.id == id
+		})
+	}
+}
+
+// Start starts polling the remote IPNI nodes. Calling Start on a started
+// notifier has no effect.
+func (n *MultiNotifier) Start(ctx context.Context) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	n.cancel = cancel
+	for _, ix := range n.indexers {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.poll(ctx, ix)
+		}()
+	}
+}
+
+// Stop stops polling and waits for in-progress polls to complete. Calling Stop
+// on a notifier that was not started has no effect.
+func (n *MultiNotifier) Stop() {
+	n.mutex.Lock()
+	cancel := n.cancel
+	n.cancel = nil
+	n.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	n.wg.Wait()
+}
+
+// Status returns the sync status of each remote IPNI node.
+func (n *MultiNotifier) Status() []IndexerStatus {
+	n.mutex.Lock()
+	indexers := slices.Clone(n.indexers)
+	n.mutex.Unlock()
+
+	statuses := make([]IndexerStatus, 0, len(indexers))
+	for _, ix := range indexers {
+		ix.mutex.Lock()
+		statuses = append(statuses, ix.status)
+		ix.mutex.Unlock()
+	}
+	return statuses
+}
+
+func (n *MultiNotifier) poll(ctx context.Context, ix *indexerTracker) {
+	wait := ix.config.pollInterval
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if err := n.update(ctx, ix); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Errorw("Failed to update remote IPNI sync status", "indexer", ix.url, "err", err)
+			pollErrorCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("indexer", ix.url)))
+			wait = min(wait*2, ix.config.maxBackoff)
+		} else {
+			wait = ix.config.pollInterval
+		}
+		timer.Reset(wait)
+	}
+}
+
+// update polls the remote IPNI node once, notifying for adverts it synced
+// since the last poll and updating its lag.
+func (n *MultiNotifier) update(ctx context.Context, ix *indexerTracker) error {
+	err := n.updateIndexer(ctx, ix)
+	ix.mutex.Lock()
+	ix.status.LastError = err
+	if err == nil {
+		ix.status.LastPoll = time.Now()
+	}
+	ix.mutex.Unlock()
+	return err
+}
+
+func (n *MultiNotifier) updateIndexer(ctx context.Context, ix *indexerTracker) error {
+	head, err := GetLastAdvertisement(ctx, ix.client, n.provider)
+	if err != nil {
+		return fmt.Errorf("fetching last advert CID from %s: %w", ix.url, err)
+	}
+
+	prev := ix.config.head.Get(ctx)
+	if DidSync(head, prev) {
+		synced, err := n.syncedAdverts(ctx, head, prev)
+		if err != nil {
+			return err
+		}
+		if err := ix.config.head.Set(ctx, head); err != nil {
+			return fmt.Errorf("updating head state: %w", err)
+		}
+		ix.mutex.Lock()
+		ix.status.LastSync = time.Now()
+		ix.mutex.Unlock()
+
+		n.mutex.Lock()
+		subscribers := slices.Clone(n.subscribers)
+		n.mutex.Unlock()
+		for _, ad := range synced {
+			for _, s := range subscribers {
+				s.f(ctx, ix.url, ad)
+			}
+		}
+	}
+
+	lag, err := n.lag(ctx, head)
+	if err != nil {
+		return err
+	}
+	ix.mutex.Lock()
+	ix.status.Head = head
+	ix.status.Lag = lag
+	ix.mutex.Unlock()
+	lagGauge.Record(ctx, int64(lag), metric.WithAttributes(attribute.String("indexer", ix.url)))
+	return nil
+}
+
+// syncedAdverts returns the adverts from prev (exclusive) to head (inclusive),
+// oldest first.
+func (n *MultiNotifier) syncedAdverts(ctx context.Context, head, prev ipld.Link) ([]ipld.Link, error) {
+	if prev == nil {
+		return []ipld.Link{head}, nil
+	}
+	var synced []ipld.Link
+	found := false
+	err := n.walk(ctx, head, func(l ipld.Link) bool {
+		if l.String() == prev.String() {
+			found = true
+			return false
+		}
+		synced = append(synced, l)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		log.Warnw("Previous remote head not found in local chain, notifying walked adverts only", "head", head, "prev", prev)
+	}
+	slices.Reverse(synced)
+	return synced, nil
+}
+
+// lag returns the number of adverts in the local chain after the passed
+// remote head. The walk of the local chain is cached and shared by every
+// indexer, so only adverts published since the last poll are read.
+func (n *MultiNotifier) lag(ctx context.Context, remoteHead ipld.Link) (int, error) {
+	localHead, err := n.chain.HeadLink(ctx)
+	if err != nil {
+		return UnknownLag, fmt.Errorf("getting local head: %w", err)
+	}
+	if localHead == nil {
+		return UnknownLag, nil
+	}
+	return n.index.lag(ctx, localHead, remoteHead)
+}
+
+// walk visits adverts in the local chain from the passed link back towards
+// the genesis advert, until visit returns false, the start of the chain is
+// reached or the maximum number of adverts have been walked.
+func (n *MultiNotifier) walk(ctx context.Context, from ipld.Link, visit func(ipld.Link) bool) error {
+	cur := from
+	for range n.maxChainWalk {
+		if cur == nil || !visit(cur) {
+			return nil
+		}
+		ad, err := n.chain.Advert(ctx, cur)
+		if err != nil {
+			if store.IsNotFound(err) {
+				// the remote head may not be in the local chain
+				return nil
+			}
+			return fmt.Errorf("reading advert %s: %w", cur, err)
+		}
+		cur = ad.PreviousID
+	}
+	return nil
+}
+
+type memoryHead struct {
+	mutex sync.Mutex
+	head  ipld.Link
+}
+
+func (m *memoryHead) Get(context.Context) ipld.Link {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.head
+}
+
+func (m *memoryHead) Set(_ context.Context, head ipld.Link) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.head = head
+	return nil
+}
@@ -0,0 +1,255 @@
+package notifier_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/notifier"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIndexer serves provider info with a settable last advertisement, or an
+// error when no advertisement is set.
+type mockIndexer struct {
+	mutex sync.Mutex
+	id    peer.ID
+	head  ipld.Link
+}
+
+func (m *mockIndexer) setHead(head ipld.Link) {
+	m.mutex.Lock()
+	m.head = head
+	m.mutex.Unlock()
+}
+
+func (m *mockIndexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.head == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	bytes, _ := json.Marshal(model.ProviderInfo{
+		AddrInfo:          peer.AddrInfo{ID: m.id},
+		LastAdvertisement: m.head.(cidlink.Link).Cid,
+	})
+	w.Write(bytes)
+}
+
+type syncEvent struct {
+	indexer string
+	advert  ipld.Link
+}
+
+func TestMultiNotifier(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	p, err := publisher.New(priv, st)
+	require.NoError(t, err)
+
+	var chain []ipld.Link
+	for range 5 {
+		digests := testutil.RandomMultihashes(t, 1)
+		l, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, testutil.RandomCID(t).String(), slices.Values(digests), metadata.Default.New())
+		require.NoError(t, err)
+		chain = append(chain, l)
+	}
+
+	behind := &mockIndexer{id: pid, head: chain[1]}
+	behindServer := httptest.NewServer(behind)
+	defer behindServer.Close()
+
+	synced := &mockIndexer{id: pid, head: chain[4]}
+	syncedServer := httptest.NewServer(synced)
+	defer syncedServer.Close()
+
+	failing := &mockIndexer{id: pid}
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+
+	n, err := notifier.NewMultiNotifier(pid, notifier.ChainFromStore(st))
+	require.NoError(t, err)
+	require.NoError(t, n.AddIndexer(behindServer.URL, notifier.WithPollInterval(time.Millisecond)))
+	require.NoError(t, n.AddIndexer(syncedServer.URL, notifier.WithPollInterval(time.Millisecond)))
+	require.NoError(t, n.AddIndexer(failingServer.URL, notifier.WithPollInterval(time.Millisecond), notifier.WithMaxBackoff(5*time.Millisecond)))
+
+	var mutex sync.Mutex
+	var events []syncEvent
+	n.Notify(func(ctx context.Context, indexer string, advert ipld.Link) {
+		mutex.Lock()
+		events = append(events, syncEvent{indexer, advert})
+		mutex.Unlock()
+	})
+	eventsFor := func(indexer string) []ipld.Link {
+		mutex.Lock()
+		defer mutex.Unlock()
+		var ads []ipld.Link
+		for _, e := range events {
+			if e.indexer == indexer {
+				ads = append(ads, e.advert)
+			}
+		}
+		return ads
+	}
+
+	n.Start(ctx)
+	defer n.Stop()
+
+	statusOf := func(url string) notifier.IndexerStatus {
+		for _, s := range n.Status() {
+			if s.URL == url {
+				return s
+			}
+		}
+		t.Fatalf("no status for %s", url)
+		return notifier.IndexerStatus{}
+	}
+
+	require.Eventually(t, func() bool {
+		return statusOf(behindServer.URL).Lag == 3 && statusOf(syncedServer.URL).Lag == 0
+	}, 5*time.Second, time.Millisecond)
+
+	// previous head not known, so only the current head is notified
+	require.Equal(t, []ipld.Link{chain[1]}, eventsFor(behindServer.URL))
+	require.Equal(t, []ipld.Link{chain[4]}, eventsFor(syncedServer.URL))
+
+	behind.setHead(chain[4])
+	require.Eventually(t, func() bool {
+		return statusOf(behindServer.URL).Lag == 0
+	}, 5*time.Second, time.Millisecond)
+
+	// every advert that became synced is notified, oldest first
+	require.Equal(t, []ipld.Link{chain[1], chain[2], chain[3], chain[4]}, eventsFor(behindServer.URL))
+
+	status := statusOf(failingServer.URL)
+	require.Error(t, status.LastError)
+	require.Equal(t, notifier.UnknownLag, status.Lag)
+	require.Empty(t, eventsFor(failingServer.URL))
+
+	n.Stop()
+	// stopping again has no effect
+	n.Stop()
+}
+
+// countingChain counts the adverts read from the local chain.
+type countingChain struct {
+	notifier.AdvertChain
+	reads atomic.Int64
+}
+
+func (c *countingChain) Advert(ctx context.Context, id ipld.Link) (schema.Advertisement, error) {
+	c.reads.Add(1)
+	return c.AdvertChain.Advert(ctx, id)
+}
+
+func TestMultiNotifierLag(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	p, err := publisher.New(priv, st)
+	require.NoError(t, err)
+
+	publish := func() ipld.Link {
+		digests := testutil.RandomMultihashes(t, 1)
+		l, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, testutil.RandomCID(t).String(), slices.Values(digests), metadata.Default.New())
+		require.NoError(t, err)
+		return l
+	}
+	var chain []ipld.Link
+	for range 5 {
+		chain = append(chain, publish())
+	}
+
+	t.Run("walk is cached", func(t *testing.T) {
+		var servers []*httptest.Server
+		for _, head := range []ipld.Link{chain[1], chain[3]} {
+			server := httptest.NewServer(&mockIndexer{id: pid, head: head})
+			defer server.Close()
+			servers = append(servers, server)
+		}
+
+		counting := &countingChain{AdvertChain: notifier.ChainFromStore(st)}
+		n, err := notifier.NewMultiNotifier(pid, counting)
+		require.NoError(t, err)
+		for _, server := range servers {
+			require.NoError(t, n.AddIndexer(server.URL, notifier.WithPollInterval(time.Millisecond)))
+		}
+
+		lags := func() []int {
+			var lags []int
+			for _, s := range n.Status() {
+				lags = append(lags, s.Lag)
+			}
+			return lags
+		}
+		n.Start(ctx)
+		defer n.Stop()
+
+		require.Eventually(t, func() bool {
+			return slices.Equal(lags(), []int{3, 1})
+		}, 5*time.Second, time.Millisecond)
+
+		// later polls do not walk the chain again
+		reads := counting.reads.Load()
+		polled := n.Status()[0].LastPoll
+		require.Eventually(t, func() bool {
+			return n.Status()[0].LastPoll.After(polled.Add(10 * time.Millisecond))
+		}, 5*time.Second, time.Millisecond)
+		require.Equal(t, reads, counting.reads.Load())
+
+		// only the new advert is read when the local chain grows
+		publish()
+		require.Eventually(t, func() bool {
+			return slices.Equal(lags(), []int{4, 2})
+		}, 5*time.Second, time.Millisecond)
+		require.Equal(t, reads+1, counting.reads.Load())
+	})
+
+	t.Run("max chain walk", func(t *testing.T) {
+		server := httptest.NewServer(&mockIndexer{id: pid, head: chain[1]})
+		defer server.Close()
+
+		n, err := notifier.NewMultiNotifier(pid, notifier.ChainFromStore(st), notifier.WithMaxChainWalk(3))
+		require.NoError(t, err)
+		require.NoError(t, n.AddIndexer(server.URL, notifier.WithPollInterval(time.Millisecond)))
+		n.Start(ctx)
+		defer n.Stop()
+
+		require.Eventually(t, func() bool {
+			return !n.Status()[0].LastPoll.IsZero()
+		}, 5*time.Second, time.Millisecond)
+		require.Equal(t, notifier.UnknownLag, n.Status()[0].Lag)
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"slices"
+	"sync"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -40,26 +42,52 @@ type Notifier struct {
 	client   *ipnifind.Client
 	provider peer.ID
 	head     NotifierHead
-	ts       time.Time
-	done     chan bool
-	notify   []NotifyRemoteSyncFunc
+
+	// updateMutex serializes updates, which may be made by the polling
+	// goroutine and by direct calls to Update.
+	updateMutex sync.Mutex
+
+	mutex       sync.Mutex
+	ts          time.Time
+	subscribers []subscriber
+	nextID      uint64
+	cancel      context.CancelFunc
+	done        chan struct{}
 }
 
+type subscriber struct {
+	id uint64
+	f  NotifyRemoteSyncFunc
+}
+
+// Start starts polling the remote IPNI node in the background. Polling stops
+// when Stop is called or the passed context is canceled. Calling Start on a
+// started notifier has no effect.
 func (n *Notifier) Start(ctx context.Context) {
-	n.done = make(chan bool)
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	n.cancel = cancel
+	n.done = done
+
 	ticker := time.NewTicker(NotifierPollInterval)
 	go func() {
+		defer close(done)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
-				ticker.Stop()
-				return
-			case <-n.done:
-				ticker.Stop()
 				return
 			case <-ticker.C:
 				synced, ts, err := n.Update(ctx)
 				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
 					log.Errorf(err.Error())
 					continue
 				}
@@ -73,31 +101,74 @@ func (n *Notifier) Start(ctx context.Context) {
 }
 
 func (n *Notifier) Update(ctx context.Context) (bool, time.Time, error) {
+	n.updateMutex.Lock()
+	defer n.updateMutex.Unlock()
+
+	n.mutex.Lock()
+	ts := n.ts
+	n.mutex.Unlock()
+
 	head, err := GetLastAdvertisement(ctx, n.client, n.provider)
 	if err != nil {
-		return false, n.ts, fmt.Errorf("fetching last advert CID from %s: %w", n.addr, err)
+		return false, ts, fmt.Errorf("fetching last advert CID from %s: %w", n.addr, err)
 	}
 	prev := n.head.Get(ctx)
 	if !DidSync(head, prev) {
-		return false, n.ts, nil
+		return false, ts, nil
 	}
 	err = n.head.Set(ctx, head)
 	if err != nil {
-		return false, n.ts, fmt.Errorf("updating head state: %w", err)
-	}
-	for _, f := range n.notify {
-		f(ctx, head, prev)
+		return false, ts, fmt.Errorf("updating head state: %w", err)
 	}
+
+	n.mutex.Lock()
+	subscribers := slices.Clone(n.subscribers)
 	n.ts = time.Now()
-	return true, n.ts, nil
+	ts = n.ts
+	n.mutex.Unlock()
+
+	for _, s := range subscribers {
+		s.f(ctx, head, prev)
+	}
+	return true, ts, nil
 }
 
+// Notify adds the passed notification function to the list of functions that
+// should be called when a remote IPNI node has been seen to perform a sync.
 func (n *Notifier) Notify(f NotifyRemoteSyncFunc) {
-	n.notify = append(n.notify, f)
+	n.Subscribe(f)
 }
 
+// Subscribe adds the passed notification function to the list of functions
+// that should be called when a remote IPNI node has been seen to perform a
+// sync. It returns a function that removes the subscription.
+func (n *Notifier) Subscribe(f NotifyRemoteSyncFunc) (unsubscribe func()) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	id := n.nextID
+	n.nextID++
+	n.subscribers = append(n.subscribers, subscriber{id: id, f: f})
+	return func() {
+		n.mutex.Lock()
+		defer n.mutex.Unlock()
+		n.subscribers = slices.DeleteFunc(n.subscribers, func(s subscriber) bool {
+			return s.id == id
+		})
+	}
+}
+
+// Stop stops polling and waits for the polling goroutine to exit. Calling Stop
+// on a notifier that was not started, or was already stopped, has no effect.
 func (n *Notifier) Stop() {
-	n.done <- true
+	n.mutex.Lock()
+	cancel, done := n.cancel, n.done
+	n.cancel, n.done = nil, nil
+	n.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
 }
 
 func GetLastAdvertisement(ctx context.Context, client *ipnifind.Client, provider peer.ID) (ipld.Link, error) {
@@ -126,6 +197,9 @@ func NewRemoteSyncNotifier(addr string, id crypto.PrivKey, head NotifierHead) (*
 	if err != nil {
 		return nil, err
 	}
+	if head == nil {
+		head = &memoryHead{}
+	}
 	return &Notifier{
 		addr:     addr,
 		client:   c,
@@ -9,12 +9,15 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipni/go-libipni/find/model"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/storacha/go-libstoracha/ipnipublisher/notifier"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
 	"github.com/storacha/go-libstoracha/testutil"
 	"github.com/stretchr/testify/require"
 )
@@ -100,6 +103,211 @@ func TestNotifier(t *testing.T) {
 	})
 }
 
+func TestNotifierLifecycle(t *testing.T) {
+	notifier.NotifierPollInterval = time.Millisecond
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	t.Run("stop without start", func(t *testing.T) {
+		notif, err := notifier.NewRemoteSyncNotifier("http://localhost", priv, nil)
+		require.NoError(t, err)
+		stopWithin(t, notif, time.Second)
+	})
+
+	t.Run("stop after context canceled", func(t *testing.T) {
+		ts, _ := mockIpniApi(t, pid)
+		defer ts.Close()
+
+		notif, err := notifier.NewRemoteSyncNotifier(ts.URL, priv, nil)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		notif.Start(ctx)
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		stopWithin(t, notif, time.Second)
+	})
+
+	t.Run("idempotent start and stop", func(t *testing.T) {
+		ts, ads := mockIpniApi(t, pid)
+		defer ts.Close()
+
+		notif, err := notifier.NewRemoteSyncNotifier(ts.URL, priv, nil)
+		require.NoError(t, err)
+
+		var mutex sync.Mutex
+		var notifications []ipld.Link
+		notif.Notify(func(ctx context.Context, head, prev ipld.Link) {
+			mutex.Lock()
+			notifications = append(notifications, head)
+			mutex.Unlock()
+		})
+
+		notif.Start(context.Background())
+		notif.Start(context.Background())
+		require.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(notifications) == len(ads)
+		}, 5*time.Second, time.Millisecond)
+		stopWithin(t, notif, time.Second)
+		stopWithin(t, notif, time.Second)
+
+		// a single poller notified each head once
+		require.Equal(t, ads, notifications)
+	})
+
+	t.Run("restart after stop", func(t *testing.T) {
+		ts, ads := mockIpniApi(t, pid)
+		defer ts.Close()
+
+		notif, err := notifier.NewRemoteSyncNotifier(ts.URL, priv, nil)
+		require.NoError(t, err)
+
+		var mutex sync.Mutex
+		var notifications []ipld.Link
+		notif.Notify(func(ctx context.Context, head, prev ipld.Link) {
+			mutex.Lock()
+			notifications = append(notifications, head)
+			mutex.Unlock()
+		})
+		last := func() ipld.Link {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(notifications) == 0 {
+				return nil
+			}
+			return notifications[len(notifications)-1]
+		}
+
+		notif.Start(context.Background())
+		require.Eventually(t, func() bool { return last() != nil }, 5*time.Second, time.Millisecond)
+		stopWithin(t, notif, time.Second)
+
+		// a poll in flight when stopped may be lost, so only the final head is
+		// guaranteed to be notified after restarting
+		notif.Start(context.Background())
+		require.Eventually(t, func() bool { return last() == ads[len(ads)-1] }, 5*time.Second, time.Millisecond)
+		stopWithin(t, notif, time.Second)
+	})
+
+	t.Run("subscribe and unsubscribe while polling", func(t *testing.T) {
+		ts, ads := mockIpniApi(t, pid)
+		defer ts.Close()
+
+		notif, err := notifier.NewRemoteSyncNotifier(ts.URL, priv, nil)
+		require.NoError(t, err)
+
+		var mutex sync.Mutex
+		var kept, removed []ipld.Link
+		notif.Subscribe(func(ctx context.Context, head, prev ipld.Link) {
+			mutex.Lock()
+			kept = append(kept, head)
+			mutex.Unlock()
+		})
+
+		notif.Start(context.Background())
+		defer notif.Stop()
+
+		// subscribe and unsubscribe concurrently with notifications being sent
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				unsubscribe := notif.Subscribe(func(ctx context.Context, head, prev ipld.Link) {
+					mutex.Lock()
+					removed = append(removed, head)
+					mutex.Unlock()
+				})
+				unsubscribe()
+			}()
+		}
+		wg.Wait()
+
+		require.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(kept) == len(ads)
+		}, 5*time.Second, time.Millisecond)
+
+		stopWithin(t, notif, time.Second)
+		mutex.Lock()
+		defer mutex.Unlock()
+		require.Equal(t, ads, kept)
+		// unsubscribed functions can only have seen heads notified before
+		// they were removed, and never more than the full set
+		require.LessOrEqual(t, len(removed), 10*len(ads))
+	})
+
+	t.Run("concurrent update", func(t *testing.T) {
+		ts, ads := mockIpniApi(t, pid)
+		defer ts.Close()
+
+		notif, err := notifier.NewRemoteSyncNotifier(ts.URL, priv, nil)
+		require.NoError(t, err)
+
+		var mutex sync.Mutex
+		var notifications []ipld.Link
+		notif.Notify(func(ctx context.Context, head, prev ipld.Link) {
+			mutex.Lock()
+			notifications = append(notifications, head)
+			mutex.Unlock()
+		})
+
+		var wg sync.WaitGroup
+		for range len(ads) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := notif.Update(context.Background())
+				require.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, ads, notifications)
+	})
+}
+
+func TestHeadStateConcurrency(t *testing.T) {
+	hs, err := notifier.NewHeadState(store.SimpleStoreFromDatastore(dssync.MutexWrap(datastore.NewMapDatastore())), "localhost")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, hs.Set(context.Background(), testutil.RandomCID(t)))
+		}()
+		go func() {
+			defer wg.Done()
+			hs.Get(context.Background())
+		}()
+	}
+	wg.Wait()
+	require.NotNil(t, hs.Get(context.Background()))
+}
+
+func stopWithin(t *testing.T, n *notifier.Notifier, timeout time.Duration) {
+	t.Helper()
+	stopped := make(chan struct{})
+	go func() {
+		n.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for notifier to stop")
+	}
+}
+
 type mockHead struct {
 	head ipld.Link
 }
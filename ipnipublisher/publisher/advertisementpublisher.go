@@ -3,27 +3,58 @@ package publisher
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
 
+	"github.com/ipfs/go-cid"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
-	"github.com/ipni/go-libipni/announce"
 	"github.com/ipni/go-libipni/announce/httpsender"
+	"github.com/ipni/go-libipni/announce/p2psender"
 	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
 	"github.com/ipni/go-libipni/ingest/schema"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
 	"github.com/storacha/go-libstoracha/ipnipublisher/store"
 	"github.com/storacha/go-ucanto/core/ipld"
 )
 
 type AdvertisementPublisher struct {
 	*options
-	pendingAds []schema.Advertisement
-	sender     announce.Sender
-	key        crypto.PrivKey
-	store      store.PublisherStore
+	pendingMutex sync.Mutex
+	pendingAds   []schema.Advertisement
+	announcer    *announcer
+	// signer signs the head and the advertisements of providers without a
+	// registered signer. It is replaced when the key is rotated, so it is read
+	// once per commit.
+	signer atomic.Pointer[signer.Signer]
+	store  store.PublisherStore
+
+	providersMutex sync.RWMutex
+	providers      map[peer.ID]signer.Signer
+
+	// commitMutex is held around generating and committing advertisements.
+	commitMutex sync.Mutex
+
+	hooksMutex  sync.Mutex
+	commitHooks map[uint64]commitHook
+	nextHookID  uint64
 }
 
+// commitHook is called with the advertisements committed to the chain.
+type commitHook func(ctx context.Context, ads []schema.Advertisement)
+
+// NewAdvertisementPublisher creates a publisher that signs advertisements and
+// the head with the passed private key.
 func NewAdvertisementPublisher(id crypto.PrivKey, store store.PublisherStore, opts ...Option) (*AdvertisementPublisher, error) {
+	return NewAdvertisementPublisherWithSigner(signer.FromPrivKey(id), store, opts...)
+}
+
+// NewAdvertisementPublisherWithSigner creates a publisher that signs
+// advertisements and the head with the passed signer, allowing the private key
+// to be held outside the process (see [signer.RemoteSigner]).
+func NewAdvertisementPublisherWithSigner(s signer.Signer, store store.PublisherStore, opts ...Option) (*AdvertisementPublisher, error) {
 	o := &options{
 		topic: "/indexer/ingest/mainnet",
 	}
@@ -33,55 +64,230 @@ func NewAdvertisementPublisher(id crypto.PrivKey, store store.PublisherStore, op
 			return nil, err
 		}
 	}
-	peer, err := peer.IDFromPrivateKey(id)
+	id, err := signer.ID(s)
 	if err != nil {
-		return nil, fmt.Errorf("cannot get peer ID from private key: %w", err)
+		return nil, fmt.Errorf("cannot get peer ID from signer: %w", err)
 	}
 	batchPublisher := &AdvertisementPublisher{
-		options: o,
-		key:     id,
-		store:   store,
+		options:   o,
+		store:     store,
+		providers: map[peer.ID]signer.Signer{},
 	}
-	if len(o.announceURLs) > 0 {
-		sender, err := httpsender.New(o.announceURLs, peer)
+	batchPublisher.signer.Store(&s)
+	for _, ps := range o.providerSigners {
+		if _, err := batchPublisher.AddProvider(ps); err != nil {
+			return nil, err
+		}
+	}
+	batchPublisher.announcer = newAnnouncer(o.pubHTTPAnnounceAddrs, o.announceRetry, o.reannounceInterval, batchPublisher.headCID)
+	// Each announce URL gets its own sender, so that the status of
+	// announcements can be tracked per indexer.
+	for _, u := range o.announceURLs {
+		sender, err := httpsender.New([]*url.URL{u}, id)
 		if err != nil {
 			return nil, fmt.Errorf("cannot create http announce sender: %w", err)
 		}
+		batchPublisher.announcer.addTarget(u.String(), sender)
+	}
+	if len(o.announceURLs) > 0 {
 		log.Info("HTTP announcements enabled")
-		batchPublisher.sender = sender
 	}
+	if o.pubsubHost != nil {
+		sender, err := p2psender.New(o.pubsubHost, o.topic)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create pubsub announce sender: %w", err)
+		}
+		log.Infow("Pubsub announcements enabled", "topic", o.topic)
+		batchPublisher.announcer.addTarget("pubsub:"+o.topic, sender)
+	}
+	batchPublisher.announcer.start()
 	return batchPublisher, nil
 }
 
+// Close stops background announcements and closes the announce senders used
+// by the publisher.
+func (p *AdvertisementPublisher) Close() error {
+	return p.announcer.close()
+}
+
+// AnnounceStatus returns the status of announcements to each announce target.
+func (p *AdvertisementPublisher) AnnounceStatus() []AnnounceStatus {
+	return p.announcer.status()
+}
+
+// CommitLocker returns the lock held while advertisements are generated and
+// committed by [IPNIPublisher.Publish], [AdvertisementPublisher.RotateKey] and
+// an [ExpiryScheduler]. Callers generating advertisements with [GenerateAd] and
+// committing them with [AdvertisementPublisher.Commit] must hold it for the
+// duration.
+func (p *AdvertisementPublisher) CommitLocker() sync.Locker {
+	return &p.commitMutex
+}
+
+// addCommitHook registers a function called with the advertisements of every
+// successful commit, returning a function that removes it.
+func (p *AdvertisementPublisher) addCommitHook(hook commitHook) (remove func()) {
+	p.hooksMutex.Lock()
+	defer p.hooksMutex.Unlock()
+	if p.commitHooks == nil {
+		p.commitHooks = map[uint64]commitHook{}
+	}
+	id := p.nextHookID
+	p.nextHookID++
+	p.commitHooks[id] = hook
+	return func() {
+		p.hooksMutex.Lock()
+		defer p.hooksMutex.Unlock()
+		delete(p.commitHooks, id)
+	}
+}
+
+// committed calls the commit hooks with the committed advertisements.
+func (p *AdvertisementPublisher) committed(ctx context.Context, ads []schema.Advertisement) {
+	if len(ads) == 0 {
+		return
+	}
+	p.hooksMutex.Lock()
+	hooks := make([]commitHook, 0, len(p.commitHooks))
+	for _, hook := range p.commitHooks {
+		hooks = append(hooks, hook)
+	}
+	p.hooksMutex.Unlock()
+	for _, hook := range hooks {
+		hook(ctx, ads)
+	}
+}
+
+func (p *AdvertisementPublisher) headCID(ctx context.Context) (cid.Cid, error) {
+	hd, err := p.store.Head(ctx)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return cid.Undef, nil
+		}
+		return cid.Undef, err
+	}
+	return hd.Head.(cidlink.Link).Cid, nil
+}
+
+// AddToBatch adds an advertisement generated with [GenerateAd] to the batch
+// committed by the next call to [AdvertisementPublisher.Commit]. The commit
+// lock (see [AdvertisementPublisher.CommitLocker]) must be held from generating
+// the advertisements of a batch until it is committed, so that the batches and
+// provider/context ID table entries of concurrent callers are not mixed.
 func (p *AdvertisementPublisher) AddToBatch(adv schema.Advertisement) error {
+	p.pendingMutex.Lock()
+	defer p.pendingMutex.Unlock()
 	p.pendingAds = append(p.pendingAds, adv)
 	return nil
 }
 
+// Commit signs and stores the pending advertisements, replaces the head and
+// announces it. The commit lock must be held, see
+// [AdvertisementPublisher.AddToBatch]. When the publisher is configured with a
+// leader election and does not hold the lease, the pending advertisements are
+// discarded and [ErrNotLeader] is returned.
 func (p *AdvertisementPublisher) Commit(ctx context.Context) (ipld.Link, error) {
+	p.pendingMutex.Lock()
 	pendingAds := p.pendingAds
 	p.pendingAds = nil
-	lnk, err := p.commit(ctx, pendingAds)
-	if err != nil {
-		for _, adv := range pendingAds {
-			if !adv.IsRm {
-				peer, err := peer.Decode(adv.Provider)
-				if err == nil {
-					_ = p.store.DeleteChunkLinkForProviderAndContextID(ctx, peer, adv.ContextID)
-				}
-			}
+	p.pendingMutex.Unlock()
+	return p.publish(ctx, pendingAds, func() {})
+}
+
+// publish signs and stores the advertisements, replaces the head and announces
+// it. It calls unlock once the advertisements are stored, before the head is
+// replaced: the head is swapped only if it did not change since it was read,
+// so a commit that stored its advertisements in the meantime fails with
+// [store.ErrPreconditionFailed] rather than being lost. The head store may
+// then be written to while publishing, e.g. by another publisher sharing it.
+func (p *AdvertisementPublisher) publish(ctx context.Context, ads []schema.Advertisement, unlock func()) (ipld.Link, error) {
+	if err := p.checkLeader(); err != nil {
+		p.discard(ctx, ads)
+		unlock()
+		return nil, err
+	}
+
+	self := p.currentSigner()
+	signed := make([]pendingAd, 0, len(ads))
+	for _, adv := range ads {
+		s, err := p.signerFor(adv.Provider, self)
+		if err != nil {
+			p.discard(ctx, ads)
+			unlock()
+			return nil, err
 		}
+		signed = append(signed, pendingAd{ad: adv, signer: s})
+	}
+	prevHead, lnk, err := p.commit(ctx, signed)
+	unlock()
+	if err == nil && len(signed) > 0 {
+		err = p.replaceHead(ctx, prevHead, lnk, self)
+	}
+	if err != nil {
+		p.discard(ctx, ads)
 		return nil, err
 	}
+	p.committed(ctx, ads)
+	if len(signed) > 0 {
+		// Failures are logged, tracked in the announce status and retried in the
+		// background when retries are enabled.
+		_ = p.announcer.announce(ctx, lnk.(cidlink.Link).Cid)
+	}
 	return lnk, nil
 }
-func (p *AdvertisementPublisher) commit(ctx context.Context, pendingAds []schema.Advertisement) (ipld.Link, error) {
+
+// currentSigner returns the signer of the publisher.
+func (p *AdvertisementPublisher) currentSigner() signer.Signer {
+	return *p.signer.Load()
+}
+
+// checkLeader returns [ErrNotLeader] if the publisher is configured with a
+// leader election and does not hold the lease. It is checked before writing to
+// the store, so that replicas that are not the leader leave it untouched.
+func (p *AdvertisementPublisher) checkLeader() error {
+	if p.leaderElection != nil && !p.leaderElection.IsLeader() {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// pendingAd is an advertisement waiting to be committed, along with the
+// signer it must be signed with.
+type pendingAd struct {
+	ad     schema.Advertisement
+	signer signer.Signer
+}
+
+// discard removes the provider/context ID table entries written for adverts
+// that failed to be committed.
+func (p *AdvertisementPublisher) discard(ctx context.Context, ads []schema.Advertisement) {
+	for _, adv := range ads {
+		if !adv.IsRm {
+			peer, err := peer.Decode(adv.Provider)
+			if err == nil {
+				_ = p.store.DeleteChunkLinkForProviderAndContextID(ctx, peer, adv.ContextID)
+				_ = digestSets(p.store).DeleteDigestSetHashForProviderAndContextID(ctx, peer, adv.ContextID)
+			}
+		}
+	}
+}
+
+// commit signs and stores the pending advertisements in order, linking each to
+// the previous one. It returns the head they were linked to and the link to
+// the last advertisement stored, which is the previous head advertisement when
+// there are no pending advertisements.
+func (p *AdvertisementPublisher) commit(ctx context.Context, pendingAds []pendingAd) (*head.SignedHead, ipld.Link, error) {
+	// Leadership is checked again, since the lease may have been lost while
+	// the advertisements were generated.
+	if err := p.checkLeader(); err != nil {
+		return nil, nil, err
+	}
 
 	// Get the previous advertisement that was generated.
 	prevHead, err := p.store.Head(ctx)
 	if err != nil {
 		if !store.IsNotFound(err) {
-			return nil, fmt.Errorf("could not get latest advertisement: %s", err)
+			return nil, nil, fmt.Errorf("could not get latest advertisement: %s", err)
 		}
 	}
 	var prevLink ipld.Link
@@ -95,48 +301,46 @@ func (p *AdvertisementPublisher) commit(ctx context.Context, pendingAds []schema
 
 	if len(pendingAds) == 0 {
 		log.Info("No pending advertisements to commit")
-		return prevLink, nil
+		return prevHead, prevLink, nil
 	}
 
 	// Store all pending advertisements in order, linking each to the previous.
-	for _, adv := range pendingAds {
+	for _, pending := range pendingAds {
+		adv := pending.ad
 		adv.PreviousID = prevLink
 
 		// Sign the advertisement.
-		if err = adv.Sign(p.key); err != nil {
-			return nil, err
+		if err = adv.Sign(signer.AsPrivKey(ctx, pending.signer)); err != nil {
+			return nil, nil, err
 		}
 
 		if err := adv.Validate(); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		lnk, err := p.store.PutAdvert(ctx, adv)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		log.Info("Stored ad in local link system")
 		prevLink = lnk
 	}
 
-	lnk := prevLink
-	head, err := head.NewSignedHead(lnk.(cidlink.Link).Cid, p.topic, p.key)
+	return prevHead, prevLink, nil
+}
+
+// replaceHead signs a head pointing to lnk with the passed signer and swaps it
+// for the previous head.
+func (p *AdvertisementPublisher) replaceHead(ctx context.Context, prevHead *head.SignedHead, lnk ipld.Link, s signer.Signer) error {
+	head, err := head.NewSignedHead(lnk.(cidlink.Link).Cid, p.topic, signer.AsPrivKey(ctx, s))
 	if err != nil {
 		log.Errorw("Failed to generate signed head for the latest advertisement", "err", err)
-		return nil, fmt.Errorf("failed to generate signed head for the latest advertisement: %w", err)
+		return fmt.Errorf("failed to generate signed head for the latest advertisement: %w", err)
 	}
 	if _, err := p.store.ReplaceHead(ctx, prevHead, head); err != nil {
 		log.Errorw("Failed to update reference to the latest advertisement", "err", err)
-		return nil, fmt.Errorf("failed to update reference to latest advertisement: %w", err)
+		return fmt.Errorf("failed to update reference to latest advertisement: %w", err)
 	}
 	log.Info("Updated reference to the latest advertisement successfully")
-
-	if p.sender != nil {
-		err = announce.Send(ctx, lnk.(cidlink.Link).Cid, p.pubHTTPAnnounceAddrs, p.sender)
-		if err != nil {
-			log.Warnw("Failed to announce advertisement", "err", err)
-		}
-	}
-
-	return lnk, nil
+	return nil
 }
@@ -0,0 +1,283 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// AnnounceStatus is the status of announcements to a single announce target.
+type AnnounceStatus struct {
+	// Target identifies the announce target, e.g. the indexer announce URL.
+	Target string
+	// LastAnnounced is the CID of the last advertisement successfully announced
+	// to the target. It is [cid.Undef] if no announcement has succeeded yet.
+	LastAnnounced cid.Cid
+	// LastSuccess is the time of the last successful announcement.
+	LastSuccess time.Time
+	// LastError is the error returned by the last failed announcement, or nil
+	// if the last announcement succeeded.
+	LastError error
+	// LastErrorTime is the time of the last failed announcement.
+	LastErrorTime time.Time
+	// Retrying indicates an announcement to the target failed and is being
+	// retried in the background.
+	Retrying bool
+}
+
+// retryConfig configures retries of failed announcements.
+type retryConfig struct {
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+}
+
+type announceTarget struct {
+	name   string
+	sender announce.Sender
+	retry  chan cid.Cid
+
+	mutex  sync.Mutex
+	status AnnounceStatus
+	// latest is the CID of the most recent announcement made to the target.
+	latest cid.Cid
+}
+
+func (t *announceTarget) send(ctx context.Context, c cid.Cid, addrs []multiaddr.Multiaddr) error {
+	msg := message.Message{Cid: c}
+	msg.SetAddrs(addrs)
+	t.mutex.Lock()
+	sender := t.sender
+	t.mutex.Unlock()
+	err := sender.Send(ctx, msg)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err != nil {
+		t.status.LastError = err
+		t.status.LastErrorTime = time.Now()
+		return err
+	}
+	t.status.LastAnnounced = c
+	t.status.LastSuccess = time.Now()
+	t.status.LastError = nil
+	return nil
+}
+
+// superseded returns true if an announcement newer than the passed CID has
+// been made to the target.
+func (t *announceTarget) superseded(c cid.Cid) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.latest != c
+}
+
+// announcer sends announcements to a set of targets, tracking the status of
+// each, retrying failed announcements in the background and optionally
+// re-announcing the current head periodically.
+type announcer struct {
+	targets            []*announceTarget
+	addrs              []multiaddr.Multiaddr
+	retry              *retryConfig
+	reannounceInterval time.Duration
+	head               func(context.Context) (cid.Cid, error)
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newAnnouncer(addrs []multiaddr.Multiaddr, retry *retryConfig, reannounceInterval time.Duration, head func(context.Context) (cid.Cid, error)) *announcer {
+	return &announcer{
+		addrs:              addrs,
+		retry:              retry,
+		reannounceInterval: reannounceInterval,
+		head:               head,
+	}
+}
+
+func (a *announcer) addTarget(name string, sender announce.Sender) {
+	a.targets = append(a.targets, &announceTarget{
+		name:   name,
+		sender: sender,
+		retry:  make(chan cid.Cid, 1),
+		status: AnnounceStatus{Target: name},
+	})
+}
+
+// replaceSender swaps the sender used for the named target, closing the
+// previous sender. The announce status of the target is kept.
+func (a *announcer) replaceSender(name string, sender announce.Sender) error {
+	for _, t := range a.targets {
+		if t.name != name {
+			continue
+		}
+		t.mutex.Lock()
+		prev := t.sender
+		t.sender = sender
+		t.mutex.Unlock()
+		return prev.Close()
+	}
+	return fmt.Errorf("unknown announce target: %s", name)
+}
+
+// start starts the background retry and re-announce routines.
+func (a *announcer) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	if a.retry != nil {
+		for _, t := range a.targets {
+			a.wg.Add(1)
+			go func() {
+				defer a.wg.Done()
+				a.retryLoop(ctx, t)
+			}()
+		}
+	}
+	if a.reannounceInterval > 0 && len(a.targets) > 0 {
+		a.wg.Add(1)
+		go func() {
+			defer a.wg.Done()
+			a.reannounceLoop(ctx)
+		}()
+	}
+}
+
+// announce sends an announcement for the passed CID to all targets. Targets
+// that fail are retried in the background when retries are enabled.
+func (a *announcer) announce(ctx context.Context, c cid.Cid) error {
+	var errs []error
+	for _, t := range a.targets {
+		t.mutex.Lock()
+		t.latest = c
+		t.mutex.Unlock()
+		if err := t.send(ctx, c, a.addrs); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			log.Warnw("Failed to announce advertisement", "target", t.name, "cid", c, "err", err)
+			errs = append(errs, err)
+			a.scheduleRetry(t, c)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *announcer) scheduleRetry(t *announceTarget, c cid.Cid) {
+	if a.retry == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// Only the latest CID needs announcing, so replace any pending retry.
+	select {
+	case <-t.retry:
+	default:
+	}
+	t.retry <- c
+	t.status.Retrying = true
+}
+
+func (a *announcer) retryLoop(ctx context.Context, t *announceTarget) {
+	for {
+		var c cid.Cid
+		select {
+		case <-ctx.Done():
+			return
+		case c = <-t.retry:
+		}
+
+		backoff := a.retry.minBackoff
+		for attempt := 1; a.retry.maxAttempts <= 0 || attempt <= a.retry.maxAttempts; attempt++ {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case newer := <-t.retry:
+				// A newer head superseded this one, start over announcing that.
+				timer.Stop()
+				c = newer
+				backoff = a.retry.minBackoff
+				attempt = 0
+				continue
+			case <-timer.C:
+			}
+
+			if t.superseded(c) {
+				// A newer head was announced since, which is retried separately
+				// if it failed.
+				break
+			}
+			err := t.send(ctx, c, a.addrs)
+			if err == nil {
+				log.Infow("Announced advertisement after retry", "target", t.name, "cid", c, "attempt", attempt)
+				break
+			}
+			log.Warnw("Failed to announce advertisement on retry", "target", t.name, "cid", c, "attempt", attempt, "err", err)
+			backoff = min(backoff*2, a.retry.maxBackoff)
+		}
+		t.mutex.Lock()
+		if len(t.retry) == 0 {
+			t.status.Retrying = false
+		}
+		t.mutex.Unlock()
+	}
+}
+
+func (a *announcer) reannounceLoop(ctx context.Context) {
+	ticker := time.NewTicker(a.reannounceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c, err := a.head(ctx)
+			if err != nil {
+				log.Warnw("Failed to get head for re-announcement", "err", err)
+				continue
+			}
+			if c == cid.Undef {
+				continue
+			}
+			log.Infow("Re-announcing head advertisement", "cid", c)
+			_ = a.announce(ctx, c)
+		}
+	}
+}
+
+// status returns the status of announcements to each target.
+func (a *announcer) status() []AnnounceStatus {
+	statuses := make([]AnnounceStatus, 0, len(a.targets))
+	for _, t := range a.targets {
+		t.mutex.Lock()
+		statuses = append(statuses, t.status)
+		t.mutex.Unlock()
+	}
+	return statuses
+}
+
+// close stops background routines and closes the senders.
+func (a *announcer) close() error {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.wg.Wait()
+	var errs []error
+	for _, t := range a.targets {
+		t.mutex.Lock()
+		sender := t.sender
+		t.mutex.Unlock()
+		if err := sender.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,183 @@
+package publisher_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIndexer is an announce endpoint that fails until it is made available.
+type mockIndexer struct {
+	mutex     sync.Mutex
+	available bool
+	announced []cid.Cid
+}
+
+func (m *mockIndexer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.available {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var msg message.Message
+	if err := msg.UnmarshalCBOR(r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.announced = append(m.announced, msg.Cid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *mockIndexer) setAvailable(available bool) {
+	m.mutex.Lock()
+	m.available = available
+	m.mutex.Unlock()
+}
+
+func (m *mockIndexer) announcements() []cid.Cid {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return slices.Clone(m.announced)
+}
+
+func TestAnnounce(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	provInfo := peer.AddrInfo{ID: pid}
+	ctx := context.Background()
+
+	publish := func(t *testing.T, p *publisher.IPNIPublisher) cid.Cid {
+		digests := testutil.RandomMultihashes(t, 1)
+		l, err := p.Publish(ctx, provInfo, testutil.RandomCID(t).String(), slices.Values(digests), metadata.Default.New())
+		require.NoError(t, err)
+		return l.(cidlink.Link).Cid
+	}
+
+	t.Run("status per target", func(t *testing.T) {
+		up := &mockIndexer{available: true}
+		upServer := httptest.NewServer(up)
+		defer upServer.Close()
+
+		down := &mockIndexer{}
+		downServer := httptest.NewServer(down)
+		defer downServer.Close()
+
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(priv, st, publisher.WithDirectAnnounce(upServer.URL, downServer.URL))
+		require.NoError(t, err)
+		defer p.Close()
+
+		ad := publish(t, p)
+		require.Equal(t, []cid.Cid{ad}, up.announcements())
+
+		statuses := p.AnnounceStatus()
+		require.Len(t, statuses, 2)
+
+		require.Contains(t, statuses[0].Target, upServer.URL)
+		require.Equal(t, ad, statuses[0].LastAnnounced)
+		require.False(t, statuses[0].LastSuccess.IsZero())
+		require.NoError(t, statuses[0].LastError)
+
+		require.Contains(t, statuses[1].Target, downServer.URL)
+		require.Equal(t, cid.Undef, statuses[1].LastAnnounced)
+		require.Error(t, statuses[1].LastError)
+		require.False(t, statuses[1].Retrying)
+	})
+
+	t.Run("retries failed announcements", func(t *testing.T) {
+		indexer := &mockIndexer{}
+		ts := httptest.NewServer(indexer)
+		defer ts.Close()
+
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(priv, st,
+			publisher.WithDirectAnnounce(ts.URL),
+			publisher.WithAnnounceRetry(0, time.Millisecond, 10*time.Millisecond),
+		)
+		require.NoError(t, err)
+		defer p.Close()
+
+		publish(t, p)
+		ad := publish(t, p)
+		require.True(t, p.AnnounceStatus()[0].Retrying)
+
+		indexer.setAvailable(true)
+		require.Eventually(t, func() bool {
+			s := p.AnnounceStatus()[0]
+			return s.LastAnnounced == ad && !s.Retrying
+		}, 5*time.Second, time.Millisecond)
+
+		// only the latest head is retried
+		require.Equal(t, []cid.Cid{ad}, indexer.announcements())
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		indexer := &mockIndexer{}
+		ts := httptest.NewServer(indexer)
+		defer ts.Close()
+
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(priv, st,
+			publisher.WithDirectAnnounce(ts.URL),
+			publisher.WithAnnounceRetry(2, time.Millisecond, time.Millisecond),
+		)
+		require.NoError(t, err)
+		defer p.Close()
+
+		publish(t, p)
+		require.Eventually(t, func() bool {
+			return !p.AnnounceStatus()[0].Retrying
+		}, 5*time.Second, time.Millisecond)
+		require.Error(t, p.AnnounceStatus()[0].LastError)
+	})
+
+	t.Run("periodically re-announces head", func(t *testing.T) {
+		indexer := &mockIndexer{available: true}
+		ts := httptest.NewServer(indexer)
+		defer ts.Close()
+
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(priv, st,
+			publisher.WithDirectAnnounce(ts.URL),
+			publisher.WithReannounceInterval(time.Millisecond),
+		)
+		require.NoError(t, err)
+		defer p.Close()
+
+		ad := publish(t, p)
+		require.Eventually(t, func() bool {
+			return len(indexer.announcements()) > 2
+		}, 5*time.Second, time.Millisecond)
+		for _, c := range indexer.announcements() {
+			require.Equal(t, ad, c)
+		}
+	})
+
+	t.Run("invalid retry options", func(t *testing.T) {
+		st := store.FromDatastore(datastore.NewMapDatastore())
+		_, err := publisher.New(priv, st, publisher.WithAnnounceRetry(1, time.Second, time.Millisecond))
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,104 @@
+package publisher
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
+)
+
+// WithProviderKeys registers the private keys of providers the publisher
+// publishes advertisements for. See [AdvertisementPublisher.AddProvider].
+func WithProviderKeys(keys ...crypto.PrivKey) Option {
+	signers := make([]signer.Signer, 0, len(keys))
+	for _, k := range keys {
+		signers = append(signers, signer.FromPrivKey(k))
+	}
+	return WithProviderSigners(signers...)
+}
+
+// WithProviderSigners registers signers for the providers the publisher
+// publishes advertisements for. See [AdvertisementPublisher.AddProvider].
+func WithProviderSigners(signers ...signer.Signer) Option {
+	return func(opts *options) error {
+		opts.providerSigners = append(opts.providerSigners, signers...)
+		return nil
+	}
+}
+
+// WithRegisteredProvidersOnly configures the publisher to refuse committing
+// advertisements for providers that are neither the publisher itself nor
+// registered with a signer, returning [ErrUnknownProvider]. By default such
+// advertisements are signed by the publisher, which indexers only accept when
+// their policy allows the publisher to publish for the provider.
+func WithRegisteredProvidersOnly() Option {
+	return func(opts *options) error {
+		opts.registeredProvidersOnly = true
+		return nil
+	}
+}
+
+// AddProvider registers a signer for a provider the publisher publishes
+// advertisements for, replacing any signer registered for the same provider.
+// It returns the provider ID the signer is registered for.
+//
+// Advertisements whose provider has a registered signer are signed by the
+// provider, so indexers accept them without the publisher being authorized to
+// publish for the provider. The head is always signed by the publisher. This
+// allows a single publisher to publish for a fleet of providers, e.g. storage
+// nodes whose content is indexed by one indexing node.
+func (p *AdvertisementPublisher) AddProvider(s signer.Signer) (peer.ID, error) {
+	id, err := signer.ID(s)
+	if err != nil {
+		return "", fmt.Errorf("cannot get peer ID from provider signer: %w", err)
+	}
+	p.providersMutex.Lock()
+	defer p.providersMutex.Unlock()
+	p.providers[id] = s
+	log.Infow("Registered provider signer", "provider", id)
+	return id, nil
+}
+
+// RemoveProvider unregisters the signer for the provider. Advertisements for
+// the provider committed afterwards are signed by the publisher, or refused if
+// the publisher was configured with [WithRegisteredProvidersOnly].
+func (p *AdvertisementPublisher) RemoveProvider(id peer.ID) {
+	p.providersMutex.Lock()
+	defer p.providersMutex.Unlock()
+	delete(p.providers, id)
+}
+
+// Providers returns the IDs of the providers with registered signers.
+func (p *AdvertisementPublisher) Providers() []peer.ID {
+	p.providersMutex.RLock()
+	defer p.providersMutex.RUnlock()
+	return slices.Sorted(maps.Keys(p.providers))
+}
+
+// signerFor returns the signer for an advertisement of the provider, given the
+// signer of the publisher.
+func (p *AdvertisementPublisher) signerFor(provider string, self signer.Signer) (signer.Signer, error) {
+	id, err := peer.Decode(provider)
+	if err != nil {
+		return nil, fmt.Errorf("decoding advert provider: %w", err)
+	}
+	p.providersMutex.RLock()
+	s, ok := p.providers[id]
+	p.providersMutex.RUnlock()
+	if ok {
+		return s, nil
+	}
+	if p.registeredProvidersOnly {
+		selfID, err := signer.ID(self)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get peer ID from signer: %w", err)
+		}
+		if id != selfID {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, id)
+		}
+	}
+	return self, nil
+}
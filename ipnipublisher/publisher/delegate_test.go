@@ -0,0 +1,101 @@
+package publisher_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelegatedPublishing(t *testing.T) {
+	ctx := context.Background()
+
+	newKey := func(t *testing.T) (crypto.PrivKey, peer.ID) {
+		priv, _, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+		id, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		return priv, id
+	}
+
+	pubKey, pubID := newKey(t)
+	aKey, aID := newKey(t)
+	bKey, bID := newKey(t)
+
+	t.Run("signs with provider keys", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(pubKey, st, publisher.WithProviderKeys(aKey))
+		require.NoError(t, err)
+		id, err := p.AddProvider(signer.FromPrivKey(bKey))
+		require.NoError(t, err)
+		require.Equal(t, bID, id)
+		require.ElementsMatch(t, []peer.ID{aID, bID}, p.Providers())
+
+		md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+		for _, tc := range []struct {
+			provider peer.ID
+			signer   peer.ID
+		}{
+			{aID, aID},
+			{bID, bID},
+			{pubID, pubID},
+			// unregistered providers are signed by the publisher
+			{testutil.RandomPeer(t), pubID},
+		} {
+			lnk, err := p.Publish(ctx, peer.AddrInfo{ID: tc.provider}, testutil.RandomCID(t).String(), slices.Values(testutil.RandomMultihashes(t, 3)), md)
+			require.NoError(t, err)
+			ad, err := st.Advert(ctx, lnk)
+			require.NoError(t, err)
+			adSigner, err := ad.VerifySignature()
+			require.NoError(t, err)
+			require.Equal(t, tc.signer, adSigner)
+		}
+
+		hd, err := st.Head(ctx)
+		require.NoError(t, err)
+		headSigner, err := hd.Validate()
+		require.NoError(t, err)
+		require.Equal(t, pubID, headSigner)
+
+		report, err := store.Verify(ctx, st, store.WithExpectedSigner(pubID), store.AllowProviderSigned())
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+		report, err = store.Verify(ctx, st, store.WithExpectedSigner(pubID))
+		require.NoError(t, err)
+		require.Len(t, report.Findings, 2)
+	})
+
+	t.Run("registered providers only", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(pubKey, st, publisher.WithProviderKeys(aKey), publisher.WithRegisteredProvidersOnly())
+		require.NoError(t, err)
+
+		md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+		contextID := testutil.RandomCID(t).String()
+		_, err = p.Publish(ctx, peer.AddrInfo{ID: bID}, contextID, slices.Values(testutil.RandomMultihashes(t, 3)), md)
+		require.ErrorIs(t, err, publisher.ErrUnknownProvider)
+		// the failed advert is not left in the tables
+		_, err = st.ChunkLinkForProviderAndContextID(ctx, bID, []byte(contextID))
+		require.True(t, store.IsNotFound(err))
+
+		_, err = p.Publish(ctx, peer.AddrInfo{ID: pubID}, testutil.RandomCID(t).String(), slices.Values(testutil.RandomMultihashes(t, 3)), md)
+		require.NoError(t, err)
+		_, err = p.Publish(ctx, peer.AddrInfo{ID: aID}, testutil.RandomCID(t).String(), slices.Values(testutil.RandomMultihashes(t, 3)), md)
+		require.NoError(t, err)
+
+		p.RemoveProvider(aID)
+		require.Empty(t, p.Providers())
+		_, err = p.Publish(ctx, peer.AddrInfo{ID: aID}, testutil.RandomCID(t).String(), slices.Values(testutil.RandomMultihashes(t, 3)), md)
+		require.ErrorIs(t, err, publisher.ErrUnknownProvider)
+	})
+}
@@ -0,0 +1,273 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+)
+
+// DryRunOutcome is what publishing a context ID would result in.
+type DryRunOutcome string
+
+const (
+	// DryRunNew indicates a new advertisement would be published for a context
+	// ID that is not currently advertised.
+	DryRunNew DryRunOutcome = "new"
+	// DryRunUpdate indicates a new advertisement would be published for a
+	// context ID that is already advertised, because its metadata or entries
+	// changed.
+	DryRunUpdate DryRunOutcome = "update"
+	// DryRunAlreadyAdvertised indicates no advertisement would be published, as
+	// publishing would fail with [ErrAlreadyAdvertised].
+	DryRunAlreadyAdvertised DryRunOutcome = "already-advertised"
+)
+
+// ChangeKind is the kind of change made to a metadata protocol.
+type ChangeKind string
+
+const (
+	ProtocolAdded    ChangeKind = "added"
+	ProtocolRemoved  ChangeKind = "removed"
+	ProtocolModified ChangeKind = "modified"
+)
+
+// ProtocolChange is a change to one protocol of advertised metadata.
+type ProtocolChange struct {
+	Protocol multicodec.Code
+	Kind     ChangeKind
+	// Old and New are the decoded protocol metadata before and after the
+	// change. Old is nil for added protocols and New is nil for removed ones.
+	Old, New metadata.Protocol
+	// Fields are the fields of a modified protocol that changed.
+	Fields []FieldChange
+}
+
+// FieldChange is a change to a field of protocol metadata. Values are in their
+// JSON representation, e.g. CIDs are maps with a single "/" key.
+type FieldChange struct {
+	Field    string
+	Old, New any
+}
+
+// DryRunResult describes what publishing a context ID would do.
+type DryRunResult struct {
+	Provider  peer.ID
+	ContextID []byte
+	Outcome   DryRunOutcome
+	// Advert is the link of the unsigned advertisement stored in the overlay,
+	// or nil if none would be published. Note it differs from the link of the
+	// advertisement that would really be published, which is signed.
+	Advert ipld.Link
+	// EntriesChanged is true if a new entry chain would be written for a
+	// context ID that is already advertised.
+	EntriesChanged bool
+	// OldMetadata is the currently advertised metadata, which is empty for new
+	// context IDs, and NewMetadata is the metadata that would be advertised.
+	OldMetadata, NewMetadata metadata.Metadata
+	// MetadataDiff lists the changes from the old to the new metadata, ordered
+	// by protocol ID.
+	MetadataDiff []ProtocolChange
+}
+
+// DryRunPublisher generates advertisements against a copy-on-write overlay of
+// a publisher store and reports what would be published, without signing,
+// storing or announcing anything in the real advertisement chain. It can be
+// used in place of an [IPNIPublisher] to preview the effect of changes, e.g.
+// to the metadata being published.
+type DryRunPublisher struct {
+	overlay       *store.Overlay
+	updateEntries bool
+
+	mutex   sync.Mutex
+	prev    ipld.Link
+	results []DryRunResult
+}
+
+var _ Publisher = (*DryRunPublisher)(nil)
+
+// NewDryRun creates a dry-run publisher over the overlay, which should be
+// created with the same store options as the store being previewed. Publisher
+// options that affect advertisement generation, such as [WithUpdateEntries],
+// are applied in the same way as by [New]. Others are ignored.
+func NewDryRun(overlay *store.Overlay, opts ...Option) (*DryRunPublisher, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return &DryRunPublisher{overlay: overlay, updateEntries: o.updateEntries}, nil
+}
+
+// Publish implements [Publisher], recording what would be published. Like
+// [IPNIPublisher.Publish], it fails with [ErrAlreadyAdvertised] when nothing
+// would be published. Later calls see the changes made by earlier ones.
+func (d *DryRunPublisher) Publish(ctx context.Context, provider peer.AddrInfo, contextID string, digests iter.Seq[mh.Multihash], meta metadata.Metadata) (ipld.Link, error) {
+	res, err := d.DryRun(ctx, provider, contextID, digests, meta)
+	if err != nil {
+		return nil, fmt.Errorf("publishing IPNI advert: %w", err)
+	}
+	if res.Outcome == DryRunAlreadyAdvertised {
+		return nil, fmt.Errorf("publishing IPNI advert: %w", ErrAlreadyAdvertised)
+	}
+	return res.Advert, nil
+}
+
+// DryRun reports what publishing the context ID would do.
+func (d *DryRunPublisher) DryRun(ctx context.Context, provider peer.AddrInfo, contextID string, digests iter.Seq[mh.Multihash], meta metadata.Metadata) (DryRunResult, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	res := DryRunResult{Provider: provider.ID, ContextID: []byte(contextID), NewMetadata: meta}
+
+	oldChunkLink, err := d.overlay.ChunkLinkForProviderAndContextID(ctx, provider.ID, res.ContextID)
+	if err != nil && !store.IsNotFound(err) {
+		return res, fmt.Errorf("could not get entries cid by provider + context id: %w", err)
+	}
+	res.OldMetadata, err = d.overlay.MetadataForProviderAndContextID(ctx, provider.ID, res.ContextID)
+	if err != nil && !store.IsNotFound(err) {
+		return res, fmt.Errorf("could not get metadata for provider + context id: %w", err)
+	}
+	res.MetadataDiff, err = DiffMetadata(res.OldMetadata, meta)
+	if err != nil {
+		return res, err
+	}
+
+	var genOpts []GenerateAdOption
+	if d.updateEntries {
+		genOpts = append(genOpts, UpdateEntries())
+	}
+	ad, err := GenerateAd(ctx, d.overlay, provider.ID, provider.Addrs, res.ContextID, meta, false, digests, genOpts...)
+	if err != nil {
+		if errors.Is(err, ErrAlreadyAdvertised) {
+			res.Outcome = DryRunAlreadyAdvertised
+			d.results = append(d.results, res)
+			return res, nil
+		}
+		return res, err
+	}
+
+	res.Outcome = DryRunNew
+	if oldChunkLink != nil {
+		res.Outcome = DryRunUpdate
+		res.EntriesChanged = oldChunkLink.String() != ad.Entries.String()
+	}
+
+	if d.prev == nil {
+		hd, err := d.overlay.Head(ctx)
+		if err != nil && !store.IsNotFound(err) {
+			return res, fmt.Errorf("could not get latest advertisement: %w", err)
+		}
+		if hd != nil {
+			d.prev = hd.Head
+		}
+	}
+	ad.PreviousID = d.prev
+	res.Advert, err = d.overlay.PutAdvert(ctx, ad)
+	if err != nil {
+		return res, err
+	}
+	d.prev = res.Advert
+
+	d.results = append(d.results, res)
+	return res, nil
+}
+
+// Results returns the results of all dry runs so far, in the order they ran.
+func (d *DryRunPublisher) Results() []DryRunResult {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return slices.Clone(d.results)
+}
+
+// DiffMetadata decodes the protocols of the old and new metadata and returns
+// the changes between them, ordered by protocol ID.
+func DiffMetadata(old, new metadata.Metadata) ([]ProtocolChange, error) {
+	codes := append(old.Protocols(), new.Protocols()...)
+	slices.Sort(codes)
+	codes = slices.Compact(codes)
+
+	var changes []ProtocolChange
+	for _, code := range codes {
+		oldP, newP := old.Get(code), new.Get(code)
+		switch {
+		case oldP == nil:
+			changes = append(changes, ProtocolChange{Protocol: code, Kind: ProtocolAdded, New: newP})
+		case newP == nil:
+			changes = append(changes, ProtocolChange{Protocol: code, Kind: ProtocolRemoved, Old: oldP})
+		default:
+			fields, err := diffFields(oldP, newP)
+			if err != nil {
+				return nil, fmt.Errorf("diffing %s metadata: %w", code, err)
+			}
+			if len(fields) > 0 {
+				changes = append(changes, ProtocolChange{Protocol: code, Kind: ProtocolModified, Old: oldP, New: newP, Fields: fields})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// diffFields compares the JSON representation of two protocols field by
+// field. Protocols that do not encode to JSON objects are compared as a
+// whole, reported with an empty field name.
+func diffFields(old, new metadata.Protocol) ([]FieldChange, error) {
+	oldV, err := jsonValue(old)
+	if err != nil {
+		return nil, err
+	}
+	newV, err := jsonValue(new)
+	if err != nil {
+		return nil, err
+	}
+	oldM, oldOK := oldV.(map[string]any)
+	newM, newOK := newV.(map[string]any)
+	if !oldOK || !newOK {
+		if reflect.DeepEqual(oldV, newV) {
+			return nil, nil
+		}
+		return []FieldChange{{Old: oldV, New: newV}}, nil
+	}
+
+	names := make([]string, 0, len(oldM)+len(newM))
+	for name := range oldM {
+		names = append(names, name)
+	}
+	for name := range newM {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	var fields []FieldChange
+	for _, name := range names {
+		if !reflect.DeepEqual(oldM[name], newM[name]) {
+			fields = append(fields, FieldChange{Field: name, Old: oldM[name], New: newM[name]})
+		}
+	}
+	return fields, nil
+}
+
+func jsonValue(p metadata.Protocol) (any, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
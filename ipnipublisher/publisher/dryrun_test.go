@@ -0,0 +1,143 @@
+package publisher_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	smd "github.com/storacha/go-libstoracha/metadata"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	provider := peer.AddrInfo{ID: pid}
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()), store.WithMetadataContext(smd.MetadataContext))
+	p, err := publisher.New(priv, st)
+	require.NoError(t, err)
+
+	claim := testutil.RandomCID(t).(cidlink.Link).Cid
+	locationMetadata := func(expiration int64) metadata.Metadata {
+		return smd.MetadataContext.New(&smd.LocationCommitmentMetadata{Claim: claim, Expiration: expiration})
+	}
+
+	unchangedID := testutil.RandomCID(t).String()
+	unchangedDigests := testutil.RandomMultihashes(t, 3)
+	_, err = p.Publish(ctx, provider, unchangedID, slices.Values(unchangedDigests), locationMetadata(100))
+	require.NoError(t, err)
+	changedID := testutil.RandomCID(t).String()
+	changedDigests := testutil.RandomMultihashes(t, 3)
+	_, err = p.Publish(ctx, provider, changedID, slices.Values(changedDigests), locationMetadata(100))
+	require.NoError(t, err)
+	hd, err := st.Head(ctx)
+	require.NoError(t, err)
+
+	overlay := store.NewOverlay(st, store.WithMetadataContext(smd.MetadataContext))
+	dr, err := publisher.NewDryRun(overlay)
+	require.NoError(t, err)
+
+	_, err = dr.Publish(ctx, provider, unchangedID, slices.Values(unchangedDigests), locationMetadata(100))
+	require.ErrorIs(t, err, publisher.ErrAlreadyAdvertised)
+
+	updated, err := dr.Publish(ctx, provider, changedID, slices.Values(changedDigests), locationMetadata(200))
+	require.NoError(t, err)
+
+	newID := testutil.RandomCID(t).String()
+	added, err := dr.Publish(ctx, provider, newID, slices.Values(testutil.RandomMultihashes(t, 3)), locationMetadata(100))
+	require.NoError(t, err)
+
+	results := dr.Results()
+	require.Len(t, results, 3)
+
+	require.Equal(t, publisher.DryRunAlreadyAdvertised, results[0].Outcome)
+	require.Nil(t, results[0].Advert)
+	require.Empty(t, results[0].MetadataDiff)
+
+	require.Equal(t, publisher.DryRunUpdate, results[1].Outcome)
+	require.Equal(t, updated, results[1].Advert)
+	require.False(t, results[1].EntriesChanged)
+	require.Len(t, results[1].MetadataDiff, 1)
+	change := results[1].MetadataDiff[0]
+	require.Equal(t, publisher.ProtocolModified, change.Kind)
+	require.Equal(t, multicodec.Code(smd.LocationCommitmentID), change.Protocol)
+	require.Equal(t, []publisher.FieldChange{{Field: "Expiration", Old: float64(100), New: float64(200)}}, change.Fields)
+
+	require.Equal(t, publisher.DryRunNew, results[2].Outcome)
+	require.Equal(t, added, results[2].Advert)
+	require.Len(t, results[2].MetadataDiff, 1)
+	require.Equal(t, publisher.ProtocolAdded, results[2].MetadataDiff[0].Kind)
+
+	// the dry-run adverts are chained from the real head
+	overlayAd, err := overlay.Advert(ctx, updated)
+	require.NoError(t, err)
+	require.Equal(t, hd.Head, overlayAd.PreviousID)
+
+	// the real store is untouched
+	after, err := st.Head(ctx)
+	require.NoError(t, err)
+	require.Equal(t, hd.Head, after.Head)
+	md, err := st.MetadataForProviderAndContextID(ctx, pid, []byte(changedID))
+	require.NoError(t, err)
+	require.True(t, md.Equal(locationMetadata(100)))
+	_, err = st.ChunkLinkForProviderAndContextID(ctx, pid, []byte(newID))
+	require.True(t, store.IsNotFound(err))
+
+	// publishing the same again in the dry run sees the earlier dry run
+	_, err = dr.Publish(ctx, provider, changedID, slices.Values(changedDigests), locationMetadata(200))
+	require.ErrorIs(t, err, publisher.ErrAlreadyAdvertised)
+
+	// in update mode the entries are read from the digests only once
+	updatingOverlay := store.NewOverlay(st, store.WithMetadataContext(smd.MetadataContext))
+	updating, err := publisher.NewDryRun(updatingOverlay, publisher.WithUpdateEntries())
+	require.NoError(t, err)
+	digests := testutil.RandomMultihashes(t, 3)
+	res, err := updating.DryRun(ctx, provider, testutil.RandomCID(t).String(), singleUse(digests), locationMetadata(100))
+	require.NoError(t, err)
+	ad, err := updatingOverlay.Advert(ctx, res.Advert)
+	require.NoError(t, err)
+	var ents []multihash.Multihash
+	for e, err := range updatingOverlay.Entries(ctx, ad.Entries) {
+		require.NoError(t, err)
+		ents = append(ents, e)
+	}
+	require.Equal(t, digests, ents)
+}
+
+func TestDiffMetadata(t *testing.T) {
+	old := metadata.Default.New(&metadata.IpfsGatewayHttp{}, metadata.Bitswap{})
+	new := metadata.Default.New(&metadata.IpfsGatewayHttp{}, &metadata.GraphsyncFilecoinV1{PieceCID: testutil.RandomCID(t).(cidlink.Link).Cid})
+
+	changes, err := publisher.DiffMetadata(old, new)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, []string{"transport-bitswap:removed", "transport-graphsync-filecoinv1:added"}, multicodecKinds(changes))
+
+	changes, err = publisher.DiffMetadata(old, old)
+	require.NoError(t, err)
+	require.Empty(t, changes)
+}
+
+func multicodecKinds(changes []publisher.ProtocolChange) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.Protocol.String()+":"+string(c.Kind))
+	}
+	return s
+}
@@ -10,4 +10,12 @@ var (
 	// ErrAlreadyAdvertised signals that an advertisement for identical content
 	// was already published.
 	ErrAlreadyAdvertised = errors.New("advertisement already published")
+
+	// ErrNotLeader signals that the publisher does not hold the leader lease, so
+	// it must not commit to the advertisement chain.
+	ErrNotLeader = errors.New("publisher is not the leader")
+
+	// ErrUnknownProvider signals that the publisher has no signer registered for
+	// the provider of an advertisement.
+	ErrUnknownProvider = errors.New("unknown provider")
 )
@@ -0,0 +1,529 @@
+package publisher
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/jobqueue"
+	smd "github.com/storacha/go-libstoracha/metadata"
+)
+
+// Renewer decides whether a claim that is about to expire is renewed. It
+// returns the metadata to republish the claim with, which should have a later
+// expiration, or false to let the claim expire.
+type Renewer func(ctx context.Context, record store.ContextIDRecord) (metadata.Metadata, bool, error)
+
+// ExpiryOption is an option configuring an expiry scheduler.
+type ExpiryOption func(cfg *expiryConfig)
+
+type expiryConfig struct {
+	interval       time.Duration
+	rescanInterval time.Duration
+	renewWindow    time.Duration
+	renewer        Renewer
+	provider       peer.ID
+	locker         sync.Locker
+	queueOptions   []jobqueue.Option
+}
+
+// WithScanInterval configures how often the claims that are due are queued.
+// Due claims are found in an index ordered by expiration, so the interval does
+// not affect the cost of finding them. The default is one minute.
+func WithScanInterval(interval time.Duration) ExpiryOption {
+	return func(cfg *expiryConfig) {
+		cfg.interval = interval
+	}
+}
+
+// WithRescanInterval configures how often the whole metadata table of the
+// store is scanned to index the claims with an expiration. Between rescans,
+// claims committed through the publisher are indexed as they are published;
+// rescanning picks up claims published by other means, e.g. by another
+// process. The default is one hour.
+func WithRescanInterval(interval time.Duration) ExpiryOption {
+	return func(cfg *expiryConfig) {
+		cfg.rescanInterval = interval
+	}
+}
+
+// WithRenewer configures the scheduler to offer claims expiring within the
+// window to the renewer, and republish the ones it renews. Claims are offered
+// on every scan until they are renewed or expire. Without a renewer, claims
+// are only removed once expired.
+func WithRenewer(renewer Renewer, window time.Duration) ExpiryOption {
+	return func(cfg *expiryConfig) {
+		cfg.renewer = renewer
+		cfg.renewWindow = window
+	}
+}
+
+// WithExpiryProvider restricts the scheduler to the claims of a provider. By
+// default the claims of all providers in the store are handled.
+func WithExpiryProvider(provider peer.ID) ExpiryOption {
+	return func(cfg *expiryConfig) {
+		cfg.provider = provider
+	}
+}
+
+// WithCommitLocker configures a lock held while the scheduler generates and
+// commits advertisements, in addition to the commit lock of the publisher (see
+// [AdvertisementPublisher.CommitLocker]), which is always held. It is taken
+// before the commit lock, e.g. to coordinate with callers that hold it while
+// publishing.
+func WithCommitLocker(locker sync.Locker) ExpiryOption {
+	return func(cfg *expiryConfig) {
+		cfg.locker = locker
+	}
+}
+
+// WithExpiryQueueOptions configures the job queue that expiring claims are
+// processed on. Claims queued while a batch is being processed are committed
+// together in the next one.
+func WithExpiryQueueOptions(opts ...jobqueue.Option) ExpiryOption {
+	return func(cfg *expiryConfig) {
+		cfg.queueOptions = append(cfg.queueOptions, opts...)
+	}
+}
+
+// ExpiryScheduler tracks published claims by the expiration in their metadata
+// (see [smd.ExpiresAt]), using the metadata table of the publisher store. It
+// republishes claims that are renewed before they lapse, and publishes removal
+// advertisements for claims that expired.
+//
+// Claims are kept in memory in an index ordered by the time they are due, so
+// the metadata table is only scanned in full on start and every rescan
+// interval (see [WithRescanInterval]).
+type ExpiryScheduler struct {
+	cfg       expiryConfig
+	publisher *AdvertisementPublisher
+	store     store.QueryableStore
+	queue     *jobqueue.JobQueue[expiryJob]
+
+	mutex    sync.Mutex
+	inFlight map[string]struct{}
+	// index orders tracked claims by due time. Entries superseded in tracked
+	// are skipped when popped.
+	index      expiryHeap
+	tracked    map[string]trackedJob
+	cancel     context.CancelFunc
+	done       chan struct{}
+	removeHook func()
+}
+
+type expiryJob struct {
+	record    store.ContextIDRecord
+	expiresAt time.Time
+}
+
+func (j expiryJob) key() string {
+	return expiryKey(j.record.Provider, j.record.ContextID)
+}
+
+func expiryKey(provider peer.ID, contextID []byte) string {
+	contextKey, _ := multibase.Encode(multibase.Base58BTC, contextID)
+	return provider.String() + "/" + contextKey
+}
+
+// trackedJob is a claim in the expiry index, with the time it is due.
+type trackedJob struct {
+	job expiryJob
+	due time.Time
+}
+
+// expiryHeap is a min-heap of tracked claims ordered by due time.
+type expiryHeap []trackedJob
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h expiryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x any)        { *h = append(*h, x.(trackedJob)) }
+func (h *expiryHeap) Pop() any {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
+
+// endOfTime is later than any expiration, to scan every claim that expires.
+var endOfTime = time.Unix(1<<62, 0)
+
+// NewExpiryScheduler creates a scheduler publishing through the publisher. The
+// publisher store must implement [store.QueryableStore].
+func NewExpiryScheduler(p *AdvertisementPublisher, opts ...ExpiryOption) (*ExpiryScheduler, error) {
+	cfg := expiryConfig{interval: time.Minute, rescanInterval: time.Hour}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.locker == sync.Locker(&p.commitMutex) {
+		cfg.locker = nil
+	}
+	qs, ok := p.store.(store.QueryableStore)
+	if !ok {
+		return nil, fmt.Errorf("publisher store: %w", store.ErrNotListable)
+	}
+	s := &ExpiryScheduler{
+		cfg:       cfg,
+		publisher: p,
+		store:     qs,
+		inFlight:  map[string]struct{}{},
+		tracked:   map[string]trackedJob{},
+	}
+	queueOpts := append([]jobqueue.Option{jobqueue.WithErrorHandler(func(err error) {
+		log.Errorw("Failed to process expiring claims", "err", err)
+	})}, cfg.queueOptions...)
+	s.queue = jobqueue.NewJobQueue[expiryJob](jobqueue.MultiJobHandler(s.handle), queueOpts...)
+	return s, nil
+}
+
+// Start starts the job queue and scans the store in the background until Stop
+// is called or the context is canceled. Calling Start on a started scheduler
+// has no effect.
+func (s *ExpiryScheduler) Start(ctx context.Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.removeHook = s.publisher.addCommitHook(s.committed)
+	s.queue.Startup()
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.cfg.interval)
+		defer ticker.Stop()
+		var scanned time.Time
+		for {
+			var err error
+			if time.Since(scanned) >= s.cfg.rescanInterval {
+				scanned = time.Now()
+				_, err = s.Scan(ctx)
+			} else {
+				_, err = s.queueDue(ctx)
+			}
+			if err != nil && ctx.Err() == nil {
+				log.Errorw("Failed to scan for expiring claims", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops scanning and shuts down the job queue, waiting for queued claims
+// to be processed or the context to be canceled. A stopped scheduler cannot
+// be started again.
+func (s *ExpiryScheduler) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	cancel, done, removeHook := s.cancel, s.done, s.removeHook
+	s.cancel = nil
+	s.mutex.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	removeHook()
+	cancel()
+	<-done
+	return s.queue.Shutdown(ctx)
+}
+
+// Scan scans the whole metadata table of the store to index the claims with an
+// expiration, then queues the claims that expired, or that expire within the
+// renew window when a renewer is configured, and returns the number queued.
+// Claims already queued are skipped. The job queue must have been started with
+// Start. When the publisher is configured with a leader election, nothing is
+// scanned or queued unless it holds the lease.
+func (s *ExpiryScheduler) Scan(ctx context.Context) (int, error) {
+	if s.publisher.checkLeader() != nil {
+		return 0, nil
+	}
+	for record, err := range s.store.ExpiredContextIDs(ctx, s.cfg.provider, endOfTime) {
+		if err != nil {
+			return 0, err
+		}
+		expiresAt, _ := smd.ExpiresAt(record.Metadata)
+		s.track(expiryJob{record: record, expiresAt: expiresAt})
+	}
+	return s.queueDue(ctx)
+}
+
+// queueDue queues the indexed claims that are due and returns the number
+// queued.
+func (s *ExpiryScheduler) queueDue(ctx context.Context) (int, error) {
+	if s.publisher.checkLeader() != nil {
+		return 0, nil
+	}
+	due := s.popDue(time.Now())
+	queued := 0
+	for i, job := range due {
+		if !s.markInFlight(job.key()) {
+			continue
+		}
+		if err := s.queue.Queue(ctx, job); err != nil {
+			s.clearInFlight(job.key())
+			s.track(due[i:]...)
+			return queued, err
+		}
+		queued++
+	}
+	if queued > 0 {
+		log.Infow("Queued expiring claims", "count", queued)
+	}
+	return queued, nil
+}
+
+// due returns the time a claim is due: its expiration, less the renew window
+// when a renewer is configured.
+func (s *ExpiryScheduler) due(j expiryJob) time.Time {
+	if s.cfg.renewer != nil {
+		return j.expiresAt.Add(-s.cfg.renewWindow)
+	}
+	return j.expiresAt
+}
+
+// track adds claims to the index, replacing the claims of the same provider and
+// context ID.
+func (s *ExpiryScheduler) track(jobs ...expiryJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, j := range jobs {
+		t := trackedJob{job: j, due: s.due(j)}
+		prev, ok := s.tracked[j.key()]
+		s.tracked[j.key()] = t
+		if !ok || !prev.due.Equal(t.due) {
+			heap.Push(&s.index, t)
+		}
+	}
+}
+
+// popDue removes the claims due before now from the index, returning them.
+func (s *ExpiryScheduler) popDue(now time.Time) []expiryJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var due []expiryJob
+	for len(s.index) > 0 && s.index[0].due.Before(now) {
+		t := heap.Pop(&s.index).(trackedJob)
+		cur, ok := s.tracked[t.job.key()]
+		if !ok || !cur.due.Equal(t.due) {
+			// superseded or removed since it was indexed
+			continue
+		}
+		delete(s.tracked, t.job.key())
+		due = append(due, cur.job)
+	}
+	return due
+}
+
+// committed indexes the claims committed through the publisher, and stops
+// tracking the claims removed.
+func (s *ExpiryScheduler) committed(ctx context.Context, ads []schema.Advertisement) {
+	for _, ad := range ads {
+		provider, err := peer.Decode(ad.Provider)
+		if err != nil || (s.cfg.provider != "" && provider != s.cfg.provider) {
+			continue
+		}
+		if ad.IsRm {
+			s.mutex.Lock()
+			delete(s.tracked, expiryKey(provider, ad.ContextID))
+			s.mutex.Unlock()
+			continue
+		}
+		md, err := s.publisher.store.MetadataForProviderAndContextID(ctx, provider, ad.ContextID)
+		if err != nil {
+			if !store.IsNotFound(err) {
+				log.Warnw("Failed to read metadata of committed claim, it is indexed on the next rescan", "provider", provider, "err", err)
+			}
+			continue
+		}
+		if expiresAt, ok := smd.ExpiresAt(md); ok {
+			s.track(expiryJob{record: store.ContextIDRecord{Provider: provider, ContextID: ad.ContextID, Metadata: md}, expiresAt: expiresAt})
+		}
+	}
+}
+
+func (s *ExpiryScheduler) markInFlight(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.inFlight[key]; ok {
+		return false
+	}
+	s.inFlight[key] = struct{}{}
+	return true
+}
+
+func (s *ExpiryScheduler) clearInFlight(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.inFlight, key)
+}
+
+// expiryRestore holds the table entries overwritten when generating an
+// advertisement, so they can be restored if the commit fails.
+type expiryRestore struct {
+	provider      peer.ID
+	contextID     []byte
+	chunkLink     ipld.Link
+	metadata      metadata.Metadata
+	digestSetHash mh.Multihash
+}
+
+// handle generates renewal and removal advertisements for a batch of expiring
+// claims and commits them together. If it fails, the claims are indexed again
+// to be retried.
+func (s *ExpiryScheduler) handle(ctx context.Context, jobs []expiryJob) (err error) {
+	defer func() {
+		for _, j := range jobs {
+			s.clearInFlight(j.key())
+		}
+		if err != nil {
+			s.track(jobs...)
+		}
+	}()
+
+	if s.cfg.locker != nil {
+		s.cfg.locker.Lock()
+		defer s.cfg.locker.Unlock()
+	}
+	s.publisher.commitMutex.Lock()
+	defer s.publisher.commitMutex.Unlock()
+
+	if err := s.publisher.checkLeader(); err != nil {
+		return err
+	}
+
+	var ads []schema.Advertisement
+	var restores []expiryRestore
+	renewed, removed := 0, 0
+	for _, j := range jobs {
+		ad, restore, ok, err := s.advert(ctx, j)
+		if err != nil {
+			s.restore(ctx, restores)
+			return err
+		}
+		if !ok {
+			continue
+		}
+		ads = append(ads, ad)
+		restores = append(restores, restore)
+		if ad.IsRm {
+			removed++
+		} else {
+			renewed++
+		}
+	}
+	if len(ads) == 0 {
+		return nil
+	}
+
+	for _, ad := range ads {
+		if err := s.publisher.AddToBatch(ad); err != nil {
+			s.restore(ctx, restores)
+			return err
+		}
+	}
+	lnk, err := s.publisher.Commit(ctx)
+	if err != nil {
+		s.restore(ctx, restores)
+		return fmt.Errorf("committing expiry adverts: %w", err)
+	}
+	log.Infow("Published expiry adverts", "head", lnk, "renewed", renewed, "removed", removed)
+	return nil
+}
+
+// advert generates the advertisement for an expiring claim: a renewal if the
+// renewer renews it, a removal if it expired, or none otherwise.
+func (s *ExpiryScheduler) advert(ctx context.Context, j expiryJob) (schema.Advertisement, expiryRestore, bool, error) {
+	r := j.record
+	restore := expiryRestore{provider: r.Provider, contextID: r.ContextID, metadata: r.Metadata}
+	log := log.With("provider", r.Provider, "contextID", fmt.Sprintf("%x", r.ContextID), "expiresAt", j.expiresAt)
+
+	// The claim may have been republished or removed since it was queued.
+	current, err := s.publisher.store.MetadataForProviderAndContextID(ctx, r.Provider, r.ContextID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return schema.Advertisement{}, restore, false, nil
+		}
+		return schema.Advertisement{}, restore, false, fmt.Errorf("reading metadata: %w", err)
+	}
+	if !current.Equal(r.Metadata) {
+		return schema.Advertisement{}, restore, false, nil
+	}
+	restore.chunkLink, err = s.publisher.store.ChunkLinkForProviderAndContextID(ctx, r.Provider, r.ContextID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			log.Warn("Expiring claim has metadata but no entries, skipping")
+			return schema.Advertisement{}, restore, false, nil
+		}
+		return schema.Advertisement{}, restore, false, fmt.Errorf("reading chunk link: %w", err)
+	}
+	restore.digestSetHash, err = digestSets(s.publisher.store).DigestSetHashForProviderAndContextID(ctx, r.Provider, r.ContextID)
+	if err != nil && !store.IsNotFound(err) {
+		return schema.Advertisement{}, restore, false, fmt.Errorf("reading digest set hash: %w", err)
+	}
+
+	if s.cfg.renewer != nil {
+		md, ok, err := s.cfg.renewer(ctx, r)
+		if err != nil {
+			return schema.Advertisement{}, restore, false, fmt.Errorf("renewing claim: %w", err)
+		}
+		if ok {
+			// The existing entries are reused, so no multihashes are needed.
+			ad, err := GenerateAd(ctx, s.publisher.store, r.Provider, nil, r.ContextID, md, false, nil)
+			if err != nil {
+				if errors.Is(err, ErrAlreadyAdvertised) {
+					log.Warn("Renewed claim has unchanged metadata, skipping")
+					return schema.Advertisement{}, restore, false, nil
+				}
+				return schema.Advertisement{}, restore, false, err
+			}
+			log.Info("Renewing claim")
+			return ad, restore, true, nil
+		}
+	}
+
+	if !j.expiresAt.Before(time.Now()) {
+		// offered to the renewer again until it is renewed or expires
+		s.track(j)
+		return schema.Advertisement{}, restore, false, nil
+	}
+	ad, err := GenerateAd(ctx, s.publisher.store, r.Provider, nil, r.ContextID, metadata.Default.New(), true, nil)
+	if err != nil {
+		return schema.Advertisement{}, restore, false, err
+	}
+	log.Info("Removing expired claim")
+	return ad, restore, true, nil
+}
+
+// restore puts back the table entries of claims whose advertisements were
+// generated but not committed.
+func (s *ExpiryScheduler) restore(ctx context.Context, restores []expiryRestore) {
+	for _, r := range restores {
+		if err := s.publisher.store.PutChunkLinkForProviderAndContextID(ctx, r.provider, r.contextID, r.chunkLink); err != nil {
+			log.Errorw("Failed to restore chunk link", "provider", r.provider, "err", err)
+		}
+		if err := s.publisher.store.PutMetadataForProviderAndContextID(ctx, r.provider, r.contextID, r.metadata); err != nil {
+			log.Errorw("Failed to restore metadata", "provider", r.provider, "err", err)
+		}
+		if r.digestSetHash != nil {
+			if err := digestSets(s.publisher.store).PutDigestSetHashForProviderAndContextID(ctx, r.provider, r.contextID, r.digestSetHash); err != nil {
+				log.Errorw("Failed to restore digest set hash", "provider", r.provider, "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package publisher_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	smd "github.com/storacha/go-libstoracha/metadata"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiryScheduler(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()), store.WithMetadataContext(smd.MetadataContext))
+	ap, err := publisher.NewAdvertisementPublisher(priv, st)
+	require.NoError(t, err)
+
+	claim := testutil.RandomCID(t).(cidlink.Link).Cid
+	now := time.Now()
+	locationMetadata := func(expiresAt time.Time) metadata.Metadata {
+		return smd.MetadataContext.New(&smd.LocationCommitmentMetadata{Claim: claim, Expiration: expiresAt.Unix()})
+	}
+	var commitMutex sync.Mutex
+	publish := func(contextID string, md metadata.Metadata) {
+		commitMutex.Lock()
+		defer commitMutex.Unlock()
+		ad, err := publisher.GenerateAd(ctx, st, pid, nil, []byte(contextID), md, false, slices.Values(testutil.RandomMultihashes(t, 3)))
+		require.NoError(t, err)
+		require.NoError(t, ap.AddToBatch(ad))
+		_, err = ap.Commit(ctx)
+		require.NoError(t, err)
+	}
+	publish("expired", locationMetadata(now.Add(-time.Hour)))
+	publish("expiring", locationMetadata(now.Add(10*time.Minute)))
+	publish("unrenewed", locationMetadata(now.Add(20*time.Minute)))
+	publish("later", locationMetadata(now.Add(10*time.Hour)))
+	publish("forever", metadata.Default.New(&metadata.IpfsGatewayHttp{}))
+
+	renewedAt := now.Add(24 * time.Hour)
+	var offeredMutex sync.Mutex
+	var offered []string
+	renewer := func(ctx context.Context, r store.ContextIDRecord) (metadata.Metadata, bool, error) {
+		offeredMutex.Lock()
+		offered = append(offered, string(r.ContextID))
+		offeredMutex.Unlock()
+		if string(r.ContextID) != "expiring" {
+			return metadata.Metadata{}, false, nil
+		}
+		return locationMetadata(renewedAt), true, nil
+	}
+
+	s, err := publisher.NewExpiryScheduler(ap,
+		publisher.WithRenewer(renewer, time.Hour),
+		publisher.WithExpiryProvider(pid),
+		publisher.WithCommitLocker(&commitMutex),
+		publisher.WithScanInterval(time.Hour),
+	)
+	require.NoError(t, err)
+	s.Start(ctx)
+
+	// wait for the renewal and removal to be committed
+	require.Eventually(t, func() bool {
+		_, err := st.MetadataForProviderAndContextID(ctx, pid, []byte("expired"))
+		if !store.IsNotFound(err) {
+			return false
+		}
+		md, err := st.MetadataForProviderAndContextID(ctx, pid, []byte("expiring"))
+		require.NoError(t, err)
+		return md.Equal(locationMetadata(renewedAt))
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, s.Stop(ctx))
+
+	offeredMutex.Lock()
+	require.ElementsMatch(t, []string{"expired", "expiring", "unrenewed"}, offered)
+	offeredMutex.Unlock()
+
+	// claims that did not expire yet and were not renewed are left alone
+	md, err := st.MetadataForProviderAndContextID(ctx, pid, []byte("unrenewed"))
+	require.NoError(t, err)
+	require.True(t, md.Equal(locationMetadata(now.Add(20*time.Minute))))
+	_, err = st.ChunkLinkForProviderAndContextID(ctx, pid, []byte("later"))
+	require.NoError(t, err)
+
+	report, err := store.Verify(ctx, st)
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Findings)
+	require.Equal(t, 7, report.Adverts)
+
+	hd, err := st.Head(ctx)
+	require.NoError(t, err)
+	var kinds []bool
+	for cur := hd.Head; len(kinds) < 2; {
+		ad, err := st.Advert(ctx, cur)
+		require.NoError(t, err)
+		kinds = append(kinds, ad.IsRm)
+		cur = ad.PreviousID
+	}
+	// the newest adverts are the removal and the renewal
+	require.ElementsMatch(t, []bool{true, false}, kinds)
+}
+
+// countingStore counts full scans of the metadata table for expiring claims.
+type countingStore struct {
+	store.FullStore
+	scans atomic.Int64
+}
+
+func (s *countingStore) QueryContextIDs(ctx context.Context, q store.ContextIDQuery) (store.ContextIDPage, error) {
+	return s.FullStore.(store.QueryableStore).QueryContextIDs(ctx, q)
+}
+
+func (s *countingStore) ExpiredContextIDs(ctx context.Context, p peer.ID, before time.Time) iter.Seq2[store.ContextIDRecord, error] {
+	s.scans.Add(1)
+	return s.FullStore.(store.QueryableStore).ExpiredContextIDs(ctx, p, before)
+}
+
+func TestExpirySchedulerIndex(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	st := &countingStore{FullStore: store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()), store.WithMetadataContext(smd.MetadataContext))}
+	ap, err := publisher.NewAdvertisementPublisher(priv, st)
+	require.NoError(t, err)
+
+	// the commit lock of the publisher is used by default
+	s, err := publisher.NewExpiryScheduler(ap, publisher.WithScanInterval(5*time.Millisecond))
+	require.NoError(t, err)
+	s.Start(ctx)
+	defer s.Stop(ctx)
+	require.Eventually(t, func() bool { return st.scans.Load() == 1 }, 5*time.Second, time.Millisecond)
+
+	// claims committed through the publisher are indexed without scanning the
+	// store again
+	claim := testutil.RandomCID(t).(cidlink.Link).Cid
+	md := smd.MetadataContext.New(&smd.LocationCommitmentMetadata{Claim: claim, Expiration: time.Now().Add(time.Second).Unix()})
+	func() {
+		ap.CommitLocker().Lock()
+		defer ap.CommitLocker().Unlock()
+		ad, err := publisher.GenerateAd(ctx, st, pid, nil, []byte("claim"), md, false, slices.Values(testutil.RandomMultihashes(t, 3)))
+		require.NoError(t, err)
+		require.NoError(t, ap.AddToBatch(ad))
+		_, err = ap.Commit(ctx)
+		require.NoError(t, err)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, err := st.MetadataForProviderAndContextID(ctx, pid, []byte("claim"))
+		return store.IsNotFound(err)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(1), st.scans.Load())
+}
+
+func TestExpirySchedulerConcurrentPublish(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()), store.WithMetadataContext(smd.MetadataContext))
+	p, err := publisher.New(priv, st)
+	require.NoError(t, err)
+
+	claim := testutil.RandomCID(t).(cidlink.Link).Cid
+	publish := func(contextID string, expiresAt time.Time) {
+		md := smd.MetadataContext.New(&smd.LocationCommitmentMetadata{Claim: claim, Expiration: expiresAt.Unix()})
+		digests := testutil.RandomMultihashes(t, 3)
+		for {
+			_, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, contextID, slices.Values(digests), md)
+			// the head may be replaced by the scheduler while publishing
+			if !errors.Is(err, store.ErrPreconditionFailed) {
+				require.NoError(t, err)
+				return
+			}
+		}
+	}
+	const n = 50
+	s, err := publisher.NewExpiryScheduler(p.AdvertisementPublisher(), publisher.WithScanInterval(time.Hour))
+	require.NoError(t, err)
+	s.Start(ctx)
+
+	// expired claims are published and queued for removal while live claims
+	// are published
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range n {
+			publish(fmt.Sprintf("expired-%d", i), time.Now().Add(-time.Minute))
+			_, err := s.Scan(ctx)
+			require.NoError(t, err)
+		}
+	}()
+	for i := range n {
+		publish(fmt.Sprintf("live-%d", i), time.Now().Add(time.Hour))
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		for i := range n {
+			if _, err := st.ChunkLinkForProviderAndContextID(ctx, pid, []byte(fmt.Sprintf("expired-%d", i))); !store.IsNotFound(err) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, s.Stop(ctx))
+
+	for i := range n {
+		_, err := st.ChunkLinkForProviderAndContextID(ctx, pid, []byte(fmt.Sprintf("live-%d", i)))
+		require.NoError(t, err)
+	}
+	report, err := store.Verify(ctx, st)
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Findings)
+}
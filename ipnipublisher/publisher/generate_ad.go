@@ -1,11 +1,15 @@
 package publisher
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"iter"
+	"slices"
 
+	"github.com/ipld/go-ipld-prime"
 	"github.com/ipni/go-libipni/ingest/schema"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -14,9 +18,47 @@ import (
 	"github.com/storacha/go-libstoracha/ipnipublisher/store"
 )
 
+// GenerateAdOption is an option configuring advertisement generation.
+type GenerateAdOption func(cfg *generateAdConfig)
+
+type generateAdConfig struct {
+	updateEntries bool
+	digestsErr    func() error
+}
+
+// UpdateEntries enables update mode. By default, when a context ID was
+// published before, the existing entry chain is reused and the passed
+// multihashes are ignored. In update mode the set of multihashes is compared
+// with the set that was previously advertised (using the digest set hash kept
+// in the store) and the entry chain is rewritten when they differ.
+//
+// Note: indexers add the multihashes of the new advertisement to the ones
+// already indexed for the context ID. To drop multihashes that are no longer
+// part of the set, publish a removal for the context ID beforehand.
+func UpdateEntries() GenerateAdOption {
+	return func(cfg *generateAdConfig) {
+		cfg.updateEntries = true
+	}
+}
+
+// DigestsErr configures a function returning the error that stopped the
+// iteration of the multihashes early, if any, for sources that report read
+// errors separately from the iterator, such as scanners or database cursors.
+// It is called once the multihashes have been read, and generation fails with
+// the error before the entry chain is recorded for the context ID.
+func DigestsErr(errFn func() error) GenerateAdOption {
+	return func(cfg *generateAdConfig) {
+		cfg.digestsErr = errFn
+	}
+}
+
 // GenerateAd generates an advertisement for the given parameters.
-func GenerateAd(ctx context.Context, publisherStore store.PublisherStore, peer peer.ID, addrs []multiaddr.Multiaddr, contextID []byte, md metadata.Metadata, isRm bool, mhs iter.Seq[mh.Multihash]) (schema.Advertisement, error) {
+func GenerateAd(ctx context.Context, publisherStore store.PublisherStore, peer peer.ID, addrs []multiaddr.Multiaddr, contextID []byte, md metadata.Metadata, isRm bool, mhs iter.Seq[mh.Multihash], opts ...GenerateAdOption) (schema.Advertisement, error) {
 	var err error
+	cfg := generateAdConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	log := log.With("providerID", peer).With("contextID", base64.StdEncoding.EncodeToString(contextID))
 
@@ -32,9 +74,37 @@ func GenerateAd(ctx context.Context, publisherStore store.PublisherStore, peer p
 	if !isRm {
 		log.Info("Creating advertisement")
 
-		// If no previously-published ad for this context ID.
-		if chunkLink == nil {
-			log.Info("Generating entries linked list for advertisement")
+		var digestSetHash mh.Multihash
+		entriesChanged := false
+		if cfg.updateEntries {
+			// The multihashes are read once, as the iterator may not support
+			// being read again, and reused to write the entry chain.
+			digests := slices.Collect(mhs)
+			if err := cfg.checkDigests(); err != nil {
+				return schema.Advertisement{}, err
+			}
+			mhs = slices.Values(digests)
+			digestSetHash, err = store.DigestSetHash(withoutErrors(mhs))
+			if err != nil {
+				return schema.Advertisement{}, fmt.Errorf("could not hash digest set: %s", err)
+			}
+			if chunkLink != nil {
+				prevDigestSetHash, err := previousDigestSetHash(ctx, publisherStore, peer, contextID, chunkLink)
+				if err != nil {
+					return schema.Advertisement{}, err
+				}
+				entriesChanged = !bytes.Equal(digestSetHash, prevDigestSetHash)
+			}
+		}
+
+		// If no previously-published ad for this context ID, or the set of
+		// multihashes has changed and should be updated.
+		if chunkLink == nil || entriesChanged {
+			if entriesChanged {
+				log.Info("Digest set changed, regenerating entries linked list for advertisement")
+			} else {
+				log.Info("Generating entries linked list for advertisement")
+			}
 
 			// Generate the linked list ipld.Link that is added to the
 			// advertisement and used for ingestion.
@@ -42,6 +112,9 @@ func GenerateAd(ctx context.Context, publisherStore store.PublisherStore, peer p
 			if err != nil {
 				return schema.Advertisement{}, fmt.Errorf("could not generate entries list: %s", err)
 			}
+			if err := cfg.checkDigests(); err != nil {
+				return schema.Advertisement{}, err
+			}
 			if chunkLink == nil {
 				log.Warnw("chunking for context ID resulted in no link", "contextID", contextID)
 				chunkLink = schema.NoEntries
@@ -53,6 +126,18 @@ func GenerateAd(ctx context.Context, publisherStore store.PublisherStore, peer p
 			if err != nil {
 				return schema.Advertisement{}, fmt.Errorf("failed to write provider + context id to entries cid mapping: %s", err)
 			}
+
+			// Record the hash of the advertised digest set when it is known, or
+			// discard any stale one so that it is recalculated from the entries
+			// when next needed.
+			if digestSetHash != nil {
+				err = digestSets(publisherStore).PutDigestSetHashForProviderAndContextID(ctx, peer, contextID, digestSetHash)
+			} else {
+				err = digestSets(publisherStore).DeleteDigestSetHashForProviderAndContextID(ctx, peer, contextID)
+			}
+			if err != nil {
+				return schema.Advertisement{}, fmt.Errorf("failed to write provider + context id to digest set hash mapping: %s", err)
+			}
 		} else {
 			// Lookup metadata for this providerID and contextID.
 			prevMetadata, err := publisherStore.MetadataForProviderAndContextID(ctx, peer, contextID)
@@ -93,6 +178,10 @@ func GenerateAd(ctx context.Context, publisherStore store.PublisherStore, peer p
 		if err != nil {
 			return schema.Advertisement{}, fmt.Errorf("failed to delete provider + context id to metadata mapping: %s", err)
 		}
+		err = digestSets(publisherStore).DeleteDigestSetHashForProviderAndContextID(ctx, peer, contextID)
+		if err != nil {
+			return schema.Advertisement{}, fmt.Errorf("failed to delete provider + context id to digest set hash mapping: %s", err)
+		}
 
 		// Create an advertisement to delete content by contextID by specifying
 		// that advertisement has no entries.
@@ -123,3 +212,68 @@ func GenerateAd(ctx context.Context, publisherStore store.PublisherStore, peer p
 	}, nil
 
 }
+
+// previousDigestSetHash returns the hash of the digest set that was last
+// advertised for the provider and context ID. Adverts published without update
+// mode have no recorded hash, in which case it is calculated from the entries.
+func previousDigestSetHash(ctx context.Context, publisherStore store.PublisherStore, peer peer.ID, contextID []byte, chunkLink ipld.Link) (mh.Multihash, error) {
+	hash, err := digestSets(publisherStore).DigestSetHashForProviderAndContextID(ctx, peer, contextID)
+	if err == nil {
+		return hash, nil
+	}
+	if !store.IsNotFound(err) {
+		return nil, fmt.Errorf("could not get digest set hash for provider + context id: %s", err)
+	}
+	hash, err = store.DigestSetHash(publisherStore.Entries(ctx, chunkLink))
+	if err != nil {
+		return nil, fmt.Errorf("could not hash existing entries: %s", err)
+	}
+	return hash, nil
+}
+
+// digestSets returns the digest set store of the publisher store, or one that
+// records nothing if the publisher store does not implement
+// [store.DigestSetStore].
+func digestSets(publisherStore store.PublisherStore) store.DigestSetStore {
+	if ds, ok := publisherStore.(store.DigestSetStore); ok {
+		return ds
+	}
+	return noDigestSets{}
+}
+
+// noDigestSets is a digest set store that records nothing, so that hashes are
+// always calculated from the entries.
+type noDigestSets struct{}
+
+func (noDigestSets) DigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) (mh.Multihash, error) {
+	return nil, store.NewErrNotFound(errors.New("digest set hashes are not recorded"))
+}
+
+func (noDigestSets) PutDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte, hash mh.Multihash) error {
+	return nil
+}
+
+func (noDigestSets) DeleteDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) error {
+	return nil
+}
+
+// checkDigests returns the error reported by the multihash source, if any.
+func (cfg generateAdConfig) checkDigests() error {
+	if cfg.digestsErr == nil {
+		return nil
+	}
+	if err := cfg.digestsErr(); err != nil {
+		return fmt.Errorf("could not read multihashes: %w", err)
+	}
+	return nil
+}
+
+func withoutErrors(mhs iter.Seq[mh.Multihash]) iter.Seq2[mh.Multihash, error] {
+	return func(yield func(mh.Multihash, error) bool) {
+		for d := range mhs {
+			if !yield(d, nil) {
+				return
+			}
+		}
+	}
+}
@@ -1,18 +1,30 @@
 package publisher
 
 import (
+	"errors"
 	"net/url"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
 )
 
 // Option is an option configuring a publisher.
 type Option func(cfg *options) error
 
 type options struct {
-	pubHTTPAnnounceAddrs []multiaddr.Multiaddr
-	topic                string
-	announceURLs         []*url.URL
+	pubHTTPAnnounceAddrs    []multiaddr.Multiaddr
+	topic                   string
+	announceURLs            []*url.URL
+	updateEntries           bool
+	pubsubHost              host.Host
+	announceRetry           *retryConfig
+	reannounceInterval      time.Duration
+	leaderElection          *store.LeaderElection
+	providerSigners         []signer.Signer
+	registeredProvidersOnly bool
 }
 
 // WithDirectAnnounce sets indexer URLs to send direct HTTP announcements to.
@@ -29,6 +41,36 @@ func WithDirectAnnounce(announceURLs ...string) Option {
 	}
 }
 
+// WithAnnounceRetry enables retrying failed announcements in the background.
+// Each announce target is retried independently, waiting minBackoff before the
+// first retry and doubling the wait after each failure up to maxBackoff. If a
+// newer advertisement is published while retrying, the newer one is announced
+// instead. A maxAttempts of zero or less retries until the announcement
+// succeeds.
+func WithAnnounceRetry(maxAttempts int, minBackoff, maxBackoff time.Duration) Option {
+	return func(opts *options) error {
+		if minBackoff <= 0 || maxBackoff < minBackoff {
+			return errors.New("invalid announce retry backoff")
+		}
+		opts.announceRetry = &retryConfig{
+			maxAttempts: maxAttempts,
+			minBackoff:  minBackoff,
+			maxBackoff:  maxBackoff,
+		}
+		return nil
+	}
+}
+
+// WithReannounceInterval enables periodic re-announcement of the current head
+// advertisement, so that indexers that missed an announcement eventually
+// learn about it.
+func WithReannounceInterval(interval time.Duration) Option {
+	return func(opts *options) error {
+		opts.reannounceInterval = interval
+		return nil
+	}
+}
+
 // WithAnnounceAddrs configures the multiaddrs that are put into announce
 // messages to tell indexers the addresses to fetch advertisements from.
 func WithAnnounceAddrs(addrs ...string) Option {
@@ -46,9 +88,39 @@ func WithAnnounceAddrs(addrs ...string) Option {
 	}
 }
 
+// WithPubsubAnnounce enables announcements over gossipsub, using the passed
+// libp2p host to publish announce messages to the configured topic.
+func WithPubsubAnnounce(h host.Host) Option {
+	return func(opts *options) error {
+		opts.pubsubHost = h
+		return nil
+	}
+}
+
 func WithTopic(topic string) Option {
 	return func(opts *options) error {
 		opts.topic = topic
 		return nil
 	}
 }
+
+// WithUpdateEntries configures the publisher to generate adverts in update
+// mode, so that publishing a context ID again with a different set of
+// multihashes results in a new entry chain. See [UpdateEntries].
+func WithUpdateEntries() Option {
+	return func(opts *options) error {
+		opts.updateEntries = true
+		return nil
+	}
+}
+
+// WithLeaderElection configures the publisher to only commit to the
+// advertisement chain while it holds the leader lease, returning
+// [ErrNotLeader] otherwise. The election must be run separately, e.g. with
+// [store.LeaderElection.Run].
+func WithLeaderElection(le *store.LeaderElection) Option {
+	return func(opts *options) error {
+		opts.leaderElection = le
+		return nil
+	}
+}
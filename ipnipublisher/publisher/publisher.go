@@ -4,14 +4,17 @@ import (
 	"context"
 	"fmt"
 	"iter"
+	"sync"
 
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/ingest/schema"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
 	"github.com/storacha/go-libstoracha/ipnipublisher/store"
 )
 
@@ -34,8 +37,9 @@ type IPNIPublisher struct {
 }
 
 // Publish creates a new advertisement from the latest head, signs it, and publishes it.
-// Publish is not safe for concurrent use and advertisements may be lost if called concurrently. A mutex or any other
-// synchronization mechanism must be used around Publish if it will be called from concurrent goroutines.
+// It holds the commit lock of the publisher (see [AdvertisementPublisher.CommitLocker]) until the advertisement is
+// stored, and fails with [store.ErrPreconditionFailed] if the head is replaced by another commit before it replaces
+// the head itself, in which case it can be retried.
 func (p *IPNIPublisher) Publish(ctx context.Context, providerInfo peer.AddrInfo, contextID string, digests iter.Seq[mh.Multihash], meta metadata.Metadata) (ipld.Link, error) {
 	link, err := p.publishAdvForIndex(ctx, providerInfo.ID, providerInfo.Addrs, []byte(contextID), meta, false, digests)
 	if err != nil {
@@ -47,11 +51,17 @@ func (p *IPNIPublisher) Publish(ctx context.Context, providerInfo peer.AddrInfo,
 var _ Publisher = (*IPNIPublisher)(nil)
 
 // New creates a new IPNI publisher.
-// IPNIPublisher is not safe for concurrent use. There is the risk of losing advertisements if Publish is called
-// from concurrent goroutines. If you will be publishing from multiple goroutines concurrently, a synchronization
-// mechanism (such as sync.Mutex) must be used to ensure that Publish is called serially.
+// Publish may be called from concurrent goroutines. Advertisements are generated and stored one at a time, along with
+// the other commits of the publisher, such as key rotations and expiry scheduling, but a call fails if the head is
+// replaced while it publishes, by another call or another process sharing the store. Failed calls can be retried.
 func New(id crypto.PrivKey, store store.PublisherStore, opts ...Option) (*IPNIPublisher, error) {
-	bp, err := NewAdvertisementPublisher(id, store, opts...)
+	return NewWithSigner(signer.FromPrivKey(id), store, opts...)
+}
+
+// NewWithSigner creates a new IPNI publisher that signs advertisements and the
+// head with the passed signer. See [New] for concurrency considerations.
+func NewWithSigner(s signer.Signer, store store.PublisherStore, opts ...Option) (*IPNIPublisher, error) {
+	bp, err := NewAdvertisementPublisherWithSigner(s, store, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -61,16 +71,73 @@ func New(id crypto.PrivKey, store store.PublisherStore, opts ...Option) (*IPNIPu
 	}, nil
 }
 
+// AdvertisementPublisher returns the publisher that advertisements are
+// committed through, e.g. to run an [ExpiryScheduler] alongside Publish.
+func (p *IPNIPublisher) AdvertisementPublisher() *AdvertisementPublisher {
+	return p.batchPublisher
+}
+
+// Close closes the publisher, releasing any resources used for announcements.
+func (p *IPNIPublisher) Close() error {
+	return p.batchPublisher.Close()
+}
+
+// RotateKey replaces the key the publisher signs with, preserving the
+// advertisement chain. See [AdvertisementPublisher.RotateKey].
+func (p *IPNIPublisher) RotateKey(ctx context.Context, newKey crypto.PrivKey, opts ...RotateKeyOption) (ipld.Link, error) {
+	return p.batchPublisher.RotateKey(ctx, newKey, opts...)
+}
+
+// RotateSigner replaces the signer the publisher signs with, preserving the
+// advertisement chain. See [AdvertisementPublisher.RotateKey].
+func (p *IPNIPublisher) RotateSigner(ctx context.Context, newSigner signer.Signer, opts ...RotateKeyOption) (ipld.Link, error) {
+	return p.batchPublisher.RotateSigner(ctx, newSigner, opts...)
+}
+
+// AddProvider registers a signer for a provider the publisher publishes
+// advertisements for. See [AdvertisementPublisher.AddProvider].
+func (p *IPNIPublisher) AddProvider(s signer.Signer) (peer.ID, error) {
+	return p.batchPublisher.AddProvider(s)
+}
+
+// RemoveProvider unregisters the signer for the provider.
+func (p *IPNIPublisher) RemoveProvider(id peer.ID) {
+	p.batchPublisher.RemoveProvider(id)
+}
+
+// Providers returns the IDs of the providers with registered signers.
+func (p *IPNIPublisher) Providers() []peer.ID {
+	return p.batchPublisher.Providers()
+}
+
+// AnnounceStatus returns the status of announcements to each announce target.
+func (p *IPNIPublisher) AnnounceStatus() []AnnounceStatus {
+	return p.batchPublisher.AnnounceStatus()
+}
+
 func (p *IPNIPublisher) publishAdvForIndex(ctx context.Context, peer peer.ID, addrs []multiaddr.Multiaddr, contextID []byte, md metadata.Metadata, isRm bool, mhs iter.Seq[mh.Multihash]) (ipld.Link, error) {
+	p.batchPublisher.commitMutex.Lock()
+	unlock := sync.OnceFunc(p.batchPublisher.commitMutex.Unlock)
+	defer unlock()
 
-	adv, err := GenerateAd(ctx, p.store, peer, addrs, contextID, md, isRm, mhs)
-	if err != nil {
+	if err := p.batchPublisher.checkLeader(); err != nil {
 		return nil, err
 	}
 
-	p.batchPublisher.AddToBatch(adv)
+	var opts []GenerateAdOption
+	if p.batchPublisher.updateEntries {
+		opts = append(opts, UpdateEntries())
+	}
+
+	adv, err := GenerateAd(ctx, p.store, peer, addrs, contextID, md, isRm, mhs, opts...)
+	if err != nil {
+		return nil, err
+	}
 
-	return p.batchPublisher.Commit(ctx)
+	// The advert is published on its own rather than added to the batch, and
+	// the commit lock is released before the head is replaced (see
+	// AdvertisementPublisher.publish).
+	return p.batchPublisher.publish(ctx, []schema.Advertisement{adv}, unlock)
 }
 
 type simpleAsyncPublisher struct {
@@ -6,19 +6,26 @@ import (
 	"encoding/base64"
 	"errors"
 	"io"
+	"iter"
 	"math/rand/v2"
+	"net/http/httptest"
 	"slices"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
 	"github.com/storacha/go-libstoracha/ipnipublisher/store"
 	"github.com/storacha/go-libstoracha/testutil"
 
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/announce/gossiptopic"
+	"github.com/ipni/go-libipni/announce/message"
 	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multihash"
@@ -124,6 +131,138 @@ func TestPublish(t *testing.T) {
 		}
 	})
 
+	md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+
+	t.Run("republish with different digests", func(t *testing.T) {
+		dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+		st := store.FromDatastore(dstore)
+		p, err := publisher.New(priv, st)
+		require.NoError(t, err)
+
+		ctxid := testutil.RandomCID(t).String()
+		digests := testutil.RandomMultihashes(t, 10)
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(digests), md)
+		require.NoError(t, err)
+
+		// without update mode the new digests are ignored
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(testutil.RandomMultihashes(t, 10)), md)
+		require.ErrorIs(t, err, publisher.ErrAlreadyAdvertised)
+	})
+
+	t.Run("update entries", func(t *testing.T) {
+		dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+		st := store.FromDatastore(dstore)
+		p, err := publisher.New(priv, st, publisher.WithUpdateEntries())
+		require.NoError(t, err)
+
+		ctxid := testutil.RandomCID(t).String()
+		digests := testutil.RandomMultihashes(t, 10)
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(digests), md)
+		require.NoError(t, err)
+
+		// same set in a different order is not a change
+		reversed := slices.Clone(digests)
+		slices.Reverse(reversed)
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(reversed), md)
+		require.ErrorIs(t, err, publisher.ErrAlreadyAdvertised)
+
+		updated := append(slices.Clone(digests), testutil.RandomMultihashes(t, 5)...)
+		adlnk, err := p.Publish(ctx, provInfo, ctxid, slices.Values(updated), md)
+		require.NoError(t, err)
+
+		ad, err := st.Advert(ctx, adlnk)
+		require.NoError(t, err)
+
+		var ents []multihash.Multihash
+		for e, err := range st.Entries(ctx, ad.Entries) {
+			require.NoError(t, err)
+			ents = append(ents, e)
+		}
+		require.Equal(t, updated, ents)
+
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(updated), md)
+		require.ErrorIs(t, err, publisher.ErrAlreadyAdvertised)
+	})
+
+	t.Run("update entries from single use iterator", func(t *testing.T) {
+		dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+		st := store.FromDatastore(dstore)
+		p, err := publisher.New(priv, st, publisher.WithUpdateEntries())
+		require.NoError(t, err)
+
+		ctxid := testutil.RandomCID(t).String()
+		for _, digests := range [][]multihash.Multihash{testutil.RandomMultihashes(t, 10), testutil.RandomMultihashes(t, 5)} {
+			adlnk, err := p.Publish(ctx, provInfo, ctxid, singleUse(digests), md)
+			require.NoError(t, err)
+
+			ad, err := st.Advert(ctx, adlnk)
+			require.NoError(t, err)
+			var ents []multihash.Multihash
+			for e, err := range st.Entries(ctx, ad.Entries) {
+				require.NoError(t, err)
+				ents = append(ents, e)
+			}
+			require.Equal(t, digests, ents)
+		}
+	})
+
+	t.Run("digest source error", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		readErr := errors.New("connection reset")
+		contextID := []byte(testutil.RandomCID(t).String())
+		for _, opts := range [][]publisher.GenerateAdOption{nil, {publisher.UpdateEntries()}} {
+			opts = append(opts, publisher.DigestsErr(func() error { return readErr }))
+			_, err := publisher.GenerateAd(ctx, st, pid, nil, contextID, md, false, slices.Values(testutil.RandomMultihashes(t, 3)), opts...)
+			require.ErrorIs(t, err, readErr)
+			_, err = st.ChunkLinkForProviderAndContextID(ctx, pid, contextID)
+			require.True(t, store.IsNotFound(err))
+		}
+	})
+
+	t.Run("update entries of advert published without update mode", func(t *testing.T) {
+		dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+		st := store.FromDatastore(dstore)
+		p, err := publisher.New(priv, st)
+		require.NoError(t, err)
+
+		ctxid := testutil.RandomCID(t).String()
+		digests := testutil.RandomMultihashes(t, 10)
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(digests), md)
+		require.NoError(t, err)
+
+		up, err := publisher.New(priv, st, publisher.WithUpdateEntries())
+		require.NoError(t, err)
+
+		// digest set hash is calculated from the existing entries
+		_, err = up.Publish(ctx, provInfo, ctxid, slices.Values(digests), md)
+		require.ErrorIs(t, err, publisher.ErrAlreadyAdvertised)
+
+		_, err = up.Publish(ctx, provInfo, ctxid, slices.Values(digests[1:]), md)
+		require.NoError(t, err)
+	})
+
+	t.Run("update entries without digest set store", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		// hide the DigestSetStore methods of the store
+		p, err := publisher.New(priv, struct{ store.PublisherStore }{st}, publisher.WithUpdateEntries())
+		require.NoError(t, err)
+
+		ctxid := testutil.RandomCID(t).String()
+		digests := testutil.RandomMultihashes(t, 10)
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(digests), md)
+		require.NoError(t, err)
+
+		// digest set hash is calculated from the existing entries
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(digests), md)
+		require.ErrorIs(t, err, publisher.ErrAlreadyAdvertised)
+
+		_, err = p.Publish(ctx, provInfo, ctxid, slices.Values(digests[1:]), md)
+		require.NoError(t, err)
+
+		_, err = st.(store.DigestSetStore).DigestSetHashForProviderAndContextID(ctx, pid, []byte(ctxid))
+		require.True(t, store.IsNotFound(err))
+	})
+
 	t.Run("concurrent publish returns error", func(t *testing.T) {
 		ms := mockStore{data: map[string][]byte{}}
 		st := store.NewPublisherStore(
@@ -158,6 +297,72 @@ func TestPublish(t *testing.T) {
 	})
 }
 
+func TestPubsubAnnounce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	topicName := "/indexer/ingest/test"
+
+	pubHost, err := libp2p.New(libp2p.Identity(priv), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer pubHost.Close()
+
+	subHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer subHost.Close()
+
+	topic, closePubsub, err := gossiptopic.MakeTopic(subHost, topicName)
+	require.NoError(t, err)
+	defer closePubsub()
+	sub, err := topic.Subscribe()
+	require.NoError(t, err)
+	defer sub.Cancel()
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	p, err := publisher.New(priv, st, publisher.WithTopic(topicName), publisher.WithPubsubAnnounce(pubHost))
+	require.NoError(t, err)
+	defer p.Close()
+
+	require.NoError(t, pubHost.Connect(ctx, peer.AddrInfo{ID: subHost.ID(), Addrs: subHost.Addrs()}))
+
+	received := make(chan message.Message, 1)
+	go func() {
+		pmsg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		var msg message.Message
+		if err := msg.UnmarshalCBOR(bytes.NewReader(pmsg.Data)); err == nil {
+			received <- msg
+		}
+	}()
+
+	// Subscriptions take a moment to propagate, so keep publishing until an
+	// announcement is received.
+	var adLinks []string
+	for {
+		digests := testutil.RandomMultihashes(t, 1)
+		l, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, testutil.RandomCID(t).String(), slices.Values(digests), metadata.Default.New())
+		require.NoError(t, err)
+		adLinks = append(adLinks, l.String())
+
+		select {
+		case msg := <-received:
+			require.Contains(t, adLinks, msg.Cid.String())
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for announcement")
+		}
+	}
+}
+
 type mockStore struct {
 	data          map[string][]byte
 	beforeReplace func()
@@ -208,3 +413,96 @@ func (ms *mockStore) Replace(ctx context.Context, key string, old io.Reader, l u
 	ms.data[key] = newBytes
 	return nil
 }
+
+func TestPublishWithRemoteSigner(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	// the private key only lives in the signing service
+	handler, err := signer.NewHandler(signer.FromPrivKey(priv))
+	require.NoError(t, err)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	remote, err := signer.NewRemoteSigner(ctx, ts.URL)
+	require.NoError(t, err)
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	p, err := publisher.NewWithSigner(remote, st)
+	require.NoError(t, err)
+
+	md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+	adlnk, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, testutil.RandomCID(t).String(), slices.Values(testutil.RandomMultihashes(t, 3)), md)
+	require.NoError(t, err)
+
+	ad, err := st.Advert(ctx, adlnk)
+	require.NoError(t, err)
+	adSigner, err := ad.VerifySignature()
+	require.NoError(t, err)
+	require.Equal(t, pid, adSigner)
+
+	hd, err := st.Head(ctx)
+	require.NoError(t, err)
+	headSigner, err := hd.Validate()
+	require.NoError(t, err)
+	require.Equal(t, pid, headSigner)
+}
+
+func TestPublishWithLeaderElection(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	st := store.FromDatastore(dstore)
+	le, err := store.NewLeaderElection(store.StoreFromDirectory(t.TempDir()), "replica", store.WithLeaseTTL(time.Minute))
+	require.NoError(t, err)
+	p, err := publisher.New(priv, st, publisher.WithLeaderElection(le))
+	require.NoError(t, err)
+
+	contextID := testutil.RandomCID(t).String()
+	digests := testutil.RandomMultihashes(t, 3)
+	md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+	_, err = p.Publish(ctx, peer.AddrInfo{ID: pid}, contextID, slices.Values(digests), md)
+	require.ErrorIs(t, err, publisher.ErrNotLeader)
+	_, err = st.Head(ctx)
+	require.True(t, store.IsNotFound(err))
+	// nothing is written to the store by a replica that is not the leader
+	_, err = st.MetadataForProviderAndContextID(ctx, pid, []byte(contextID))
+	require.True(t, store.IsNotFound(err))
+	_, err = st.ChunkLinkForProviderAndContextID(ctx, pid, []byte(contextID))
+	require.True(t, store.IsNotFound(err))
+
+	ok, err := le.TryAcquire(ctx)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	adlnk, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, contextID, slices.Values(digests), md)
+	require.NoError(t, err)
+	hd, err := st.Head(ctx)
+	require.NoError(t, err)
+	require.Equal(t, adlnk, hd.Head)
+}
+
+// singleUse returns an iterator over the digests that can only be read once,
+// like a stream or a database cursor.
+func singleUse(digests []multihash.Multihash) iter.Seq[multihash.Multihash] {
+	used := false
+	return func(yield func(multihash.Multihash) bool) {
+		if used {
+			return
+		}
+		used = true
+		for _, d := range digests {
+			if !yield(d) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/httpsender"
+	"github.com/ipni/go-libipni/announce/p2psender"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+)
+
+// RotateKeyOption is an option configuring a publisher key rotation.
+type RotateKeyOption func(cfg *rotateKeyConfig)
+
+type rotateKeyConfig struct {
+	readvertise bool
+	removeOld   bool
+	pubsubHost  host.Host
+}
+
+// Readvertise re-advertises every context ID currently advertised with the old
+// publisher identity as provider under the new identity. The existing entry
+// chains and metadata are reused.
+func Readvertise() RotateKeyOption {
+	return func(cfg *rotateKeyConfig) {
+		cfg.readvertise = true
+	}
+}
+
+// RemoveOld publishes a removal, signed with the old key, for every context ID
+// currently advertised with the old publisher identity as provider.
+func RemoveOld() RotateKeyOption {
+	return func(cfg *rotateKeyConfig) {
+		cfg.removeOld = true
+	}
+}
+
+// RotatePubsubHost sets the libp2p host used for pubsub announcements after
+// the rotation. Announcements over pubsub carry the identity of the host, so
+// the host must use the new key. It is required when the publisher was
+// configured with [WithPubsubAnnounce].
+func RotatePubsubHost(h host.Host) RotateKeyOption {
+	return func(cfg *rotateKeyConfig) {
+		cfg.pubsubHost = h
+	}
+}
+
+// RotateKey replaces the key the publisher signs advertisements and the head
+// with, preserving the advertisement chain. The head is re-signed with the new
+// key and, if there were any, advertisements published as part of the rotation
+// are announced using the new identity.
+//
+// The migration flow for moving a provider to a new key is:
+//
+//  1. Make the new key available to the publisher, and configure indexers to
+//     allow the new identity to publish (e.g. the indexer publisher policy).
+//  2. Call RotateKey with [Readvertise] and [RemoveOld]. The context IDs of the
+//     old identity are re-advertised under the new identity first, so content
+//     stays discoverable, then removed under the old identity. The removals are
+//     signed with the old key so indexers accept them.
+//  3. Announce, serve and sign with the new key from then on. When the
+//     publisher is served over libp2p, the serving host must also use the new
+//     key, since indexers fetch the head from the announced identity. When
+//     announcing over pubsub, pass a host with the new key with
+//     [RotatePubsubHost].
+//  4. Retire the old key once indexers have synced the new head.
+//
+// Adverts for providers other than the publisher identity are left untouched.
+// Finding the context IDs to re-advertise or remove walks the whole chain. If
+// the rotation fails, the publisher continues to use the old key, and the
+// context IDs of the old identity are left as they were. Once the head has
+// been re-signed the rotation is complete, and no error is returned.
+//
+// RotateKey holds the commit lock of the publisher (see
+// [AdvertisementPublisher.CommitLocker]), so it must not be called while
+// holding it.
+func (p *AdvertisementPublisher) RotateKey(ctx context.Context, newKey crypto.PrivKey, opts ...RotateKeyOption) (ipld.Link, error) {
+	return p.RotateSigner(ctx, signer.FromPrivKey(newKey), opts...)
+}
+
+// RotateSigner is like [AdvertisementPublisher.RotateKey], but switches to a
+// signer, which may hold the new key outside the process.
+func (p *AdvertisementPublisher) RotateSigner(ctx context.Context, newSigner signer.Signer, opts ...RotateKeyOption) (ipld.Link, error) {
+	cfg := rotateKeyConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	p.commitMutex.Lock()
+	defer p.commitMutex.Unlock()
+
+	oldSigner := p.currentSigner()
+	oldID, err := signer.ID(oldSigner)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get peer ID from signer: %w", err)
+	}
+	newID, err := signer.ID(newSigner)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get peer ID from new signer: %w", err)
+	}
+	if oldID == newID {
+		return nil, errors.New("new key is the same as the current key")
+	}
+	log := log.With("oldPublisher", oldID, "newPublisher", newID)
+	if err := p.checkLeader(); err != nil {
+		return nil, err
+	}
+
+	// Create the announce senders for the new identity up front, so that
+	// nothing can fail once the head has been re-signed.
+	senders, err := p.newSenders(newID, cfg.pubsubHost)
+	if err != nil {
+		return nil, err
+	}
+	abort := func() {
+		for _, s := range senders {
+			_ = s.Close()
+		}
+	}
+
+	var pending []pendingAd
+	if cfg.readvertise || cfg.removeOld {
+		live, err := liveAdverts(ctx, p.store, oldID)
+		if err != nil {
+			abort()
+			return nil, err
+		}
+		if cfg.readvertise {
+			for _, ad := range live {
+				readv, err := p.readvertisement(ctx, oldID, newID, ad)
+				if err != nil {
+					p.discard(ctx, adverts(pending))
+					abort()
+					return nil, err
+				}
+				pending = append(pending, pendingAd{ad: readv, signer: newSigner})
+			}
+		}
+		if cfg.removeOld {
+			// The table entries of the old identity are deleted once the head
+			// is replaced, so they are kept if the rotation fails.
+			for _, ad := range live {
+				rm, err := removalAdvert(oldID, ad.ContextID)
+				if err != nil {
+					p.discard(ctx, adverts(pending))
+					abort()
+					return nil, fmt.Errorf("generating removal advert: %w", err)
+				}
+				pending = append(pending, pendingAd{ad: rm, signer: oldSigner})
+			}
+		}
+		log.Infow("Rotating publisher key", "readvertised", cfg.readvertise, "removed", cfg.removeOld, "contextIDs", len(live))
+	}
+
+	prevHead, lnk, err := p.commit(ctx, pending)
+	if err == nil && lnk != nil {
+		err = p.replaceHead(ctx, prevHead, lnk, newSigner)
+	}
+	if err != nil {
+		p.discard(ctx, adverts(pending))
+		abort()
+		return nil, err
+	}
+
+	for _, ad := range pending {
+		if ad.ad.IsRm {
+			p.deleteEntries(ctx, oldID, ad.ad.ContextID)
+		}
+	}
+	p.committed(ctx, adverts(pending))
+	p.signer.Store(&newSigner)
+	if cfg.pubsubHost != nil {
+		p.pubsubHost = cfg.pubsubHost
+	}
+	for name, sender := range senders {
+		if err := p.announcer.replaceSender(name, sender); err != nil {
+			log.Warnw("Failed to close previous announce sender", "target", name, "err", err)
+		}
+	}
+	log.Info("Rotated publisher key")
+
+	if len(pending) > 0 {
+		_ = p.announcer.announce(ctx, lnk.(cidlink.Link).Cid)
+	}
+	return lnk, nil
+}
+
+// newSenders creates an announce sender for every announce target of the
+// publisher, carrying the passed identity. Pubsub announcements are sent by
+// the passed host, which must have the identity.
+func (p *AdvertisementPublisher) newSenders(id peer.ID, pubsubHost host.Host) (map[string]announce.Sender, error) {
+	senders := map[string]announce.Sender{}
+	closeAll := func() {
+		for _, s := range senders {
+			_ = s.Close()
+		}
+	}
+	for _, u := range p.announceURLs {
+		sender, err := httpsender.New([]*url.URL{u}, id)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("cannot create http announce sender: %w", err)
+		}
+		senders[u.String()] = sender
+	}
+	if p.pubsubHost != nil {
+		if pubsubHost == nil {
+			closeAll()
+			return nil, errors.New("pubsub announcements require a host with the new identity")
+		}
+		if pubsubHost.ID() != id {
+			closeAll()
+			return nil, fmt.Errorf("pubsub host identity %s does not match the new identity %s", pubsubHost.ID(), id)
+		}
+		sender, err := p2psender.New(pubsubHost, p.topic)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("cannot create pubsub announce sender: %w", err)
+		}
+		senders["pubsub:"+p.topic] = sender
+	}
+	return senders, nil
+}
+
+// removalAdvert creates a removal advert for the context ID of the provider,
+// like [GenerateAd] does, without deleting the table entries of the context ID.
+func removalAdvert(provider peer.ID, contextID []byte) (schema.Advertisement, error) {
+	md := metadata.Default.New()
+	mdBytes, err := md.MarshalBinary()
+	if err != nil {
+		return schema.Advertisement{}, err
+	}
+	return schema.Advertisement{
+		Provider:  provider.String(),
+		Entries:   schema.NoEntries,
+		ContextID: contextID,
+		Metadata:  mdBytes,
+		IsRm:      true,
+	}, nil
+}
+
+// deleteEntries deletes the provider/context ID table entries of a removed
+// context ID. Failures are logged, since the removal is already committed.
+func (p *AdvertisementPublisher) deleteEntries(ctx context.Context, provider peer.ID, contextID []byte) {
+	if err := p.store.DeleteChunkLinkForProviderAndContextID(ctx, provider, contextID); err != nil {
+		log.Warnw("Failed to delete chunk link of removed context ID", "provider", provider, "err", err)
+	}
+	if err := p.store.DeleteMetadataForProviderAndContextID(ctx, provider, contextID); err != nil {
+		log.Warnw("Failed to delete metadata of removed context ID", "provider", provider, "err", err)
+	}
+	if err := digestSets(p.store).DeleteDigestSetHashForProviderAndContextID(ctx, provider, contextID); err != nil {
+		log.Warnw("Failed to delete digest set hash of removed context ID", "provider", provider, "err", err)
+	}
+}
+
+// readvertisement creates an advert for the new provider identity with the
+// same entries and metadata as an advert of the old identity, and records it in
+// the provider/context ID tables.
+func (p *AdvertisementPublisher) readvertisement(ctx context.Context, oldID, newID peer.ID, ad schema.Advertisement) (schema.Advertisement, error) {
+	md, err := p.store.MetadataForProviderAndContextID(ctx, oldID, ad.ContextID)
+	if err != nil {
+		return schema.Advertisement{}, fmt.Errorf("could not get metadata for provider + context id: %w", err)
+	}
+	if err := p.store.PutChunkLinkForProviderAndContextID(ctx, newID, ad.ContextID, ad.Entries); err != nil {
+		return schema.Advertisement{}, fmt.Errorf("failed to write provider + context id to entries cid mapping: %w", err)
+	}
+	if err := p.store.PutMetadataForProviderAndContextID(ctx, newID, ad.ContextID, md); err != nil {
+		return schema.Advertisement{}, fmt.Errorf("failed to write provider + context id to metadata mapping: %w", err)
+	}
+	hash, err := digestSets(p.store).DigestSetHashForProviderAndContextID(ctx, oldID, ad.ContextID)
+	if err == nil {
+		err = digestSets(p.store).PutDigestSetHashForProviderAndContextID(ctx, newID, ad.ContextID, hash)
+	} else if store.IsNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return schema.Advertisement{}, fmt.Errorf("failed to copy provider + context id digest set hash: %w", err)
+	}
+	return schema.Advertisement{
+		Provider:  newID.String(),
+		Addresses: ad.Addresses,
+		Entries:   ad.Entries,
+		ContextID: ad.ContextID,
+		Metadata:  ad.Metadata,
+	}, nil
+}
+
+// liveAdverts walks the chain from the head and returns the latest advert for
+// each context ID of the provider that has not been removed, oldest first.
+func liveAdverts(ctx context.Context, publisherStore store.PublisherStore, provider peer.ID) ([]schema.Advertisement, error) {
+	hd, err := publisherStore.Head(ctx)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not get latest advertisement: %w", err)
+	}
+
+	seen := map[string]struct{}{}
+	var live []schema.Advertisement
+	for cur := hd.Head; cur != nil; {
+		ad, err := publisherStore.Advert(ctx, cur)
+		if err != nil {
+			return nil, fmt.Errorf("could not get advertisement %s: %w", cur, err)
+		}
+		cur = ad.PreviousID
+		if ad.Provider != provider.String() {
+			continue
+		}
+		if _, ok := seen[string(ad.ContextID)]; ok {
+			continue
+		}
+		seen[string(ad.ContextID)] = struct{}{}
+		if !ad.IsRm {
+			live = append(live, ad)
+		}
+	}
+	slices.Reverse(live)
+	return live, nil
+}
+
+func adverts(pending []pendingAd) []schema.Advertisement {
+	ads := make([]schema.Advertisement, 0, len(pending))
+	for _, p := range pending {
+		ads = append(ads, p.ad)
+	}
+	return ads
+}
@@ -0,0 +1,330 @@
+package publisher_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateKey(t *testing.T) {
+	ctx := context.Background()
+
+	oldKey, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	require.NoError(t, err)
+
+	newKey, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	newID, err := peer.IDFromPrivateKey(newKey)
+	require.NoError(t, err)
+
+	// publishChain publishes adverts for 3 context IDs, the first of which is
+	// then removed, plus one for another provider.
+	publishChain := func(t *testing.T, st store.FullStore, p *publisher.IPNIPublisher) [][]byte {
+		var contextIDs [][]byte
+		for range 3 {
+			contextID := testutil.RandomCID(t).String()
+			md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+			_, err := p.Publish(ctx, peer.AddrInfo{ID: oldID}, contextID, slices.Values(testutil.RandomMultihashes(t, 3)), md)
+			require.NoError(t, err)
+			contextIDs = append(contextIDs, []byte(contextID))
+		}
+
+		ap, err := publisher.NewAdvertisementPublisher(oldKey, st)
+		require.NoError(t, err)
+		rm, err := publisher.GenerateAd(ctx, st, oldID, nil, contextIDs[0], metadata.Default.New(), true, nil)
+		require.NoError(t, err)
+		require.NoError(t, ap.AddToBatch(rm))
+		_, err = ap.Commit(ctx)
+		require.NoError(t, err)
+		return contextIDs
+	}
+
+	requireHeadSigner := func(t *testing.T, st store.FullStore, signer peer.ID) ipld.Link {
+		hd, err := st.Head(ctx)
+		require.NoError(t, err)
+		id, err := hd.Validate()
+		require.NoError(t, err)
+		require.Equal(t, signer, id)
+		return hd.Head
+	}
+
+	t.Run("re-sign head only", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(oldKey, st)
+		require.NoError(t, err)
+		publishChain(t, st, p)
+		prevHead := requireHeadSigner(t, st, oldID)
+
+		lnk, err := p.RotateKey(ctx, newKey)
+		require.NoError(t, err)
+		require.Equal(t, prevHead, lnk)
+		require.Equal(t, prevHead, requireHeadSigner(t, st, newID))
+
+		// new adverts are signed with the new key and continue the chain
+		contextID := testutil.RandomCID(t).String()
+		md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+		lnk, err = p.Publish(ctx, peer.AddrInfo{ID: newID}, contextID, slices.Values(testutil.RandomMultihashes(t, 3)), md)
+		require.NoError(t, err)
+		ad, err := st.Advert(ctx, lnk)
+		require.NoError(t, err)
+		require.Equal(t, prevHead, ad.PreviousID)
+		signer, err := ad.VerifySignature()
+		require.NoError(t, err)
+		require.Equal(t, newID, signer)
+		requireHeadSigner(t, st, newID)
+	})
+
+	t.Run("re-advertise and remove old", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(oldKey, st)
+		require.NoError(t, err)
+		contextIDs := publishChain(t, st, p)
+		prevHead := requireHeadSigner(t, st, oldID)
+
+		var entries []ipld.Link
+		for _, contextID := range contextIDs[1:] {
+			lnk, err := st.ChunkLinkForProviderAndContextID(ctx, oldID, contextID)
+			require.NoError(t, err)
+			entries = append(entries, lnk)
+		}
+
+		_, err = p.RotateKey(ctx, newKey, publisher.Readvertise(), publisher.RemoveOld())
+		require.NoError(t, err)
+		requireHeadSigner(t, st, newID)
+
+		// every advert is signed by its provider and the tables are consistent
+		report, err := store.Verify(ctx, st)
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+		require.Equal(t, 8, report.Adverts)
+
+		for i, contextID := range contextIDs[1:] {
+			lnk, err := st.ChunkLinkForProviderAndContextID(ctx, newID, contextID)
+			require.NoError(t, err)
+			require.Equal(t, entries[i], lnk)
+			_, err = st.MetadataForProviderAndContextID(ctx, newID, contextID)
+			require.NoError(t, err)
+
+			_, err = st.ChunkLinkForProviderAndContextID(ctx, oldID, contextID)
+			require.True(t, store.IsNotFound(err))
+		}
+		// removed context ID is not re-advertised
+		_, err = st.ChunkLinkForProviderAndContextID(ctx, newID, contextIDs[0])
+		require.True(t, store.IsNotFound(err))
+
+		// re-advertisements come first, then removals, linked to the old head
+		var chain []string
+		hd, err := st.Head(ctx)
+		require.NoError(t, err)
+		for cur := hd.Head; cur != prevHead; {
+			ad, err := st.Advert(ctx, cur)
+			require.NoError(t, err)
+			if ad.IsRm {
+				chain = append(chain, "rm:"+ad.Provider)
+			} else {
+				chain = append(chain, "ad:"+ad.Provider)
+			}
+			cur = ad.PreviousID
+		}
+		slices.Reverse(chain)
+		require.Equal(t, []string{
+			"ad:" + newID.String(), "ad:" + newID.String(),
+			"rm:" + oldID.String(), "rm:" + oldID.String(),
+		}, chain)
+	})
+
+	t.Run("announces with new identity", func(t *testing.T) {
+		var mutex sync.Mutex
+		var addrs []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var msg message.Message
+			if err := msg.UnmarshalCBOR(r.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mas, err := msg.GetAddrs()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mutex.Lock()
+			for _, ma := range mas {
+				addrs = append(addrs, ma.String())
+			}
+			mutex.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer ts.Close()
+
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(oldKey, st, publisher.WithDirectAnnounce(ts.URL), publisher.WithAnnounceAddrs("/ip4/127.0.0.1/tcp/3000/http"))
+		require.NoError(t, err)
+		defer p.Close()
+		publishChain(t, st, p)
+
+		_, err = p.RotateKey(ctx, newKey, publisher.Readvertise())
+		require.NoError(t, err)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		require.NotEmpty(t, addrs)
+		require.True(t, strings.HasSuffix(addrs[0], oldID.String()))
+		require.True(t, strings.HasSuffix(addrs[len(addrs)-1], newID.String()))
+	})
+
+	t.Run("pubsub host", func(t *testing.T) {
+		oldHost, err := libp2p.New(libp2p.Identity(oldKey), libp2p.NoListenAddrs)
+		require.NoError(t, err)
+		defer oldHost.Close()
+		newHost, err := libp2p.New(libp2p.Identity(newKey), libp2p.NoListenAddrs)
+		require.NoError(t, err)
+		defer newHost.Close()
+
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(oldKey, st, publisher.WithPubsubAnnounce(oldHost))
+		require.NoError(t, err)
+		defer p.Close()
+		publishChain(t, st, p)
+		head := requireHeadSigner(t, st, oldID)
+
+		// the rotation fails before the chain is changed without a host for the
+		// new identity
+		_, err = p.RotateKey(ctx, newKey, publisher.Readvertise())
+		require.Error(t, err)
+		_, err = p.RotateKey(ctx, newKey, publisher.Readvertise(), publisher.RotatePubsubHost(oldHost))
+		require.Error(t, err)
+		require.Equal(t, head, requireHeadSigner(t, st, oldID))
+
+		_, err = p.RotateKey(ctx, newKey, publisher.Readvertise(), publisher.RotatePubsubHost(newHost))
+		require.NoError(t, err)
+		requireHeadSigner(t, st, newID)
+		require.Len(t, p.AnnounceStatus(), 1)
+	})
+
+	t.Run("remote signer", func(t *testing.T) {
+		handler, err := signer.NewHandler(signer.FromPrivKey(newKey))
+		require.NoError(t, err)
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+		remote, err := signer.NewRemoteSigner(ctx, ts.URL)
+		require.NoError(t, err)
+
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(oldKey, st)
+		require.NoError(t, err)
+		publishChain(t, st, p)
+
+		_, err = p.RotateSigner(ctx, remote, publisher.Readvertise(), publisher.RemoveOld())
+		require.NoError(t, err)
+		requireHeadSigner(t, st, newID)
+
+		report, err := store.Verify(ctx, st)
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+	})
+
+	t.Run("failed rotation keeps old entries", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(oldKey, st)
+		require.NoError(t, err)
+		contextIDs := publishChain(t, st, p)
+		prevHead := requireHeadSigner(t, st, oldID)
+
+		// the head cannot be signed with the new key
+		_, err = p.RotateSigner(ctx, failingSigner{signer.FromPrivKey(newKey)}, publisher.RemoveOld())
+		require.Error(t, err)
+		require.Equal(t, prevHead, requireHeadSigner(t, st, oldID))
+		for _, contextID := range contextIDs[1:] {
+			_, err := st.ChunkLinkForProviderAndContextID(ctx, oldID, contextID)
+			require.NoError(t, err)
+			_, err = st.MetadataForProviderAndContextID(ctx, oldID, contextID)
+			require.NoError(t, err)
+		}
+
+		_, err = p.RotateKey(ctx, newKey, publisher.RemoveOld())
+		require.NoError(t, err)
+		for _, contextID := range contextIDs[1:] {
+			_, err := st.ChunkLinkForProviderAndContextID(ctx, oldID, contextID)
+			require.True(t, store.IsNotFound(err))
+		}
+	})
+
+	t.Run("concurrent publish", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(oldKey, st)
+		require.NoError(t, err)
+		publishChain(t, st, p)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+				_, err := p.Publish(ctx, peer.AddrInfo{ID: oldID}, testutil.RandomCID(t).String(), slices.Values(testutil.RandomMultihashes(t, 3)), md)
+				// the head may be replaced by the rotation while publishing
+				if !errors.Is(err, store.ErrPreconditionFailed) {
+					require.NoError(t, err)
+				}
+			}
+		}()
+		_, err = p.RotateKey(ctx, newKey, publisher.Readvertise())
+		require.NoError(t, err)
+		wg.Wait()
+
+		// the head is signed with a single key
+		hd, err := st.Head(ctx)
+		require.NoError(t, err)
+		id, err := hd.Validate()
+		require.NoError(t, err)
+		require.Equal(t, newID, id)
+	})
+
+	t.Run("same key", func(t *testing.T) {
+		st := store.FromDatastore(datastore.NewMapDatastore())
+		p, err := publisher.New(oldKey, st)
+		require.NoError(t, err)
+		_, err = p.RotateKey(ctx, oldKey)
+		require.Error(t, err)
+	})
+
+	t.Run("empty store", func(t *testing.T) {
+		st := store.FromDatastore(datastore.NewMapDatastore())
+		p, err := publisher.New(oldKey, st)
+		require.NoError(t, err)
+		lnk, err := p.RotateKey(ctx, newKey, publisher.Readvertise(), publisher.RemoveOld())
+		require.NoError(t, err)
+		require.Nil(t, lnk)
+		_, err = st.Head(ctx)
+		require.True(t, store.IsNotFound(err))
+	})
+}
+
+type failingSigner struct {
+	signer.Signer
+}
+
+func (failingSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	return nil, errors.New("signing failed")
+}
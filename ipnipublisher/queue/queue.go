@@ -40,6 +40,7 @@ type (
 	AdvertisementQueuePublisher struct {
 		queue AdvertisementPublishingQueue
 		store store.PublisherStore
+		opts  []publisher.GenerateAdOption
 	}
 )
 
@@ -113,15 +114,16 @@ func NewPublishingQueuePoller(queue PublishingQueue, publisher publisher.AsyncPu
 
 var _ publisher.AsyncPublisher = (*AdvertisementQueuePublisher)(nil)
 
-func NewAdvertisementQueuePublisher(queue AdvertisementPublishingQueue, store store.PublisherStore) *AdvertisementQueuePublisher {
+func NewAdvertisementQueuePublisher(queue AdvertisementPublishingQueue, store store.PublisherStore, opts ...publisher.GenerateAdOption) *AdvertisementQueuePublisher {
 	return &AdvertisementQueuePublisher{
 		queue: queue,
 		store: store,
+		opts:  opts,
 	}
 }
 
 func (qa *AdvertisementQueuePublisher) Publish(ctx context.Context, pInfo peer.AddrInfo, contextID string, digests iter.Seq[mh.Multihash], meta metadata.Metadata) error {
-	adv, err := publisher.GenerateAd(ctx, qa.store, pInfo.ID, pInfo.Addrs, []byte(contextID), meta, false, digests)
+	adv, err := publisher.GenerateAd(ctx, qa.store, pInfo.ID, pInfo.Addrs, []byte(contextID), meta, false, digests, qa.opts...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var meter = otel.Meter("github.com/storacha/go-libstoracha/ipnipublisher/server")
+
+var (
+	requestCounter  metric.Int64Counter
+	requestDuration metric.Float64Histogram
+)
+
+func init() {
+	var err error
+	requestCounter, err = meter.Int64Counter(
+		"ipnipublisher.server.requests",
+		metric.WithDescription("Number of requests handled by the advertisement server"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create requests counter: %w", err))
+	}
+	requestDuration, err = meter.Float64Histogram(
+		"ipnipublisher.server.request.duration",
+		metric.WithDescription("Duration of requests handled by the advertisement server"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create request duration histogram: %w", err))
+	}
+}
+
+const (
+	transportHTTP   = "http"
+	transportLibp2p = "libp2p"
+)
+
+// instrument wraps a handler, recording request count and duration metrics
+// for the named route.
+func instrument(route string, transport string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		attrs := metric.WithAttributes(
+			attribute.String("route", route),
+			attribute.String("transport", transport),
+			attribute.Int("status", sw.status),
+		)
+		requestCounter.Add(r.Context(), 1, attrs)
+		requestDuration.Record(r.Context(), time.Since(start).Seconds(), attrs)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
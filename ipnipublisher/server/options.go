@@ -1,13 +1,30 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+var (
+	// DefaultReadTimeout is the maximum duration for reading an entire request
+	// when no read timeout is configured.
+	DefaultReadTimeout = 30 * time.Second
+	// DefaultWriteTimeout is the maximum duration before timing out writes of a
+	// response when no write timeout is configured.
+	DefaultWriteTimeout = 30 * time.Second
 )
 
 // config contains all options for configuring Publisher.
 type config struct {
-	handlerPath string
-	httpAddr    string
+	handlerPath  string
+	httpAddr     string
+	streamHost   host.Host
+	tlsConfig    *tls.Config
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 }
 
 // Option is a function that sets a value in a config.
@@ -15,7 +32,10 @@ type Option func(*config) error
 
 // getOpts creates a pubConfig and applies Options to it.
 func getOpts(opts []Option) (config, error) {
-	cfg := config{}
+	cfg := config{
+		readTimeout:  DefaultReadTimeout,
+		writeTimeout: DefaultWriteTimeout,
+	}
 	for i, opt := range opts {
 		if err := opt(&cfg); err != nil {
 			return config{}, fmt.Errorf("option %d failed: %s", i, err)
@@ -46,3 +66,46 @@ func WithHandlerPath(urlPath string) Option {
 		return nil
 	}
 }
+
+// WithStreamHost sets a libp2p stream host that adverts are also served from,
+// using HTTP over libp2p streams with the [ProtocolID] protocol.
+func WithStreamHost(h host.Host) Option {
+	return func(c *config) error {
+		c.streamHost = h
+		return nil
+	}
+}
+
+// WithTLSConfig configures the HTTP server to serve HTTPS using the passed TLS
+// configuration. The configuration must provide a certificate, either via
+// Certificates or GetCertificate.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *config) error {
+		if tlsConfig == nil {
+			return fmt.Errorf("TLS config is nil")
+		}
+		if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil {
+			return fmt.Errorf("TLS config has no certificates")
+		}
+		c.tlsConfig = tlsConfig
+		return nil
+	}
+}
+
+// WithReadTimeout sets the maximum duration for reading an entire request,
+// including the body. If not set, [DefaultReadTimeout] is used.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		c.readTimeout = timeout
+		return nil
+	}
+}
+
+// WithWriteTimeout sets the maximum duration before timing out writes of a
+// response. If not set, [DefaultWriteTimeout] is used.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		c.writeTimeout = timeout
+		return nil
+	}
+}
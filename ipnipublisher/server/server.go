@@ -1,36 +1,159 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log/v2"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	libp2phttp "github.com/libp2p/go-libp2p/p2p/http"
+	"github.com/multiformats/go-multiaddr"
 	"github.com/storacha/go-libstoracha/ipnipublisher/store"
 )
 
+var log = logging.Logger("server")
+
 const (
 	// IPNIPath is the path that the Publisher expects as the last port of the
 	// HTTP request URL path. The sync client automatically adds this to the
 	// request path.
 	IPNIPath = "/ipni/v1/ad"
+	// ProtocolID is the libp2p protocol ID used to serve adverts over HTTP on a
+	// libp2p stream host.
+	ProtocolID = protocol.ID(IPNIPath)
+	// HealthPath is the path of the HTTP health check endpoint.
+	HealthPath = "/healthz"
 )
 
+// advertCacheControl is the Cache-Control header value for adverts and entry
+// chunks. They are addressed by CID, so their content never changes.
+const advertCacheControl = "public, max-age=29030400, immutable"
+
 type Server struct {
-	advertStore store.EncodeableStore
-	handlerPath string
-	srv         *http.Server
+	handler   *handler
+	srv       *http.Server
+	tlsConfig *tls.Config
+	p2pHost   *libp2phttp.Host
+
+	mutex    sync.Mutex
+	listener net.Listener
 }
 
+// Start starts serving adverts. It returns an error if the server is unable
+// to start listening, and otherwise serves in the background until Shutdown
+// is called.
 func (s *Server) Start(ctx context.Context) error {
-	go s.srv.ListenAndServe()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.srv != nil {
+		addr := s.srv.Addr
+		if addr == "" {
+			if s.tlsConfig != nil {
+				addr = ":https"
+			} else {
+				addr = ":http"
+			}
+		}
+		var lc net.ListenConfig
+		ln, err := lc.Listen(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("listening on %s: %w", addr, err)
+		}
+		s.listener = ln
+		go func() {
+			var err error
+			if s.tlsConfig != nil {
+				err = s.srv.ServeTLS(ln, "", "")
+			} else {
+				err = s.srv.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorw("Failed to serve adverts over HTTP", "err", err)
+			}
+		}()
+		log.Infow("Serving adverts over HTTP", "addr", ln.Addr())
+	}
+
+	if s.p2pHost != nil {
+		addrs, errCh, err := serveP2P(s.p2pHost)
+		if err != nil {
+			if s.srv != nil {
+				s.srv.Close()
+			}
+			return fmt.Errorf("serving over libp2p: %w", err)
+		}
+		go func() {
+			if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Errorw("Failed to serve adverts over libp2p", "err", err)
+			}
+		}()
+		log.Infow("Serving adverts over libp2p", "addrs", addrs)
+	}
 	return nil
 }
 
+// serveP2P starts serving on the libp2p HTTP host in the background, and
+// returns once its listener is ready or has failed to be set up. On success it
+// returns the addresses being served and a channel receiving the error Serve
+// eventually returns.
+//
+// The host only serves on its stream host, so Serve either fails before
+// listening with no addresses recorded, or records the stream host addresses
+// and blocks serving on them. Addrs waits until one of the two has happened,
+// which makes an empty address list a reliable signal of failure, after which
+// the error is always sent by Serve.
+func serveP2P(h *libp2phttp.Host) ([]multiaddr.Multiaddr, <-chan error, error) {
+	if len(h.ListenAddrs) > 0 {
+		return nil, nil, errors.New("libp2p HTTP host must only serve on its stream host")
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- h.Serve()
+	}()
+	addrs := h.Addrs()
+	if len(addrs) == 0 {
+		return nil, nil, <-errCh
+	}
+	return addrs, errCh, nil
+}
+
+// Addr returns the address the HTTP server is listening on, or nil if it is
+// not listening.
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown gracefully shuts down the server, waiting for in-flight requests to
+// complete. If the context expires before they do, remaining connections are
+// closed and the context error is returned.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.srv.Close()
+	var errs []error
+	if s.srv != nil {
+		if err := s.srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err, s.srv.Close())
+		}
+	}
+	if s.p2pHost != nil {
+		errs = append(errs, s.p2pHost.Close())
+	}
+	return errors.Join(errs...)
 }
 
 func NewServer(store store.EncodeableStore, options ...Option) (*Server, error) {
@@ -46,28 +169,61 @@ func NewServer(store store.EncodeableStore, options ...Option) (*Server, error)
 		handlerPath = strings.TrimPrefix(IPNIPath, "/")
 	}
 
-	srv := &http.Server{
-		Addr: opts.httpAddr,
+	s := &Server{
+		handler:   &handler{advertStore: store, handlerPath: handlerPath},
+		tlsConfig: opts.tlsConfig,
 	}
 
-	s := &Server{advertStore: store, srv: srv, handlerPath: handlerPath}
+	// HTTP listen addresses are optional when serving over a libp2p stream host.
+	if opts.httpAddr != "" || opts.streamHost == nil {
+		mux := http.NewServeMux()
+		mux.Handle("/"+handlerPath+"/{ad}", instrument("ad", transportHTTP, s))
+		mux.Handle("GET "+HealthPath, instrument("health", transportHTTP, http.HandlerFunc(serveHealth)))
+		s.srv = &http.Server{
+			Addr:              opts.httpAddr,
+			Handler:           mux,
+			TLSConfig:         opts.tlsConfig,
+			ReadHeaderTimeout: opts.readTimeout,
+			ReadTimeout:       opts.readTimeout,
+			WriteTimeout:      opts.writeTimeout,
+		}
+	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/"+handlerPath+"/{ad}", s)
-	s.srv.Handler = mux
+	if opts.streamHost != nil {
+		s.p2pHost = &libp2phttp.Host{StreamHost: opts.streamHost}
+		// libp2phttp strips the handler path from the request before it is
+		// passed to the handler, so the request path is expected to be just the
+		// requested item.
+		s.p2pHost.SetHTTPHandlerAtPath(ProtocolID, "/"+handlerPath, instrument("ad", transportLibp2p, &handler{advertStore: store}))
+	}
 
 	return s, nil
 }
 
 // ServeHTTP implements the http.Handler interface.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("OK"))
+}
+
+type handler struct {
+	advertStore store.EncodeableStore
+	handlerPath string
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// If we expect publisher requests to have a prefix in the request path,
 	// then check for the expected prefix.. This happens when using an external
 	// server with this Publisher as the request handler.
 	urlPath := strings.TrimPrefix(r.URL.Path, "/")
-	if s.handlerPath != "" {
+	if h.handlerPath != "" {
 		// A URL path from http will have a leading "/". A URL from libp2phttp will not.
-		if !strings.HasPrefix(urlPath, s.handlerPath) {
+		if !strings.HasPrefix(urlPath, h.handlerPath) {
 			http.Error(w, "invalid request path: "+r.URL.Path, http.StatusBadRequest)
 			return
 		}
@@ -78,15 +234,22 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	ask := path.Base(r.URL.Path)
 	if ask == "head" {
-		// Serve the head message.
-		err := s.advertStore.EncodeHead(r.Context(), w)
+		// The head changes with every publish, so must always be revalidated.
+		w.Header().Set("Cache-Control", "no-cache")
+		// Serve the head message. It is read in full first, so that a failure
+		// is reported with an error status rather than a truncated body.
+		var buf bytes.Buffer
+		err := h.advertStore.EncodeHead(r.Context(), &buf)
 		if err != nil {
 			if store.IsNotFound(err) {
 				http.Error(w, "", http.StatusNoContent)
 				return
 			}
+			log.Errorw("Failed to serve head", "err", err)
 			http.Error(w, "", http.StatusInternalServerError)
+			return
 		}
+		writeBody(w, &buf)
 		return
 	}
 
@@ -96,12 +259,41 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request: not a cid", http.StatusBadRequest)
 		return
 	}
-	err = s.advertStore.Encode(r.Context(), cidlink.Link{Cid: c}, w)
+
+	etag := `"` + c.String() + `"`
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == etag || match == "*") {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", advertCacheControl)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Blocks are small, so they are read in full before responding. This way
+	// the response is only marked as cacheable once the whole block has been
+	// read, and a failure is never cached as a truncated body.
+	var buf bytes.Buffer
+	err = h.advertStore.Encode(r.Context(), cidlink.Link{Cid: c}, &buf)
 	if err != nil {
+		// errors are not content addressed, so must not be cached
+		w.Header().Set("Cache-Control", "no-store")
 		if store.IsNotFound(err) {
 			http.Error(w, "cid not found", http.StatusNotFound)
 			return
 		}
+		log.Errorw("Failed to load requested block", "err", err, "cid", c)
 		http.Error(w, "unable to load data for cid", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", advertCacheControl)
+	writeBody(w, &buf)
+}
+
+// writeBody writes a response body read in full, logging write failures,
+// which are caused by the client going away.
+func writeBody(w http.ResponseWriter, buf *bytes.Buffer) {
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Debugw("Failed to write response", "err", err)
 	}
 }
@@ -0,0 +1,254 @@
+package server_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2phttp "github.com/libp2p/go-libp2p/p2p/http"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/server"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func publishAdvert(t *testing.T, priv crypto.PrivKey) (store.FullStore, ipld.Link) {
+	t.Helper()
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	p, err := publisher.New(priv, st)
+	require.NoError(t, err)
+
+	digests := testutil.RandomMultihashes(t, 10)
+	adlnk, err := p.Publish(context.Background(), peer.AddrInfo{ID: pid}, testutil.RandomCID(t).String(), slices.Values(digests), metadata.Default.New())
+	require.NoError(t, err)
+	return st, adlnk
+}
+
+func requireServesAdverts(t *testing.T, client http.Client, baseURL string, adlnk ipld.Link) {
+	t.Helper()
+
+	res, err := client.Get(baseURL + "head")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	hd, err := head.Decode(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, adlnk.String(), hd.Head.String())
+
+	res, err = client.Get(baseURL + adlnk.String())
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	data, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	_, err = schema.BytesToAdvertisement(hd.Head.(cidlink.Link).Cid, data)
+	require.NoError(t, err)
+
+	res, err = client.Get(baseURL + testutil.RandomCID(t).String())
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestServer(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	t.Run("http", func(t *testing.T) {
+		st, adlnk := publishAdvert(t, priv)
+
+		srv, err := server.NewServer(st)
+		require.NoError(t, err)
+
+		ts := httptest.NewServer(srv)
+		defer ts.Close()
+
+		requireServesAdverts(t, *ts.Client(), ts.URL+server.IPNIPath+"/", adlnk)
+	})
+
+	t.Run("libp2p", func(t *testing.T) {
+		st, adlnk := publishAdvert(t, priv)
+
+		serverHost, err := libp2p.New(libp2p.Identity(priv), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		defer serverHost.Close()
+
+		srv, err := server.NewServer(st, server.WithStreamHost(serverHost))
+		require.NoError(t, err)
+		require.NoError(t, srv.Start(context.Background()))
+		defer srv.Shutdown(context.Background())
+
+		clientHost, err := libp2p.New(libp2p.NoListenAddrs)
+		require.NoError(t, err)
+		defer clientHost.Close()
+
+		httpHost := libp2phttp.Host{StreamHost: clientHost}
+		client, err := httpHost.NamespacedClient(server.ProtocolID, peer.AddrInfo{ID: serverHost.ID(), Addrs: serverHost.Addrs()})
+		require.NoError(t, err)
+
+		requireServesAdverts(t, client, "/", adlnk)
+	})
+}
+
+func TestServerLifecycle(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	st, adlnk := publishAdvert(t, priv)
+
+	srv, err := server.NewServer(st, server.WithHTTPListenAddrs("127.0.0.1:0"))
+	require.NoError(t, err)
+	require.Nil(t, srv.Addr())
+	require.NoError(t, srv.Start(ctx))
+
+	baseURL := "http://" + srv.Addr().String()
+	requireServesAdverts(t, http.Client{}, baseURL+server.IPNIPath+"/", adlnk)
+
+	t.Run("health", func(t *testing.T) {
+		res, err := http.Get(baseURL + server.HealthPath)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	})
+
+	t.Run("caching headers", func(t *testing.T) {
+		res, err := http.Get(baseURL + server.IPNIPath + "/head")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+		require.Empty(t, res.Header.Get("ETag"))
+
+		res, err = http.Get(baseURL + server.IPNIPath + "/" + adlnk.String())
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Contains(t, res.Header.Get("Cache-Control"), "immutable")
+		etag := res.Header.Get("ETag")
+		require.Equal(t, `"`+adlnk.String()+`"`, etag)
+
+		req, err := http.NewRequest(http.MethodGet, baseURL+server.IPNIPath+"/"+adlnk.String(), nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", etag)
+		res, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusNotModified, res.StatusCode)
+
+		res, err = http.Get(baseURL + server.IPNIPath + "/" + testutil.RandomCID(t).String())
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusNotFound, res.StatusCode)
+		require.Empty(t, res.Header.Get("ETag"))
+		require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	})
+
+	t.Run("start error", func(t *testing.T) {
+		other, err := server.NewServer(st, server.WithHTTPListenAddrs(srv.Addr().String()))
+		require.NoError(t, err)
+		require.Error(t, other.Start(ctx))
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		require.NoError(t, srv.Shutdown(ctx))
+		_, err := http.Get(baseURL + server.HealthPath)
+		require.Error(t, err)
+	})
+}
+
+// partialStore writes part of a block before failing to encode it.
+type partialStore struct {
+	store.EncodeableStore
+}
+
+func (partialStore) Encode(ctx context.Context, id ipld.Link, w io.Writer) error {
+	if _, err := w.Write([]byte("partial")); err != nil {
+		return err
+	}
+	return errors.New("encode failed")
+}
+
+func TestServerEncodeError(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	st, adlnk := publishAdvert(t, priv)
+
+	srv, err := server.NewServer(partialStore{st}, server.WithHTTPListenAddrs("127.0.0.1:0"))
+	require.NoError(t, err)
+	require.NoError(t, srv.Start(ctx))
+	defer srv.Shutdown(ctx)
+
+	res, err := http.Get("http://" + srv.Addr().String() + server.IPNIPath + "/" + adlnk.String())
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	require.Equal(t, "no-store", res.Header.Get("Cache-Control"))
+	require.Empty(t, res.Header.Get("ETag"))
+	require.NotContains(t, string(body), "partial")
+}
+
+func TestServerP2PStartError(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	st, _ := publishAdvert(t, priv)
+
+	// a host without listen addresses cannot serve requests
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer h.Close()
+
+	srv, err := server.NewServer(st, server.WithStreamHost(h))
+	require.NoError(t, err)
+	require.Error(t, srv.Start(context.Background()))
+}
+
+func TestServerTLS(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("missing certificate", func(t *testing.T) {
+		_, err := server.NewServer(nil, server.WithTLSConfig(&tls.Config{}))
+		require.Error(t, err)
+	})
+
+	t.Run("serves https", func(t *testing.T) {
+		st, adlnk := publishAdvert(t, priv)
+
+		// borrow the test certificate and a client that trusts it
+		ts := httptest.NewTLSServer(http.NotFoundHandler())
+		defer ts.Close()
+
+		srv, err := server.NewServer(st, server.WithHTTPListenAddrs("127.0.0.1:0"), server.WithTLSConfig(ts.TLS.Clone()))
+		require.NoError(t, err)
+		require.NoError(t, srv.Start(ctx))
+		defer srv.Shutdown(ctx)
+
+		requireServesAdverts(t, *ts.Client(), "https://"+srv.Addr().String()+server.IPNIPath+"/", adlnk)
+	})
+}
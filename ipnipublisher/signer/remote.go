@@ -0,0 +1,173 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+const (
+	// PublicKeyPath is the path a signing service serves the marshaled public
+	// key of its identity at.
+	PublicKeyPath = "/publickey"
+	// SignPath is the path a signing service accepts data to sign at. The
+	// request body is the data and the response body is the signature.
+	SignPath = "/sign"
+)
+
+// MaxSignRequestSize is the maximum size of the data a signing service
+// accepts.
+var MaxSignRequestSize int64 = 1 << 20
+
+// RemoteOption is an option configuring a remote signer.
+type RemoteOption func(cfg *remoteConfig)
+
+type remoteConfig struct {
+	client *http.Client
+	pubKey crypto.PubKey
+}
+
+// WithHTTPClient configures the HTTP client used to talk to the signing
+// service. If not configured, [http.DefaultClient] is used.
+func WithHTTPClient(client *http.Client) RemoteOption {
+	return func(cfg *remoteConfig) {
+		cfg.client = client
+	}
+}
+
+// WithPublicKey pins the public key of the signing service identity. If not
+// configured, it is fetched from the service. Signatures returned by the
+// service are always verified against the public key.
+func WithPublicKey(pubKey crypto.PubKey) RemoteOption {
+	return func(cfg *remoteConfig) {
+		cfg.pubKey = pubKey
+	}
+}
+
+// RemoteSigner is a signer backed by an external signing service, such as a
+// local signing daemon or a KMS proxy, served over HTTP by [NewHandler] or a
+// compatible implementation.
+type RemoteSigner struct {
+	endpoint *url.URL
+	client   *http.Client
+	pubKey   crypto.PubKey
+}
+
+var _ Signer = (*RemoteSigner)(nil)
+
+// NewRemoteSigner creates a signer that signs using the signing service at the
+// passed endpoint URL.
+func NewRemoteSigner(ctx context.Context, endpoint string, opts ...RemoteOption) (*RemoteSigner, error) {
+	cfg := remoteConfig{client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing service URL: %w", err)
+	}
+	s := &RemoteSigner{endpoint: u, client: cfg.client, pubKey: cfg.pubKey}
+	if s.pubKey == nil {
+		s.pubKey, err = s.fetchPublicKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *RemoteSigner) PublicKey() crypto.PubKey {
+	return s.pubKey
+}
+
+func (s *RemoteSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint.JoinPath(SignPath).String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	sig, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("signing with remote signer: %w", err)
+	}
+	ok, err := s.pubKey.Verify(data, sig)
+	if err != nil {
+		return nil, fmt.Errorf("verifying remote signature: %w", err)
+	}
+	if !ok {
+		return nil, errors.New("remote signer returned an invalid signature")
+	}
+	return sig, nil
+}
+
+func (s *RemoteSigner) fetchPublicKey(ctx context.Context) (crypto.PubKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint.JoinPath(PublicKeyPath).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching remote signer public key: %w", err)
+	}
+	pubKey, err := crypto.UnmarshalPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding remote signer public key: %w", err)
+	}
+	return pubKey, nil
+}
+
+func (s *RemoteSigner) do(req *http.Request) ([]byte, error) {
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", res.StatusCode, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
+
+// NewHandler creates an HTTP handler serving a signing service for the passed
+// signer, which [RemoteSigner] can talk to. It can be used to run a signing
+// daemon that holds the key outside the publishing process, or to front a KMS.
+//
+// The handler does not authenticate requests; it must only be reachable by
+// trusted clients, e.g. on a loopback interface or behind an authenticating
+// proxy.
+func NewHandler(s Signer) (http.Handler, error) {
+	pubKey, err := crypto.MarshalPublicKey(s.PublicKey())
+	if err != nil {
+		return nil, fmt.Errorf("marshaling public key: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+PublicKeyPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(pubKey)
+	})
+	mux.HandleFunc("POST "+SignPath, func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxSignRequestSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		sig, err := s.Sign(r.Context(), data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(sig)
+	})
+	return mux, nil
+}
@@ -0,0 +1,87 @@
+// Package signer abstracts signing of IPNI advertisements and heads, so that
+// the publisher does not need the private key in process memory.
+package signer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrPrivateKeyUnavailable is returned when the raw private key of a signer
+// is requested.
+var ErrPrivateKeyUnavailable = errors.New("private key is not available")
+
+// Signer signs data on behalf of a publisher identity.
+type Signer interface {
+	// PublicKey returns the public key signatures can be verified with.
+	PublicKey() crypto.PubKey
+	// Sign signs the passed data.
+	Sign(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// ID returns the peer ID of the signer identity.
+func ID(s Signer) (peer.ID, error) {
+	return peer.IDFromPublicKey(s.PublicKey())
+}
+
+type keySigner struct {
+	key crypto.PrivKey
+}
+
+// FromPrivKey creates a signer that signs with a private key held in memory.
+func FromPrivKey(key crypto.PrivKey) Signer {
+	return keySigner{key}
+}
+
+func (s keySigner) PublicKey() crypto.PubKey {
+	return s.key.GetPublic()
+}
+
+func (s keySigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	return s.key.Sign(data)
+}
+
+// AsPrivKey adapts a signer to the [crypto.PrivKey] interface, for use with
+// APIs that sign with a private key, such as advertisement and head signing.
+// Signing uses the passed context. The raw private key cannot be retrieved
+// from the returned key.
+func AsPrivKey(ctx context.Context, s Signer) crypto.PrivKey {
+	if ks, ok := s.(keySigner); ok {
+		return ks.key
+	}
+	return &signerKey{ctx: ctx, signer: s}
+}
+
+type signerKey struct {
+	ctx    context.Context
+	signer Signer
+}
+
+var _ crypto.PrivKey = (*signerKey)(nil)
+
+func (k *signerKey) Sign(data []byte) ([]byte, error) {
+	return k.signer.Sign(k.ctx, data)
+}
+
+func (k *signerKey) GetPublic() crypto.PubKey {
+	return k.signer.PublicKey()
+}
+
+func (k *signerKey) Raw() ([]byte, error) {
+	return nil, ErrPrivateKeyUnavailable
+}
+
+func (k *signerKey) Type() pb.KeyType {
+	return k.signer.PublicKey().Type()
+}
+
+func (k *signerKey) Equals(other crypto.Key) bool {
+	if priv, ok := other.(crypto.PrivKey); ok {
+		return k.GetPublic().Equals(priv.GetPublic())
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package signer_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/signer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	ctx := context.Background()
+
+	priv, pub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	handler, err := signer.NewHandler(signer.FromPrivKey(priv))
+	require.NoError(t, err)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	t.Run("fetches public key", func(t *testing.T) {
+		s, err := signer.NewRemoteSigner(ctx, ts.URL)
+		require.NoError(t, err)
+		require.True(t, pub.Equals(s.PublicKey()))
+
+		id, err := signer.ID(s)
+		require.NoError(t, err)
+		expected, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		require.Equal(t, expected, id)
+
+		data := []byte("data to sign")
+		sig, err := s.Sign(ctx, data)
+		require.NoError(t, err)
+		ok, err := pub.Verify(data, sig)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("rejects signature from other key", func(t *testing.T) {
+		_, other, err := crypto.GenerateEd25519Key(nil)
+		require.NoError(t, err)
+
+		s, err := signer.NewRemoteSigner(ctx, ts.URL, signer.WithPublicKey(other))
+		require.NoError(t, err)
+		_, err = s.Sign(ctx, []byte("data to sign"))
+		require.ErrorContains(t, err, "invalid signature")
+	})
+
+	t.Run("unavailable service", func(t *testing.T) {
+		_, err := signer.NewRemoteSigner(ctx, "http://127.0.0.1:0")
+		require.Error(t, err)
+	})
+}
+
+func TestAsPrivKey(t *testing.T) {
+	ctx := context.Background()
+
+	priv, pub, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	// in memory keys are used as is
+	require.Equal(t, priv, signer.AsPrivKey(ctx, signer.FromPrivKey(priv)))
+
+	handler, err := signer.NewHandler(signer.FromPrivKey(priv))
+	require.NoError(t, err)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	s, err := signer.NewRemoteSigner(ctx, ts.URL, signer.WithPublicKey(pub))
+	require.NoError(t, err)
+
+	key := signer.AsPrivKey(ctx, s)
+	require.True(t, pub.Equals(key.GetPublic()))
+	require.Equal(t, pub.Type(), key.Type())
+	require.True(t, key.Equals(priv))
+	_, err = key.Raw()
+	require.ErrorIs(t, err, signer.ErrPrivateKeyUnavailable)
+
+	data := []byte("data to sign")
+	sig, err := key.Sign(data)
+	require.NoError(t, err)
+	ok, err := pub.Verify(data, sig)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
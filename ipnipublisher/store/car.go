@@ -0,0 +1,357 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/storacha/go-ucanto/core/car"
+	"github.com/storacha/go-ucanto/core/ipld/block"
+)
+
+// ExportOption is an option configuring an export of the advertisement chain.
+type ExportOption func(cfg *exportConfig)
+
+type exportConfig struct {
+	from, until ipld.Link
+	skipEntries bool
+	v2          bool
+}
+
+// WithExportRange restricts the export to the advertisements from the from
+// advertisement back to and including the until advertisement. A nil from
+// starts at the head and a nil until continues to the genesis advertisement.
+func WithExportRange(from, until ipld.Link) ExportOption {
+	return func(cfg *exportConfig) {
+		cfg.from = from
+		cfg.until = until
+	}
+}
+
+// WithoutExportedEntries excludes the advertisement entry chunks from the
+// export.
+func WithoutExportedEntries() ExportOption {
+	return func(cfg *exportConfig) {
+		cfg.skipEntries = true
+	}
+}
+
+// WithCARv2 writes the export as an indexed CARv2 instead of a CARv1. Note the
+// CARv1 payload is buffered in memory to build the index.
+func WithCARv2() ExportOption {
+	return func(cfg *exportConfig) {
+		cfg.v2 = true
+	}
+}
+
+// Export writes the advertisement chain, and the entry chunks of each
+// advertisement, to w as a CAR. The first root of the CAR is the newest
+// exported advertisement. When the export starts at the head, the signed head
+// is included as a block and its CID is the second root.
+//
+// Advertisements are written newest first, each followed by its entry chunks.
+// Entry chains shared by several advertisements are only written once.
+func Export(ctx context.Context, s FullStore, w io.Writer, opts ...ExportOption) error {
+	cfg := exportConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	hd, err := s.Head(ctx)
+	if err != nil {
+		if !IsNotFound(err) || cfg.from == nil {
+			return fmt.Errorf("reading head: %w", err)
+		}
+		hd = nil
+	}
+
+	start := cfg.from
+	if start == nil {
+		start = hd.Head
+	}
+	roots := []ipld.Link{start}
+
+	var headBlock block.Block
+	if hd != nil && sameLink(start, hd.Head) {
+		headBlock, err = encodedHeadBlock(ctx, s)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, headBlock.Link())
+	}
+
+	blocks := func(yield func(block.Block, error) bool) {
+		if headBlock != nil && !yield(headBlock, nil) {
+			return
+		}
+		exported := map[string]struct{}{}
+		for cur := start; cur != nil; {
+			blk, err := encodedBlock(ctx, s, cur)
+			if err != nil {
+				yield(nil, fmt.Errorf("exporting advert %s: %w", cur, err))
+				return
+			}
+			ad, err := schema.BytesToAdvertisement(asCID(cur), blk.Bytes())
+			if err != nil {
+				yield(nil, fmt.Errorf("decoding advert %s: %w", cur, err))
+				return
+			}
+			if !yield(blk, nil) {
+				return
+			}
+			if !cfg.skipEntries && !ad.IsRm {
+				for blk, err := range entryBlocks(ctx, s, ad.Entries, exported) {
+					if !yield(blk, err) || err != nil {
+						return
+					}
+				}
+			}
+			if cfg.until != nil && sameLink(cur, cfg.until) {
+				return
+			}
+			cur = ad.PreviousID
+		}
+		if cfg.until != nil {
+			yield(nil, fmt.Errorf("advert %s not found in chain from %s", cfg.until, start))
+		}
+	}
+
+	r := car.Encode(roots, blocks)
+	defer r.Close()
+	if !cfg.v2 {
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	v1, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return carv2.WrapV1(bytes.NewReader(v1), w)
+}
+
+// entryBlocks yields the blocks of the entry chain starting at root, skipping
+// chains that have already been exported.
+func entryBlocks(ctx context.Context, s EncodeableStore, root ipld.Link, exported map[string]struct{}) iter.Seq2[block.Block, error] {
+	return func(yield func(block.Block, error) bool) {
+		for cur := root; cur != nil && cur != schema.NoEntries; {
+			if _, ok := exported[cur.String()]; ok {
+				return
+			}
+			exported[cur.String()] = struct{}{}
+
+			blk, err := encodedBlock(ctx, s, cur)
+			if err != nil {
+				yield(nil, fmt.Errorf("exporting entry chunk %s: %w", cur, err))
+				return
+			}
+			chunk, err := schema.BytesToEntryChunk(asCID(cur), blk.Bytes())
+			if err != nil {
+				yield(nil, fmt.Errorf("decoding entry chunk %s: %w", cur, err))
+				return
+			}
+			if !yield(blk, nil) {
+				return
+			}
+			cur = chunk.Next
+		}
+	}
+}
+
+func encodedBlock(ctx context.Context, s EncodeableStore, lnk ipld.Link) (block.Block, error) {
+	var buf bytes.Buffer
+	if err := s.Encode(ctx, lnk, &buf); err != nil {
+		return nil, err
+	}
+	return block.NewBlock(lnk, buf.Bytes()), nil
+}
+
+// encodedHeadBlock returns the signed head as a block, addressed the same way
+// as by [ReplaceHead].
+func encodedHeadBlock(ctx context.Context, s EncodeableStore) (block.Block, error) {
+	var buf bytes.Buffer
+	if err := s.EncodeHead(ctx, &buf); err != nil {
+		return nil, fmt.Errorf("exporting head: %w", err)
+	}
+	prefix := cid.Prefix{Version: 1, Codec: uint64(multicodec.Json), MhType: multihash.SHA2_256, MhLength: -1}
+	c, err := prefix.Sum(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return block.NewBlock(cidlink.Link{Cid: c}, buf.Bytes()), nil
+}
+
+// ImportResult describes what was imported from a CAR.
+type ImportResult struct {
+	// Head is the advertisement the imported head points to, or nil if the CAR
+	// did not include a head.
+	Head ipld.Link
+	// Adverts is the number of advertisements imported.
+	Adverts int
+	// Blocks is the total number of blocks imported, including entry chunks.
+	Blocks int
+}
+
+// ImportableStore is a store that advertisement chains exported by [Export]
+// can be imported into.
+type ImportableStore interface {
+	// Import reads a CARv1 or CARv2 written by [Export], writing its blocks to
+	// the store and updating the provider/context ID tables to reflect the
+	// imported advertisements. If the CAR includes a signed head it replaces the
+	// current head, provided its signature is valid and it points to the newest
+	// imported advertisement.
+	Import(ctx context.Context, r io.Reader) (ImportResult, error)
+}
+
+var _ ImportableStore = (*AdStore)(nil)
+
+// Import implements [ImportableStore].
+//
+// The provider/context ID tables are updated by applying the imported
+// advertisements oldest first, so the latest advertisement for each provider
+// and context ID wins. Digest set hashes are discarded and recalculated from
+// the entries when next needed. When only a range of the chain is imported,
+// context IDs advertised before the range are not added to the tables.
+func (s *AdStore) Import(ctx context.Context, r io.Reader) (ImportResult, error) {
+	var result ImportResult
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		return result, fmt.Errorf("reading CAR: %w", err)
+	}
+	if len(br.Roots) == 0 {
+		return result, errors.New("CAR has no roots")
+	}
+	start := cidlink.Link{Cid: br.Roots[0]}
+	var headCID cid.Cid
+	if len(br.Roots) > 1 {
+		headCID = br.Roots[1]
+	}
+
+	imported := map[string]struct{}{}
+	var headBytes []byte
+	for {
+		blk, err := br.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return result, fmt.Errorf("reading CAR block: %w", err)
+		}
+		if headCID.Defined() && blk.Cid().Equals(headCID) {
+			headBytes = blk.RawData()
+			continue
+		}
+		key := cidlink.Link{Cid: blk.Cid()}.String()
+		data := blk.RawData()
+		if err := s.store.Put(ctx, key, uint64(len(data)), bytes.NewReader(data)); err != nil {
+			return result, fmt.Errorf("writing block %s: %w", key, err)
+		}
+		imported[key] = struct{}{}
+		result.Blocks++
+	}
+
+	// The signed head is checked before any table is updated, so that a CAR
+	// with a forged or mismatched head is rejected without changing the store.
+	var hd *head.SignedHead
+	if headBytes != nil {
+		hd, err = head.Decode(bytes.NewReader(headBytes))
+		if err != nil {
+			return result, fmt.Errorf("decoding imported head: %w", err)
+		}
+		if _, err := hd.Validate(); err != nil {
+			return result, fmt.Errorf("verifying imported head signature: %w", err)
+		}
+		if !sameLink(hd.Head, start) {
+			return result, fmt.Errorf("imported head %s does not match root advert %s", hd.Head, start)
+		}
+	}
+
+	// Walk the imported part of the chain, newest first.
+	var ads []schema.Advertisement
+	for cur := ipld.Link(start); cur != nil; {
+		if _, ok := imported[cur.String()]; !ok {
+			break
+		}
+		ad, err := Advert(ctx, s.store, cur)
+		if err != nil {
+			return result, fmt.Errorf("reading imported advert %s: %w", cur, err)
+		}
+		ads = append(ads, ad)
+		cur = ad.PreviousID
+	}
+	if len(ads) == 0 {
+		return result, fmt.Errorf("CAR does not contain root advert %s", start)
+	}
+	result.Adverts = len(ads)
+
+	for _, ad := range slices.Backward(ads) {
+		if err := s.applyAdvert(ctx, ad); err != nil {
+			return result, err
+		}
+	}
+
+	if hd != nil {
+		prev, err := Head(ctx, s.store)
+		if err != nil {
+			if !IsNotFound(err) {
+				return result, fmt.Errorf("reading current head: %w", err)
+			}
+			prev = nil
+		}
+		if _, err := ReplaceHead(ctx, s.store, prev, hd); err != nil {
+			return result, fmt.Errorf("replacing head: %w", err)
+		}
+		result.Head = hd.Head
+	}
+
+	log.Infow("Imported advertisement chain", "head", result.Head, "adverts", result.Adverts, "blocks", result.Blocks)
+	return result, nil
+}
+
+// applyAdvert updates the provider/context ID tables for an advertisement, in
+// the same way as when it was generated.
+func (s *AdStore) applyAdvert(ctx context.Context, ad schema.Advertisement) error {
+	provider, err := peer.Decode(ad.Provider)
+	if err != nil {
+		return fmt.Errorf("decoding advert provider: %w", err)
+	}
+	if !ad.IsRm {
+		if err := PutChunkLink(ctx, s.chunkLinks, provider, ad.ContextID, ad.Entries); err != nil {
+			return fmt.Errorf("writing chunk link: %w", err)
+		}
+		if err := s.metadata.Put(ctx, provider, ad.ContextID, ad.Metadata); err != nil {
+			return fmt.Errorf("writing metadata: %w", err)
+		}
+	} else {
+		if err := ignoreNotFound(s.chunkLinks.Delete(ctx, provider, ad.ContextID)); err != nil {
+			return fmt.Errorf("deleting chunk link: %w", err)
+		}
+		if err := ignoreNotFound(s.metadata.Delete(ctx, provider, ad.ContextID)); err != nil {
+			return fmt.Errorf("deleting metadata: %w", err)
+		}
+	}
+	if err := ignoreNotFound(s.DeleteDigestSetHashForProviderAndContextID(ctx, provider, ad.ContextID)); err != nil {
+		return fmt.Errorf("deleting digest set hash: %w", err)
+	}
+	return nil
+}
+
+func ignoreNotFound(err error) error {
+	if err != nil && IsNotFound(err) {
+		return nil
+	}
+	return err
+}
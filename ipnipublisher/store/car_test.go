@@ -0,0 +1,204 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/storacha/go-ucanto/core/car"
+	"github.com/storacha/go-ucanto/core/ipld/block"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	src := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()), store.WithEntryChunkSize(2))
+	p, err := publisher.New(priv, src)
+	require.NoError(t, err)
+
+	var ads []ipld.Link
+	var contextIDs [][]byte
+	for range 3 {
+		contextID := testutil.RandomCID(t).String()
+		md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+		lnk, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, contextID, slices.Values(testutil.RandomMultihashes(t, 5)), md)
+		require.NoError(t, err)
+		ads = append(ads, lnk)
+		contextIDs = append(contextIDs, []byte(contextID))
+	}
+
+	// remove the first context ID, so the import must apply a removal
+	ap, err := publisher.NewAdvertisementPublisher(priv, src)
+	require.NoError(t, err)
+	rm, err := publisher.GenerateAd(ctx, src, pid, nil, contextIDs[0], metadata.Default.New(), true, nil)
+	require.NoError(t, err)
+	require.NoError(t, ap.AddToBatch(rm))
+	rmLink, err := ap.Commit(ctx)
+	require.NoError(t, err)
+	ads = append(ads, rmLink)
+
+	requireSameState := func(t *testing.T, dst store.FullStore) {
+		srcHead, err := src.Head(ctx)
+		require.NoError(t, err)
+		dstHead, err := dst.Head(ctx)
+		require.NoError(t, err)
+		require.Equal(t, srcHead.Head, dstHead.Head)
+
+		_, err = dst.ChunkLinkForProviderAndContextID(ctx, pid, contextIDs[0])
+		require.True(t, store.IsNotFound(err))
+		for _, contextID := range contextIDs[1:] {
+			want, err := src.ChunkLinkForProviderAndContextID(ctx, pid, contextID)
+			require.NoError(t, err)
+			got, err := dst.ChunkLinkForProviderAndContextID(ctx, pid, contextID)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+
+			wantMd, err := src.MetadataForProviderAndContextID(ctx, pid, contextID)
+			require.NoError(t, err)
+			gotMd, err := dst.MetadataForProviderAndContextID(ctx, pid, contextID)
+			require.NoError(t, err)
+			require.True(t, wantMd.Equal(gotMd))
+		}
+
+		report, err := store.Verify(ctx, dst)
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+		require.Equal(t, 4, report.Adverts)
+		require.Equal(t, 15, report.Entries)
+	}
+
+	t.Run("CARv1 to local store", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, store.Export(ctx, src, &buf))
+
+		dst := store.FromLocalStore(t.TempDir(), datastore.NewMapDatastore())
+		res, err := dst.(store.ImportableStore).Import(ctx, &buf)
+		require.NoError(t, err)
+		require.Equal(t, ads[3], res.Head)
+		require.Equal(t, 4, res.Adverts)
+		// 4 adverts and 3 entry chains of 3 chunks each
+		require.Equal(t, 13, res.Blocks)
+
+		requireSameState(t, dst)
+	})
+
+	t.Run("CARv2", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, store.Export(ctx, src, &buf, store.WithCARv2()))
+		require.Equal(t, []byte{0x0a, 0xa1, 0x67, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x02}, buf.Bytes()[:11])
+
+		dst := store.FromDatastore(datastore.NewMapDatastore())
+		_, err := dst.(store.ImportableStore).Import(ctx, &buf)
+		require.NoError(t, err)
+
+		requireSameState(t, dst)
+	})
+
+	t.Run("range without entries", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, store.Export(ctx, src, &buf, store.WithExportRange(ads[2], ads[1]), store.WithoutExportedEntries()))
+
+		dst := store.FromDatastore(datastore.NewMapDatastore())
+		res, err := dst.(store.ImportableStore).Import(ctx, &buf)
+		require.NoError(t, err)
+		require.Nil(t, res.Head)
+		require.Equal(t, 2, res.Adverts)
+		require.Equal(t, 2, res.Blocks)
+
+		_, err = dst.Head(ctx)
+		require.True(t, store.IsNotFound(err))
+		_, err = dst.Advert(ctx, ads[0])
+		require.True(t, store.IsNotFound(err))
+		for _, contextID := range contextIDs[1:3] {
+			_, err := dst.ChunkLinkForProviderAndContextID(ctx, pid, contextID)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("invalid head", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, store.Export(ctx, src, &buf))
+		roots, blocks, err := car.Decode(&buf)
+		require.NoError(t, err)
+		var rest []block.Block
+		for blk, err := range blocks {
+			require.NoError(t, err)
+			if blk.Link().String() != roots[1].String() {
+				rest = append(rest, blk)
+			}
+		}
+		// withHead returns the exported CAR with its signed head replaced
+		withHead := func(hd *head.SignedHead) io.Reader {
+			data, err := hd.Encode()
+			require.NoError(t, err)
+			c, err := cid.Prefix{Version: 1, Codec: uint64(multicodec.Json), MhType: multihash.SHA2_256, MhLength: -1}.Sum(data)
+			require.NoError(t, err)
+			headBlock := block.NewBlock(cidlink.Link{Cid: c}, data)
+			return car.Encode([]ipld.Link{roots[0], headBlock.Link()}, func(yield func(block.Block, error) bool) {
+				if !yield(headBlock, nil) {
+					return
+				}
+				for _, blk := range rest {
+					if !yield(blk, nil) {
+						return
+					}
+				}
+			})
+		}
+
+		forged, err := head.NewSignedHead(ads[3].(cidlink.Link).Cid, "", priv)
+		require.NoError(t, err)
+		forged.Sig[0] ^= 0xff
+		mismatched, err := head.NewSignedHead(ads[2].(cidlink.Link).Cid, "", priv)
+		require.NoError(t, err)
+
+		for name, tc := range map[string]struct {
+			head *head.SignedHead
+			err  string
+		}{
+			"bad signature":    {forged, "signature"},
+			"different advert": {mismatched, "does not match root advert"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				dst := store.FromDatastore(datastore.NewMapDatastore())
+				_, err := dst.(store.ImportableStore).Import(ctx, withHead(tc.head))
+				require.ErrorContains(t, err, tc.err)
+
+				_, err = dst.Head(ctx)
+				require.True(t, store.IsNotFound(err))
+				for _, contextID := range contextIDs[1:] {
+					_, err := dst.ChunkLinkForProviderAndContextID(ctx, pid, contextID)
+					require.True(t, store.IsNotFound(err))
+				}
+			})
+		}
+	})
+
+	t.Run("range end not in chain", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := store.Export(ctx, src, &buf, store.WithExportRange(nil, testutil.RandomCID(t)))
+		require.ErrorContains(t, err, "not found in chain")
+	})
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"iter"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// EntryDeduplicator filters repeated multihashes out of a stream of
+// advertisement entries, keeping the first occurrence of each.
+//
+// It keeps an exact set of the multihashes it has seen rather than a
+// probabilistic filter, since a false positive would silently drop a
+// multihash from the advertisement. Memory is bounded by the limit: once the
+// set holds limit multihashes, new multihashes are no longer tracked and any
+// repeats of them are passed through. This only affects how compact the entry
+// chain is, never which multihashes are advertised.
+type EntryDeduplicator struct {
+	seen    map[string]struct{}
+	limit   int
+	dropped int
+}
+
+// NewEntryDeduplicator creates a new deduplicator that tracks at most limit
+// distinct multihashes. A limit of zero or less means no limit.
+func NewEntryDeduplicator(limit int) *EntryDeduplicator {
+	return &EntryDeduplicator{seen: map[string]struct{}{}, limit: limit}
+}
+
+// Filter returns an iterator that yields the multihashes from entries,
+// skipping any that have already been seen by this deduplicator.
+func (d *EntryDeduplicator) Filter(entries iter.Seq[multihash.Multihash]) iter.Seq[multihash.Multihash] {
+	return func(yield func(multihash.Multihash) bool) {
+		for mh := range entries {
+			k := string(mh)
+			if _, ok := d.seen[k]; ok {
+				d.dropped++
+				continue
+			}
+			if d.limit <= 0 || len(d.seen) < d.limit {
+				d.seen[k] = struct{}{}
+			}
+			if !yield(mh) {
+				return
+			}
+		}
+	}
+}
+
+// Dropped returns the number of duplicate multihashes that have been filtered
+// out so far.
+func (d *EntryDeduplicator) Dropped() int {
+	return d.dropped
+}
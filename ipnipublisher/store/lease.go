@@ -0,0 +1,226 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseKey is the key the lease record is stored under by default.
+const DefaultLeaseKey = "lease"
+
+// DefaultLeaseTTL is the default time a lease is held for without renewal.
+const DefaultLeaseTTL = 30 * time.Second
+
+type leaseRecord struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// LeaseOption is an option configuring a leader election.
+type LeaseOption func(l *LeaderElection)
+
+// WithLeaseKey configures the key the lease record is stored under. If not
+// configured, [DefaultLeaseKey] is used.
+func WithLeaseKey(key string) LeaseOption {
+	return func(l *LeaderElection) {
+		l.key = key
+	}
+}
+
+// WithLeaseTTL configures the time a lease is held for without renewal. If not
+// configured, [DefaultLeaseTTL] is used.
+func WithLeaseTTL(ttl time.Duration) LeaseOption {
+	return func(l *LeaderElection) {
+		l.ttl = ttl
+	}
+}
+
+// LeaderElection elects a single leader among replicas sharing a store, using
+// a lease record that is acquired and renewed with [Store.Replace]. It allows
+// replicas publishing through the same store to ensure only one of them commits
+// the advertisement chain at a time.
+//
+// Leases expire by wall clock time, so the TTL must be comfortably larger than
+// the clock skew between replicas. Leadership is given up locally a third of
+// the TTL before the lease expires, to allow for delays in renewing it.
+type LeaderElection struct {
+	store Store
+	key   string
+	id    string
+	ttl   time.Duration
+
+	mutex sync.Mutex
+	// held is the lease record written by this replica, or nil if it does not
+	// hold the lease.
+	held    []byte
+	expires time.Time
+}
+
+// NewLeaderElection creates a leader election for the replica with the passed
+// ID, which must be unique among the replicas sharing the store. The lease is
+// only safe if [Store.Replace] is atomic across the replicas, so it returns
+// [ErrReplaceNotAtomic] for stores reporting otherwise through
+// [AtomicReplacer], such as a store from a datastore that does not implement
+// [ReplaceableDatastore]. Stores that do not implement [AtomicReplacer] are
+// assumed to replace atomically.
+func NewLeaderElection(store Store, id string, opts ...LeaseOption) (*LeaderElection, error) {
+	if ar, ok := store.(AtomicReplacer); ok && !ar.AtomicReplace() {
+		return nil, ErrReplaceNotAtomic
+	}
+	l := &LeaderElection{store: store, key: DefaultLeaseKey, id: id, ttl: DefaultLeaseTTL}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l, nil
+}
+
+// TryAcquire acquires the lease if it is free or expired, or renews it if it
+// is already held by this replica. It returns true if this replica holds the
+// lease.
+func (l *LeaderElection) TryAcquire(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	cur, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	var old io.Reader
+	if cur != nil {
+		var rec leaseRecord
+		if err := json.Unmarshal(cur, &rec); err != nil {
+			return false, fmt.Errorf("decoding lease record: %w", err)
+		}
+		if rec.Holder != l.id && time.Now().Before(rec.Expires) {
+			l.held = nil
+			return false, nil
+		}
+		old = bytes.NewReader(cur)
+	}
+
+	rec := leaseRecord{Holder: l.id, Expires: time.Now().Add(l.ttl)}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	err = l.store.Replace(ctx, l.key, old, uint64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		l.held = nil
+		if errors.Is(err, ErrPreconditionFailed) {
+			return false, nil
+		}
+		return false, fmt.Errorf("writing lease record: %w", err)
+	}
+	l.held = data
+	l.expires = rec.Expires
+	return true, nil
+}
+
+// Release gives up the lease if it is held by this replica, so that another
+// replica can acquire it without waiting for it to expire.
+func (l *LeaderElection) Release(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.held == nil {
+		return nil
+	}
+	held := l.held
+	l.held = nil
+
+	data, err := json.Marshal(leaseRecord{})
+	if err != nil {
+		return err
+	}
+	err = l.store.Replace(ctx, l.key, bytes.NewReader(held), uint64(len(data)), bytes.NewReader(data))
+	if err != nil && !errors.Is(err, ErrPreconditionFailed) {
+		return fmt.Errorf("releasing lease: %w", err)
+	}
+	return nil
+}
+
+// IsLeader returns true if this replica holds the lease and it is not close to
+// expiring.
+func (l *LeaderElection) IsLeader() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.held != nil && time.Now().Before(l.expires.Add(-l.ttl/3))
+}
+
+// Run campaigns for leadership until the context is canceled, renewing the
+// lease every third of the TTL while it is held. Each time leadership is
+// acquired, lead is called in a new goroutine with a context that is canceled
+// when leadership is lost. The lease is released when Run returns.
+func (l *LeaderElection) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	// stopLeading cancels the current call to lead and waits for it to return,
+	// or is nil when not leading.
+	var stopLeading func()
+	defer func() {
+		if stopLeading != nil {
+			stopLeading()
+		}
+		if err := l.Release(context.Background()); err != nil {
+			log.Warnw("Failed to release lease", "key", l.key, "err", err)
+		}
+	}()
+
+	for {
+		if _, err := l.TryAcquire(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warnw("Failed to acquire or renew lease", "key", l.key, "err", err)
+		}
+		if l.IsLeader() {
+			if stopLeading == nil {
+				log.Infow("Acquired lease", "key", l.key, "holder", l.id)
+				stopLeading = startLeading(ctx, lead)
+			}
+		} else if stopLeading != nil {
+			log.Infow("Lost lease", "key", l.key, "holder", l.id)
+			stopLeading()
+			stopLeading = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// startLeading calls lead in a new goroutine and returns a function that
+// cancels it and waits for it to return.
+func startLeading(ctx context.Context, lead func(ctx context.Context)) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (l *LeaderElection) read(ctx context.Context) ([]byte, error) {
+	r, err := l.store.Get(ctx, l.key)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading lease record: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,244 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/stretchr/testify/require"
+)
+
+// casDatastore is a datastore that implements conditional replacement itself.
+type casDatastore struct {
+	datastore.Datastore
+	mutex    sync.Mutex
+	replaces int
+}
+
+func (d *casDatastore) Replace(ctx context.Context, key datastore.Key, old, new []byte) (bool, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.replaces++
+	cur, err := d.Get(ctx, key)
+	if errors.Is(err, datastore.ErrNotFound) {
+		cur = nil
+	} else if err != nil {
+		return false, err
+	}
+	if (old == nil) != (cur == nil) || !bytes.Equal(old, cur) {
+		return false, nil
+	}
+	return true, d.Put(ctx, key, new)
+}
+
+func TestReplace(t *testing.T) {
+	ctx := context.Background()
+
+	// increment reads a counter and replaces it with the next value, retrying
+	// until the replacement succeeds.
+	increment := func(t *testing.T, s store.Store) {
+		for {
+			var old io.Reader
+			n := 0
+			r, err := s.Get(ctx, "counter")
+			if err == nil {
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				r.Close()
+				n, err = strconv.Atoi(string(data))
+				require.NoError(t, err)
+				old = bytes.NewReader(data)
+			} else {
+				require.True(t, store.IsNotFound(err))
+			}
+			next := []byte(strconv.Itoa(n + 1))
+			err = s.Replace(ctx, "counter", old, uint64(len(next)), bytes.NewReader(next))
+			if errors.Is(err, store.ErrPreconditionFailed) {
+				continue
+			}
+			require.NoError(t, err)
+			return
+		}
+	}
+
+	requireCount := func(t *testing.T, s store.Store, want int) {
+		r, err := s.Get(ctx, "counter")
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, strconv.Itoa(want), string(data))
+	}
+
+	t.Run("directory stores sharing a directory", func(t *testing.T) {
+		dir := t.TempDir()
+		var wg sync.WaitGroup
+		for range 8 {
+			// separate stores do not share the in-process lock
+			s := store.StoreFromDirectory(dir)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 10 {
+					increment(t, s)
+				}
+			}()
+		}
+		wg.Wait()
+		requireCount(t, store.StoreFromDirectory(dir), 80)
+	})
+
+	t.Run("replaceable datastore", func(t *testing.T) {
+		ds := &casDatastore{Datastore: dssync.MutexWrap(datastore.NewMapDatastore())}
+		s := store.StoreFromDatastore(ds)
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 10 {
+					increment(t, s)
+				}
+			}()
+		}
+		wg.Wait()
+		requireCount(t, s, 40)
+		require.GreaterOrEqual(t, ds.replaces, 40)
+	})
+}
+
+func TestLeaderElection(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("acquire, renew and release", func(t *testing.T) {
+		s := store.StoreFromDirectory(t.TempDir())
+		a, err := store.NewLeaderElection(s, "a", store.WithLeaseTTL(time.Minute))
+		require.NoError(t, err)
+		b, err := store.NewLeaderElection(s, "b", store.WithLeaseTTL(time.Minute))
+		require.NoError(t, err)
+
+		ok, err := a.TryAcquire(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.True(t, a.IsLeader())
+
+		ok, err = b.TryAcquire(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+		require.False(t, b.IsLeader())
+
+		ok, err = a.TryAcquire(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, a.Release(ctx))
+		require.False(t, a.IsLeader())
+
+		ok, err = b.TryAcquire(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("expired lease", func(t *testing.T) {
+		ds := &casDatastore{Datastore: dssync.MutexWrap(datastore.NewMapDatastore())}
+		s := store.StoreFromDatastore(namespace.Wrap(ds, datastore.NewKey("leases")))
+		a, err := store.NewLeaderElection(s, "a", store.WithLeaseTTL(30*time.Millisecond), store.WithLeaseKey("custom"))
+		require.NoError(t, err)
+		b, err := store.NewLeaderElection(s, "b", store.WithLeaseTTL(30*time.Millisecond), store.WithLeaseKey("custom"))
+		require.NoError(t, err)
+
+		ok, err := a.TryAcquire(ctx)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Positive(t, ds.replaces)
+
+		require.Eventually(t, func() bool {
+			ok, err := b.TryAcquire(ctx)
+			require.NoError(t, err)
+			return ok
+		}, time.Second, 5*time.Millisecond)
+		require.False(t, a.IsLeader())
+
+		ok, err = a.TryAcquire(ctx)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("store without atomic replace", func(t *testing.T) {
+		s := store.StoreFromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		_, err := store.NewLeaderElection(s, "a")
+		require.ErrorIs(t, err, store.ErrReplaceNotAtomic)
+	})
+
+	t.Run("run hands over leadership", func(t *testing.T) {
+		s := store.StoreFromDirectory(t.TempDir())
+		ttl := 90 * time.Millisecond
+
+		var mutex sync.Mutex
+		leading := 0
+		maxLeading := 0
+		led := map[string]int{}
+		lead := func(id string) func(ctx context.Context) {
+			return func(ctx context.Context) {
+				mutex.Lock()
+				leading++
+				maxLeading = max(maxLeading, leading)
+				led[id]++
+				mutex.Unlock()
+				<-ctx.Done()
+				mutex.Lock()
+				leading--
+				mutex.Unlock()
+			}
+		}
+
+		aCtx, aCancel := context.WithCancel(ctx)
+		aDone := make(chan error)
+		aElection, err := store.NewLeaderElection(s, "a", store.WithLeaseTTL(ttl))
+		require.NoError(t, err)
+		go func() {
+			aDone <- aElection.Run(aCtx, lead("a"))
+		}()
+		require.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return led["a"] == 1
+		}, time.Second, 5*time.Millisecond)
+
+		bCtx, bCancel := context.WithCancel(ctx)
+		defer bCancel()
+		bDone := make(chan error)
+		bElection, err := store.NewLeaderElection(s, "b", store.WithLeaseTTL(ttl))
+		require.NoError(t, err)
+		go func() {
+			bDone <- bElection.Run(bCtx, lead("b"))
+		}()
+		time.Sleep(2 * ttl)
+
+		// stopping a releases the lease, so b takes over
+		aCancel()
+		require.ErrorIs(t, <-aDone, context.Canceled)
+		require.Eventually(t, func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return led["b"] == 1
+		}, time.Second, 5*time.Millisecond)
+
+		bCancel()
+		require.ErrorIs(t, <-bDone, context.Canceled)
+		mutex.Lock()
+		defer mutex.Unlock()
+		require.Equal(t, 1, maxLeading)
+		require.Equal(t, 1, led["a"])
+		require.Zero(t, leading)
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package store
+
+// lockFileAtomic is false, since replacing a value in a directory store is not
+// atomic across processes without flock.
+const lockFileAtomic = false
+
+// lockFile is a no-op on platforms without flock, where replacing a value in
+// a directory store is only atomic within a single process.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,24 @@
+package store
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var meter = otel.Meter("github.com/storacha/go-libstoracha/ipnipublisher/store")
+
+var duplicateEntriesCounter metric.Int64Counter
+
+func init() {
+	var err error
+	duplicateEntriesCounter, err = meter.Int64Counter(
+		"ipnipublisher.store.entries.duplicates",
+		metric.WithDescription("Number of duplicate multihashes dropped when writing advertisement entries"),
+		metric.WithUnit("{multihash}"),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to create duplicate entries counter: %w", err))
+	}
+}
@@ -7,6 +7,10 @@ type Option func(cfg *options)
 
 type options struct {
 	metadataContext metadata.MetadataContext
+	entryChunkSize  int
+	dedupe          bool
+	dedupeLimit     int
+	digestSets      ProviderContextTable
 }
 
 // WithMetadataContext configues the IPNI metadata context, allowing custom
@@ -16,3 +20,33 @@ func WithMetadataContext(context metadata.MetadataContext) Option {
 		o.metadataContext = context
 	}
 }
+
+// WithEntryChunkSize configures the maximum number of multihashes written to
+// each advertisement entry chunk. If not configured, or not a positive number,
+// [MaxEntryChunkSize] is used.
+func WithEntryChunkSize(size int) Option {
+	return func(o *options) {
+		o.entryChunkSize = size
+	}
+}
+
+// WithEntryDeduplication configures the store to drop repeated multihashes
+// when writing advertisement entries, so that the same set of multihashes
+// always results in the same, minimal entry chain. The limit bounds the number
+// of distinct multihashes tracked per call to PutEntries (see
+// [EntryDeduplicator]). A limit of zero or less means no limit.
+func WithEntryDeduplication(limit int) Option {
+	return func(o *options) {
+		o.dedupe = true
+		o.dedupeLimit = limit
+	}
+}
+
+// WithDigestSetTable configures the table used to record a hash of the set of
+// multihashes advertised for each provider and context ID. If not configured,
+// digest set hashes are not recorded.
+func WithDigestSetTable(table ProviderContextTable) Option {
+	return func(o *options) {
+		o.digestSets = table
+	}
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"iter"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	chunkLinkTable = "chunkLink"
+	metadataTable  = "metadata"
+	digestSetTable = "digestSet"
+)
+
+// Overlay is a copy-on-write view of a publisher store. Reads fall through to
+// the base store for anything not written in the overlay, while writes and
+// deletes are kept in memory and never reach the base store. It allows
+// changes such as generating advertisements to be tried out without touching
+// the real advertisement chain.
+type Overlay struct {
+	base  PublisherStore
+	upper *AdStore
+
+	mutex sync.RWMutex
+	// deleted are the provider/context ID table entries deleted in the overlay,
+	// keyed by table and provider/context ID key.
+	deleted map[string]struct{}
+	// headSet is true once the head has been replaced in the overlay.
+	headSet bool
+}
+
+var _ PublisherStore = (*Overlay)(nil)
+var _ DigestSetStore = (*Overlay)(nil)
+
+// NewOverlay creates a copy-on-write overlay of the base store. The options
+// configure the in-memory store writes go to, and should match those of the
+// base store so that e.g. entry chains are chunked in the same way.
+func NewOverlay(base PublisherStore, opts ...Option) *Overlay {
+	return &Overlay{
+		base:    base,
+		upper:   FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()), opts...).(*AdStore),
+		deleted: map[string]struct{}{},
+	}
+}
+
+func (o *Overlay) PutAdvert(ctx context.Context, ad schema.Advertisement) (ipld.Link, error) {
+	return o.upper.PutAdvert(ctx, ad)
+}
+
+func (o *Overlay) Advert(ctx context.Context, id ipld.Link) (schema.Advertisement, error) {
+	ad, err := o.upper.Advert(ctx, id)
+	if err != nil && IsNotFound(err) {
+		return o.base.Advert(ctx, id)
+	}
+	return ad, err
+}
+
+func (o *Overlay) PutEntries(ctx context.Context, mhs iter.Seq[multihash.Multihash]) (ipld.Link, error) {
+	return o.upper.PutEntries(ctx, mhs)
+}
+
+// Entries reads the entry chain from the overlay when its root was written to
+// the overlay, and from the base store otherwise.
+func (o *Overlay) Entries(ctx context.Context, root ipld.Link) iter.Seq2[multihash.Multihash, error] {
+	if root != nil && root != schema.NoEntries {
+		r, err := o.upper.store.Get(ctx, root.String())
+		if err != nil && IsNotFound(err) {
+			return o.base.Entries(ctx, root)
+		}
+		if err == nil {
+			r.Close()
+		}
+	}
+	return o.upper.Entries(ctx, root)
+}
+
+func (o *Overlay) Head(ctx context.Context) (*head.SignedHead, error) {
+	o.mutex.RLock()
+	headSet := o.headSet
+	o.mutex.RUnlock()
+	if headSet {
+		return o.upper.Head(ctx)
+	}
+	return o.base.Head(ctx)
+}
+
+// ReplaceHead replaces the head in the overlay. The old head must match the
+// head of the overlay, which is the head of the base store until it has been
+// replaced in the overlay.
+func (o *Overlay) ReplaceHead(ctx context.Context, oldHead *head.SignedHead, newHead *head.SignedHead) (ipld.Link, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.headSet {
+		return o.upper.ReplaceHead(ctx, oldHead, newHead)
+	}
+	cur, err := o.base.Head(ctx)
+	if err != nil {
+		if !IsNotFound(err) {
+			return nil, err
+		}
+		cur = nil
+	}
+	if (cur == nil) != (oldHead == nil) || (cur != nil && !sameLink(cur.Head, oldHead.Head)) {
+		return nil, ErrPreconditionFailed
+	}
+	lnk, err := o.upper.ReplaceHead(ctx, nil, newHead)
+	if err != nil {
+		return nil, err
+	}
+	o.headSet = true
+	return lnk, nil
+}
+
+func (o *Overlay) ChunkLinkForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) (ipld.Link, error) {
+	lnk, err := o.upper.ChunkLinkForProviderAndContextID(ctx, p, contextID)
+	if err != nil && IsNotFound(err) && !o.isDeleted(chunkLinkTable, p, contextID) {
+		return o.base.ChunkLinkForProviderAndContextID(ctx, p, contextID)
+	}
+	return lnk, err
+}
+
+func (o *Overlay) PutChunkLinkForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte, chunkLink ipld.Link) error {
+	o.setDeleted(chunkLinkTable, p, contextID, false)
+	return o.upper.PutChunkLinkForProviderAndContextID(ctx, p, contextID, chunkLink)
+}
+
+func (o *Overlay) DeleteChunkLinkForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) error {
+	o.setDeleted(chunkLinkTable, p, contextID, true)
+	return ignoreNotFound(o.upper.DeleteChunkLinkForProviderAndContextID(ctx, p, contextID))
+}
+
+func (o *Overlay) MetadataForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) (metadata.Metadata, error) {
+	md, err := o.upper.MetadataForProviderAndContextID(ctx, p, contextID)
+	if err != nil && IsNotFound(err) && !o.isDeleted(metadataTable, p, contextID) {
+		return o.base.MetadataForProviderAndContextID(ctx, p, contextID)
+	}
+	return md, err
+}
+
+func (o *Overlay) PutMetadataForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte, md metadata.Metadata) error {
+	o.setDeleted(metadataTable, p, contextID, false)
+	return o.upper.PutMetadataForProviderAndContextID(ctx, p, contextID, md)
+}
+
+func (o *Overlay) DeleteMetadataForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) error {
+	o.setDeleted(metadataTable, p, contextID, true)
+	return ignoreNotFound(o.upper.DeleteMetadataForProviderAndContextID(ctx, p, contextID))
+}
+
+func (o *Overlay) DigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) (multihash.Multihash, error) {
+	hash, err := o.upper.DigestSetHashForProviderAndContextID(ctx, p, contextID)
+	if err != nil && IsNotFound(err) && !o.isDeleted(digestSetTable, p, contextID) {
+		// hashes are only recorded by base stores that support them
+		if base, ok := o.base.(DigestSetStore); ok {
+			return base.DigestSetHashForProviderAndContextID(ctx, p, contextID)
+		}
+	}
+	return hash, err
+}
+
+func (o *Overlay) PutDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte, hash multihash.Multihash) error {
+	o.setDeleted(digestSetTable, p, contextID, false)
+	return o.upper.PutDigestSetHashForProviderAndContextID(ctx, p, contextID, hash)
+}
+
+func (o *Overlay) DeleteDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) error {
+	o.setDeleted(digestSetTable, p, contextID, true)
+	return ignoreNotFound(o.upper.DeleteDigestSetHashForProviderAndContextID(ctx, p, contextID))
+}
+
+func (o *Overlay) isDeleted(table string, p peer.ID, contextID []byte) bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	_, ok := o.deleted[table+providerContextKey(p, contextID).String()]
+	return ok
+}
+
+func (o *Overlay) setDeleted(table string, p peer.ID, contextID []byte, deleted bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	key := table + providerContextKey(p, contextID).String()
+	if deleted {
+		o.deleted[key] = struct{}{}
+	} else {
+		delete(o.deleted, key)
+	}
+}
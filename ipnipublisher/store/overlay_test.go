@@ -0,0 +1,70 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlay(t *testing.T) {
+	ctx := context.Background()
+	base := store.FromDatastore(datastore.NewMapDatastore())
+	provider := testutil.RandomPeer(t)
+	contextID := []byte("context")
+
+	digests := testutil.RandomMultihashes(t, 3)
+	entries, err := base.PutEntries(ctx, slices.Values(digests))
+	require.NoError(t, err)
+	require.NoError(t, base.PutChunkLinkForProviderAndContextID(ctx, provider, contextID, entries))
+	md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+	require.NoError(t, base.PutMetadataForProviderAndContextID(ctx, provider, contextID, md))
+
+	overlay := store.NewOverlay(base)
+
+	t.Run("reads fall through", func(t *testing.T) {
+		lnk, err := overlay.ChunkLinkForProviderAndContextID(ctx, provider, contextID)
+		require.NoError(t, err)
+		require.Equal(t, entries, lnk)
+		var got [][]byte
+		for mh, err := range overlay.Entries(ctx, lnk) {
+			require.NoError(t, err)
+			got = append(got, mh)
+		}
+		require.Len(t, got, 3)
+		_, err = overlay.Head(ctx)
+		require.True(t, store.IsNotFound(err))
+	})
+
+	t.Run("deletes are not written through", func(t *testing.T) {
+		require.NoError(t, overlay.DeleteChunkLinkForProviderAndContextID(ctx, provider, contextID))
+		require.NoError(t, overlay.DeleteMetadataForProviderAndContextID(ctx, provider, contextID))
+
+		_, err := overlay.ChunkLinkForProviderAndContextID(ctx, provider, contextID)
+		require.True(t, store.IsNotFound(err))
+		_, err = overlay.MetadataForProviderAndContextID(ctx, provider, contextID)
+		require.True(t, store.IsNotFound(err))
+
+		_, err = base.ChunkLinkForProviderAndContextID(ctx, provider, contextID)
+		require.NoError(t, err)
+		_, err = base.MetadataForProviderAndContextID(ctx, provider, contextID)
+		require.NoError(t, err)
+	})
+
+	t.Run("writes are not written through", func(t *testing.T) {
+		other := testutil.RandomCID(t)
+		require.NoError(t, overlay.PutChunkLinkForProviderAndContextID(ctx, provider, contextID, other))
+		lnk, err := overlay.ChunkLinkForProviderAndContextID(ctx, provider, contextID)
+		require.NoError(t, err)
+		require.Equal(t, other, lnk)
+
+		lnk, err = base.ChunkLinkForProviderAndContextID(ctx, provider, contextID)
+		require.NoError(t, err)
+		require.Equal(t, entries, lnk)
+	})
+}
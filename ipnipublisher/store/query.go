@@ -0,0 +1,211 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multicodec"
+	smd "github.com/storacha/go-libstoracha/metadata"
+)
+
+// DefaultQueryLimit is the number of records returned per page when a query
+// does not set a limit.
+const DefaultQueryLimit = 100
+
+// ErrNotListable is returned when querying a store whose metadata table does
+// not implement [ListableProviderContextTable].
+var ErrNotListable = errors.New("provider/context ID table does not support listing")
+
+// ProviderContextEntry is an entry of a provider/context ID table.
+type ProviderContextEntry struct {
+	Provider  peer.ID
+	ContextID []byte
+	Data      []byte
+}
+
+// ListableProviderContextTable is a provider/context ID table whose entries
+// can be enumerated.
+type ListableProviderContextTable interface {
+	ProviderContextTable
+	// List returns up to limit entries of the provider, or of all providers if
+	// the provider is empty, in a stable order. The cursor is empty for the
+	// first page, or the cursor returned with the previous page. The returned
+	// cursor is empty when there are no more entries.
+	List(ctx context.Context, p peer.ID, cursor string, limit int) ([]ProviderContextEntry, string, error)
+}
+
+var _ ListableProviderContextTable = (*dsProviderContextTable)(nil)
+
+func (d *dsProviderContextTable) List(ctx context.Context, p peer.ID, cursor string, limit int) ([]ProviderContextEntry, string, error) {
+	q := query.Query{Orders: []query.Order{query.OrderByKey{}}}
+	if p != "" {
+		q.Prefix = datastore.NewKey(p.String()).String()
+	}
+	if cursor != "" {
+		q.Filters = []query.Filter{query.FilterKeyCompare{Op: query.GreaterThan, Key: cursor}}
+	}
+	if limit > 0 {
+		// read one more to know whether there is a next page
+		q.Limit = limit + 1
+	}
+	results, err := d.ds.Query(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	rs, err := results.Rest()
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if limit > 0 && len(rs) > limit {
+		rs = rs[:limit]
+		next = rs[limit-1].Key
+	}
+	entries := make([]ProviderContextEntry, 0, len(rs))
+	for _, r := range rs {
+		provider, contextID, err := parseProviderContextKey(r.Key)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, ProviderContextEntry{Provider: provider, ContextID: contextID, Data: r.Value})
+	}
+	return entries, next, nil
+}
+
+// parseProviderContextKey is the inverse of providerContextKey.
+func parseProviderContextKey(key string) (peer.ID, []byte, error) {
+	provider, contextKey, ok := strings.Cut(strings.TrimPrefix(key, "/"), "/")
+	if !ok {
+		return "", nil, fmt.Errorf("invalid provider/context ID key %q", key)
+	}
+	p, err := peer.Decode(provider)
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding provider of key %q: %w", key, err)
+	}
+	_, contextID, err := multibase.Decode(contextKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding context ID of key %q: %w", key, err)
+	}
+	return p, contextID, nil
+}
+
+// ContextIDQuery selects published context IDs by their metadata.
+type ContextIDQuery struct {
+	// Provider restricts the query to a provider. If empty, context IDs of all
+	// providers are returned.
+	Provider peer.ID
+	// Protocols restricts the query to context IDs whose metadata includes any
+	// of the protocols, e.g. [smd.LocationCommitmentID].
+	Protocols []multicodec.Code
+	// Cursor is the cursor returned with the previous page, or empty for the
+	// first page.
+	Cursor string
+	// Limit is the maximum number of records returned. If not positive,
+	// [DefaultQueryLimit] is used.
+	Limit int
+}
+
+// ContextIDRecord is a published context ID and its metadata.
+type ContextIDRecord struct {
+	Provider  peer.ID
+	ContextID []byte
+	Metadata  metadata.Metadata
+}
+
+// ContextIDPage is a page of query results.
+type ContextIDPage struct {
+	Records []ContextIDRecord
+	// Cursor fetches the next page when set in the query. It is empty when
+	// there are no more records.
+	Cursor string
+	// Skipped is the number of records left out of the page because their
+	// metadata could not be decoded.
+	Skipped int
+}
+
+// QueryableStore is a store whose published context IDs can be queried.
+type QueryableStore interface {
+	// QueryContextIDs returns a page of the context IDs currently advertised,
+	// i.e. that have metadata in the store, matching the query. Records whose
+	// metadata cannot be decoded are skipped rather than failing the query.
+	QueryContextIDs(ctx context.Context, q ContextIDQuery) (ContextIDPage, error)
+	// ExpiredContextIDs returns the context IDs of the provider, or of all
+	// providers if empty, whose metadata expires before the passed time. See
+	// [smd.ExpiresAt] for how the expiration of metadata is determined.
+	ExpiredContextIDs(ctx context.Context, p peer.ID, before time.Time) iter.Seq2[ContextIDRecord, error]
+}
+
+var _ QueryableStore = (*AdStore)(nil)
+
+// QueryContextIDs implements [QueryableStore]. It fails with [ErrNotListable]
+// if the metadata table does not support listing.
+func (s *AdStore) QueryContextIDs(ctx context.Context, q ContextIDQuery) (ContextIDPage, error) {
+	table, ok := s.metadata.(ListableProviderContextTable)
+	if !ok {
+		return ContextIDPage{}, ErrNotListable
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+
+	page := ContextIDPage{Cursor: q.Cursor}
+	for {
+		entries, next, err := table.List(ctx, q.Provider, page.Cursor, limit-len(page.Records))
+		if err != nil {
+			return ContextIDPage{}, fmt.Errorf("listing metadata: %w", err)
+		}
+		for _, e := range entries {
+			md := s.metadataContext.New()
+			if err := md.UnmarshalBinary(e.Data); err != nil {
+				log.Warnw("Skipping context ID with undecodable metadata", "provider", e.Provider, "contextID", e.ContextID, "err", err)
+				page.Skipped++
+				continue
+			}
+			if len(q.Protocols) > 0 && !slices.ContainsFunc(q.Protocols, func(c multicodec.Code) bool { return md.Get(c) != nil }) {
+				continue
+			}
+			page.Records = append(page.Records, ContextIDRecord{Provider: e.Provider, ContextID: e.ContextID, Metadata: md})
+		}
+		page.Cursor = next
+		if next == "" || len(page.Records) == limit {
+			return page, nil
+		}
+	}
+}
+
+// ExpiredContextIDs implements [QueryableStore].
+func (s *AdStore) ExpiredContextIDs(ctx context.Context, p peer.ID, before time.Time) iter.Seq2[ContextIDRecord, error] {
+	return func(yield func(ContextIDRecord, error) bool) {
+		q := ContextIDQuery{Provider: p}
+		for {
+			page, err := s.QueryContextIDs(ctx, q)
+			if err != nil {
+				yield(ContextIDRecord{}, err)
+				return
+			}
+			for _, r := range page.Records {
+				if exp, ok := smd.ExpiresAt(r.Metadata); ok && exp.Before(before) {
+					if !yield(r, nil) {
+						return
+					}
+				}
+			}
+			if page.Cursor == "" {
+				return
+			}
+			q.Cursor = page.Cursor
+		}
+	}
+}
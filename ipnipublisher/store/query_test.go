@@ -0,0 +1,123 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	smd "github.com/storacha/go-libstoracha/metadata"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryContextIDs(t *testing.T) {
+	ctx := context.Background()
+	st := store.FromDatastore(datastore.NewMapDatastore(), store.WithMetadataContext(smd.MetadataContext))
+	qs := st.(store.QueryableStore)
+
+	now := time.Now().Truncate(time.Second)
+	claim := testutil.RandomCID(t).(cidlink.Link).Cid
+	alice, bob := testutil.RandomPeer(t), testutil.RandomPeer(t)
+
+	put := func(p peer.ID, contextID string, md metadata.Metadata) {
+		require.NoError(t, st.PutMetadataForProviderAndContextID(ctx, p, []byte(contextID), md))
+	}
+	for i := range 5 {
+		put(alice, "location"+string(rune('a'+i)), smd.MetadataContext.New(&smd.LocationCommitmentMetadata{
+			Claim:      claim,
+			Expiration: now.Add(time.Duration(i-2) * time.Hour).Unix(),
+		}))
+	}
+	put(alice, "index", smd.MetadataContext.New(&smd.IndexClaimMetadata{Index: claim, Claim: claim}))
+	put(bob, "equals", smd.MetadataContext.New(&smd.EqualsClaimMetadata{Equals: claim, Claim: claim, Expiration: now.Add(-time.Hour).Unix()}))
+
+	collect := func(t *testing.T, q store.ContextIDQuery) ([]string, int) {
+		var ids []string
+		pages := 0
+		for {
+			page, err := qs.QueryContextIDs(ctx, q)
+			require.NoError(t, err)
+			pages++
+			for _, r := range page.Records {
+				ids = append(ids, string(r.ContextID))
+			}
+			if page.Cursor == "" {
+				return ids, pages
+			}
+			q.Cursor = page.Cursor
+		}
+	}
+
+	t.Run("paginates by provider", func(t *testing.T) {
+		ids, pages := collect(t, store.ContextIDQuery{Provider: alice, Limit: 2})
+		require.ElementsMatch(t, []string{"index", "locationa", "locationb", "locationc", "locationd", "locatione"}, ids)
+		require.Equal(t, 3, pages)
+
+		ids, _ = collect(t, store.ContextIDQuery{})
+		require.Len(t, ids, 7)
+	})
+
+	t.Run("filters by protocol", func(t *testing.T) {
+		ids, _ := collect(t, store.ContextIDQuery{Protocols: []multicodec.Code{smd.IndexClaimID, smd.EqualsClaimID}, Limit: 1})
+		require.ElementsMatch(t, []string{"index", "equals"}, ids)
+
+		ids, _ = collect(t, store.ContextIDQuery{Provider: bob, Protocols: []multicodec.Code{smd.LocationCommitmentID}})
+		require.Empty(t, ids)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var ids []string
+		for r, err := range qs.ExpiredContextIDs(ctx, "", now) {
+			require.NoError(t, err)
+			ids = append(ids, string(r.ContextID))
+		}
+		require.ElementsMatch(t, []string{"locationa", "locationb", "equals"}, ids)
+
+		ids = nil
+		for r, err := range qs.ExpiredContextIDs(ctx, alice, now.Add(90*time.Minute)) {
+			require.NoError(t, err)
+			ids = append(ids, string(r.ContextID))
+		}
+		require.ElementsMatch(t, []string{"locationa", "locationb", "locationc", "locationd"}, ids)
+	})
+
+	t.Run("undecodable metadata", func(t *testing.T) {
+		tbl := store.NewDatastoreProviderContextTable(datastore.NewMapDatastore())
+		st := store.NewPublisherStore(store.StoreFromDatastore(datastore.NewMapDatastore()), tbl, tbl, store.WithMetadataContext(smd.MetadataContext))
+		for i := range 3 {
+			require.NoError(t, st.PutMetadataForProviderAndContextID(ctx, alice, []byte{byte(i)}, smd.MetadataContext.New(&smd.IndexClaimMetadata{Index: claim, Claim: claim})))
+		}
+		require.NoError(t, tbl.Put(ctx, alice, []byte{1, 0}, []byte{0xff, 0xff}))
+
+		var ids [][]byte
+		skipped := 0
+		q := store.ContextIDQuery{Limit: 2}
+		for {
+			page, err := st.QueryContextIDs(ctx, q)
+			require.NoError(t, err)
+			skipped += page.Skipped
+			for _, r := range page.Records {
+				ids = append(ids, r.ContextID)
+			}
+			if page.Cursor == "" {
+				break
+			}
+			q.Cursor = page.Cursor
+		}
+		require.ElementsMatch(t, [][]byte{{0}, {1}, {2}}, ids)
+		require.Equal(t, 1, skipped)
+	})
+
+	t.Run("not listable", func(t *testing.T) {
+		tbl := store.NewDatastoreProviderContextTable(datastore.NewMapDatastore())
+		unlistable := store.NewPublisherStore(store.StoreFromDatastore(datastore.NewMapDatastore()), tbl, struct{ store.ProviderContextTable }{tbl})
+		_, err := unlistable.QueryContextIDs(ctx, store.ContextIDQuery{})
+		require.ErrorIs(t, err, store.ErrNotListable)
+	})
+}
@@ -9,11 +9,14 @@ import (
 	"iter"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/keytransform"
 	"github.com/ipfs/go-datastore/namespace"
+	dssync "github.com/ipfs/go-datastore/sync"
 	logging "github.com/ipfs/go-log/v2"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
@@ -25,6 +28,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multibase"
 	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
 	"github.com/storacha/go-ucanto/core/ipld/block"
 	"github.com/storacha/go-ucanto/core/ipld/codec/json"
 	"github.com/storacha/go-ucanto/core/ipld/hash/sha256"
@@ -54,7 +58,9 @@ var ErrPreconditionFailed = errors.New("precondition failed")
 const (
 	keyToMetadataMapPrefix  = "map/keyMD/"
 	keyToChunkLinkMapPrefix = "map/keyChunkLink/"
+	keyToDigestSetMapPrefix = "map/keyDigestSet/"
 	headKey                 = "head"
+	lockFileSuffix          = ".lock"
 )
 
 // MaxEntryChunkSize is the maximum number of multihashes each advertisement
@@ -140,6 +146,17 @@ type MetadataStore interface {
 	DeleteMetadataForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) error
 }
 
+// DigestSetStore records a hash of the set of multihashes that were
+// advertised for a provider and context ID, allowing changes to the set to be
+// detected without walking the entry chain. It is optional: publisher stores
+// that implement it are used to record hashes, otherwise hashes are calculated
+// from the entry chain when needed.
+type DigestSetStore interface {
+	DigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) (multihash.Multihash, error)
+	PutDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte, hash multihash.Multihash) error
+	DeleteDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) error
+}
+
 type PublisherStore interface {
 	AdvertStore
 	EntriesStore
@@ -157,10 +174,15 @@ type AdStore struct {
 	store           Store
 	chunkLinks      ProviderContextTable
 	metadata        ProviderContextTable
+	digestSets      ProviderContextTable
 	metadataContext metadata.MetadataContext
+	entryChunkSize  int
+	dedupe          bool
+	dedupeLimit     int
 }
 
 var _ FullStore = (*AdStore)(nil)
+var _ DigestSetStore = (*AdStore)(nil)
 
 func (s *AdStore) PutAdvert(ctx context.Context, ad schema.Advertisement) (ipld.Link, error) {
 	return PutAdvert(ctx, s.store, ad)
@@ -175,7 +197,21 @@ func (s *AdStore) Entries(ctx context.Context, root ipld.Link) iter.Seq2[multiha
 }
 
 func (s *AdStore) PutEntries(ctx context.Context, mhs iter.Seq[multihash.Multihash]) (ipld.Link, error) {
-	return PutEntries(ctx, s.store, mhs, MaxEntryChunkSize)
+	chunkSize := s.entryChunkSize
+	if chunkSize <= 0 {
+		chunkSize = MaxEntryChunkSize
+	}
+	if !s.dedupe {
+		return PutEntries(ctx, s.store, mhs, chunkSize)
+	}
+
+	dedup := NewEntryDeduplicator(s.dedupeLimit)
+	lnk, err := PutEntries(ctx, s.store, dedup.Filter(mhs), chunkSize)
+	if dropped := dedup.Dropped(); dropped > 0 {
+		log.Infow("Dropped duplicate multihashes from entries", "duplicateCount", dropped)
+		duplicateEntriesCounter.Add(ctx, int64(dropped))
+	}
+	return lnk, err
 }
 
 func (s *AdStore) Encode(ctx context.Context, id datamodel.Link, w io.Writer) error {
@@ -218,6 +254,27 @@ func (s *AdStore) DeleteMetadataForProviderAndContextID(ctx context.Context, p p
 	return s.metadata.Delete(ctx, p, contextID)
 }
 
+func (s *AdStore) DigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) (multihash.Multihash, error) {
+	if s.digestSets == nil {
+		return nil, NewErrNotFound(errors.New("no digest set table configured"))
+	}
+	return s.digestSets.Get(ctx, p, contextID)
+}
+
+func (s *AdStore) PutDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte, hash multihash.Multihash) error {
+	if s.digestSets == nil {
+		return nil
+	}
+	return s.digestSets.Put(ctx, p, contextID, hash)
+}
+
+func (s *AdStore) DeleteDigestSetHashForProviderAndContextID(ctx context.Context, p peer.ID, contextID []byte) error {
+	if s.digestSets == nil {
+		return nil
+	}
+	return s.digestSets.Delete(ctx, p, contextID)
+}
+
 func NewPublisherStore(store Store, chunkLinks, metadataTable ProviderContextTable, opts ...Option) *AdStore {
 	o := &options{}
 	for _, opt := range opts {
@@ -227,7 +284,16 @@ func NewPublisherStore(store Store, chunkLinks, metadataTable ProviderContextTab
 	if mctx == nil {
 		mctx = metadata.Default
 	}
-	return &AdStore{store, chunkLinks, metadataTable, mctx}
+	return &AdStore{
+		store:           store,
+		chunkLinks:      chunkLinks,
+		metadata:        metadataTable,
+		digestSets:      o.digestSets,
+		metadataContext: mctx,
+		entryChunkSize:  o.entryChunkSize,
+		dedupe:          o.dedupe,
+		dedupeLimit:     o.dedupeLimit,
+	}
 }
 
 func Advert(ctx context.Context, ds SimpleStore, id ipld.Link) (schema.Advertisement, error) {
@@ -280,6 +346,29 @@ func PutEntries(ctx context.Context, ds SimpleStore, entries iter.Seq[multihash.
 	return next, nil
 }
 
+// DigestSetHash calculates a SHA2-256 multihash over the distinct multihashes
+// in entries. The result does not depend on the order of the entries or on
+// repeated entries, so it identifies the set of multihashes advertised by an
+// entry chain.
+func DigestSetHash(entries iter.Seq2[multihash.Multihash, error]) (multihash.Multihash, error) {
+	var keys []string
+	for mh, err := range entries {
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, string(mh))
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.Write(varint.ToUvarint(uint64(len(k))))
+		buf.WriteString(k)
+	}
+	return multihash.Sum(buf.Bytes(), multihash.SHA2_256, -1)
+}
+
 func Entries(ctx context.Context, ds SimpleStore, root ipld.Link) iter.Seq2[multihash.Multihash, error] {
 	return func(yield func(multihash.Multihash, error) bool) {
 		cur := root
@@ -478,6 +567,60 @@ func (d *dsStoreAdapter) Put(ctx context.Context, key string, len uint64, r io.R
 	return d.ds.Put(ctx, datastore.NewKey(key), data)
 }
 
+// ReplaceableDatastore is a datastore that can atomically replace a value,
+// e.g. using conditional writes. When the datastore passed to
+// [FromDatastore] implements it, or wraps it with namespace, key transform or
+// mutex wrappers, replacing the head is atomic across processes sharing the
+// datastore. Otherwise it is only atomic within the process.
+type ReplaceableDatastore interface {
+	datastore.Datastore
+	// Replace writes new to key only when the current value equals old, or when
+	// old is nil and key does not exist. It returns false when the current
+	// value does not match.
+	Replace(ctx context.Context, key datastore.Key, old, new []byte) (bool, error)
+}
+
+// AtomicReplacer is implemented by stores that can tell whether
+// [Store.Replace] is atomic across processes sharing the store, or only
+// within the process.
+type AtomicReplacer interface {
+	AtomicReplace() bool
+}
+
+// ErrReplaceNotAtomic is returned when a store is required to replace values
+// atomically across processes but cannot.
+var ErrReplaceNotAtomic = errors.New("store does not support atomic replace across processes")
+
+// replaceableDatastore finds the [ReplaceableDatastore] wrapped by known
+// datastore wrappers, such as namespaces and mutexes, and the key transform
+// applied by the wrappers on the way to it.
+func replaceableDatastore(ds datastore.Datastore) (ReplaceableDatastore, func(datastore.Key) datastore.Key, bool) {
+	convert := func(k datastore.Key) datastore.Key { return k }
+	for {
+		if rds, ok := ds.(ReplaceableDatastore); ok {
+			return rds, convert, true
+		}
+		switch w := ds.(type) {
+		case *keytransform.Datastore:
+			prev := convert
+			convert = func(k datastore.Key) datastore.Key { return w.ConvertKey(prev(k)) }
+			ds = w.Children()[0]
+		case *dssync.MutexDatastore:
+			ds = w.Children()[0]
+		default:
+			return nil, nil, false
+		}
+	}
+}
+
+// AtomicReplace implements AtomicReplacer. Replace is atomic across processes
+// when the datastore, or the datastore wrapped by namespace, key transform or
+// mutex wrappers, implements [ReplaceableDatastore].
+func (d *dsStoreAdapter) AtomicReplace() bool {
+	_, _, ok := replaceableDatastore(d.ds)
+	return ok
+}
+
 func (d *dsStoreAdapter) Replace(ctx context.Context, key string, old io.Reader, newLen uint64, new io.Reader) error {
 	var oldBytes []byte
 	if old != nil {
@@ -487,6 +630,25 @@ func (d *dsStoreAdapter) Replace(ctx context.Context, key string, old io.Reader,
 		}
 		oldBytes = b
 	}
+
+	if rds, convert, ok := replaceableDatastore(d.ds); ok {
+		newBytes, err := io.ReadAll(new)
+		if err != nil {
+			return err
+		}
+		if old != nil && oldBytes == nil {
+			oldBytes = []byte{}
+		}
+		replaced, err := rds.Replace(ctx, convert(datastore.NewKey(key)), oldBytes, newBytes)
+		if err != nil {
+			return err
+		}
+		if !replaced {
+			return ErrPreconditionFailed
+		}
+		return nil
+	}
+
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
@@ -526,7 +688,14 @@ func (d *directoryStore) Get(ctx context.Context, key string) (io.ReadCloser, er
 	if err != nil {
 		return nil, err
 	}
-	return os.Open(path)
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, NewErrNotFound(err)
+		}
+		return nil, err
+	}
+	return f, nil
 }
 
 // Put implements Store.
@@ -537,15 +706,13 @@ func (d *directoryStore) Put(ctx context.Context, key string, len uint64, data i
 	}
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
-	file, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	_, err = io.Copy(file, data)
-	return err
+	return writeFileAtomic(path, data)
 }
 
+// Replace implements Store. The compare and swap is guarded by an exclusive
+// lock on a lock file next to the key, so it is atomic across processes
+// sharing the directory, and the new value is written to a temporary file and
+// renamed over the old one, so readers never see a partial write.
 func (d *directoryStore) Replace(ctx context.Context, key string, old io.Reader, newLen uint64, new io.Reader) error {
 	var oldBytes []byte
 	if old != nil {
@@ -563,6 +730,12 @@ func (d *directoryStore) Replace(ctx context.Context, key string, old io.Reader,
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
+	unlock, err := lockFile(path + lockFileSuffix)
+	if err != nil {
+		return fmt.Errorf("locking %s: %w", key, err)
+	}
+	defer unlock()
+
 	cur, err := os.ReadFile(path)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
@@ -577,13 +750,36 @@ func (d *directoryStore) Replace(ctx context.Context, key string, old io.Reader,
 		return ErrPreconditionFailed
 	}
 
-	file, err := os.Create(path)
+	return writeFileAtomic(path, new)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path and renames it to path.
+func writeFileAtomic(path string, data io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	_, err = io.Copy(file, new)
-	return err
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// AtomicReplace implements AtomicReplacer. Replace is guarded by a lock file,
+// so it is atomic across processes sharing the directory, except on platforms
+// without file locks, where it is only atomic within a process.
+func (d *directoryStore) AtomicReplace() bool {
+	return lockFileAtomic
 }
 
 var _ Store = (*directoryStore)(nil)
@@ -599,12 +795,25 @@ func SimpleStoreFromDatastore(ds datastore.Datastore) SimpleStore {
 	return &dsStoreAdapter{ds: ds}
 }
 
+// StoreFromDatastore creates a store backed by a datastore. Replace is atomic
+// across processes when the datastore implements [ReplaceableDatastore], or
+// wraps one with namespace, key transform or mutex wrappers.
+func StoreFromDatastore(ds datastore.Datastore) Store {
+	return &dsStoreAdapter{ds: ds}
+}
+
+// StoreFromDirectory creates a store that keeps each key in a file in the
+// passed directory.
+func StoreFromDirectory(storagePath string) Store {
+	return &directoryStore{directory: storagePath}
+}
+
 func FromDatastore(ds datastore.Datastore, opts ...Option) FullStore {
 	return NewPublisherStore(
 		&dsStoreAdapter{ds: ds},
 		&dsProviderContextTable{namespace.Wrap(ds, datastore.NewKey(keyToChunkLinkMapPrefix))},
 		&dsProviderContextTable{namespace.Wrap(ds, datastore.NewKey(keyToMetadataMapPrefix))},
-		opts...,
+		append([]Option{WithDigestSetTable(&dsProviderContextTable{namespace.Wrap(ds, datastore.NewKey(keyToDigestSetMapPrefix))})}, opts...)...,
 	)
 }
 
@@ -612,5 +821,6 @@ func FromLocalStore(storagePath string, ds datastore.Datastore, opts ...Option)
 	store := &directoryStore{directory: storagePath}
 	chunkLinksStore := &dsProviderContextTable{namespace.Wrap(ds, datastore.NewKey(keyToChunkLinkMapPrefix))}
 	mdStore := &dsProviderContextTable{namespace.Wrap(ds, datastore.NewKey(keyToMetadataMapPrefix))}
-	return NewPublisherStore(store, chunkLinksStore, mdStore, opts...)
+	digestSetStore := &dsProviderContextTable{namespace.Wrap(ds, datastore.NewKey(keyToDigestSetMapPrefix))}
+	return NewPublisherStore(store, chunkLinksStore, mdStore, append([]Option{WithDigestSetTable(digestSetStore)}, opts...)...)
 }
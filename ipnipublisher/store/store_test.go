@@ -5,9 +5,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"slices"
 	"testing"
 
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/node/bindnode"
@@ -15,8 +17,10 @@ import (
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/multiformats/go-varint"
 	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -121,3 +125,107 @@ func TestCustomMetadataContext(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, md, r)
 }
+
+func TestPutEntries(t *testing.T) {
+	ctx := context.Background()
+
+	collect := func(t *testing.T, s store.FullStore, root ipld.Link) []multihash.Multihash {
+		var mhs []multihash.Multihash
+		for mh, err := range s.Entries(ctx, root) {
+			require.NoError(t, err)
+			mhs = append(mhs, mh)
+		}
+		return mhs
+	}
+
+	countBlocks := func(t *testing.T, ds datastore.Datastore) int {
+		res, err := ds.Query(ctx, query.Query{KeysOnly: true})
+		require.NoError(t, err)
+		entries, err := res.Rest()
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	t.Run("custom chunk size", func(t *testing.T) {
+		ds := datastore.NewMapDatastore()
+		s := store.FromDatastore(ds, store.WithEntryChunkSize(3))
+
+		digests := testutil.RandomMultihashes(t, 10)
+		root, err := s.PutEntries(ctx, slices.Values(digests))
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, digests, collect(t, s, root))
+		require.Equal(t, 4, countBlocks(t, ds))
+	})
+
+	t.Run("keeps duplicates by default", func(t *testing.T) {
+		s := store.FromDatastore(datastore.NewMapDatastore())
+
+		digests := testutil.RandomMultihashes(t, 5)
+		root, err := s.PutEntries(ctx, slices.Values(append(digests, digests...)))
+		require.NoError(t, err)
+
+		require.Len(t, collect(t, s, root), 10)
+	})
+
+	t.Run("deduplication", func(t *testing.T) {
+		ds := datastore.NewMapDatastore()
+		s := store.FromDatastore(ds, store.WithEntryChunkSize(3), store.WithEntryDeduplication(0))
+
+		digests := testutil.RandomMultihashes(t, 5)
+		withDupes := append(slices.Clone(digests), digests[0], digests[3], digests[0])
+		root, err := s.PutEntries(ctx, slices.Values(withDupes))
+		require.NoError(t, err)
+
+		require.ElementsMatch(t, digests, collect(t, s, root))
+		require.Equal(t, 2, countBlocks(t, ds))
+
+		// same set of multihashes results in the same entry chain
+		other, err := s.PutEntries(ctx, slices.Values(digests))
+		require.NoError(t, err)
+		require.Equal(t, root, other)
+	})
+}
+
+func TestEntryDeduplicator(t *testing.T) {
+	digests := testutil.RandomMultihashes(t, 4)
+
+	t.Run("unbounded", func(t *testing.T) {
+		d := store.NewEntryDeduplicator(0)
+		in := []multihash.Multihash{digests[0], digests[1], digests[0], digests[2], digests[1], digests[3]}
+		out := slices.Collect(d.Filter(slices.Values(in)))
+		require.Equal(t, digests, out)
+		require.Equal(t, 2, d.Dropped())
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		d := store.NewEntryDeduplicator(1)
+		in := []multihash.Multihash{digests[0], digests[1], digests[0], digests[1]}
+		out := slices.Collect(d.Filter(slices.Values(in)))
+		// digests[1] is not tracked, so its repeat is passed through
+		require.Equal(t, []multihash.Multihash{digests[0], digests[1], digests[1]}, out)
+		require.Equal(t, 1, d.Dropped())
+	})
+}
+
+func TestDigestSetHash(t *testing.T) {
+	hash := func(t *testing.T, mhs []multihash.Multihash) multihash.Multihash {
+		h, err := store.DigestSetHash(func(yield func(multihash.Multihash, error) bool) {
+			for _, mh := range mhs {
+				if !yield(mh, nil) {
+					return
+				}
+			}
+		})
+		require.NoError(t, err)
+		return h
+	}
+
+	digests := testutil.RandomMultihashes(t, 5)
+	reversed := slices.Clone(digests)
+	slices.Reverse(reversed)
+
+	require.Equal(t, hash(t, digests), hash(t, reversed))
+	require.Equal(t, hash(t, digests), hash(t, append(slices.Clone(digests), digests[2])))
+	require.NotEqual(t, hash(t, digests), hash(t, digests[1:]))
+}
@@ -0,0 +1,363 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// FindingKind identifies the type of inconsistency found when verifying an
+// advertisement chain.
+type FindingKind string
+
+const (
+	// FindingHeadSignature indicates the signed head has an invalid signature or
+	// was signed by an unexpected peer.
+	FindingHeadSignature FindingKind = "head-signature"
+	// FindingAdvertMissing indicates an advertisement referenced by the head or
+	// by a PreviousID link could not be found in the store.
+	FindingAdvertMissing FindingKind = "advert-missing"
+	// FindingAdvertInvalid indicates an advertisement could not be read or
+	// decoded, or failed validation.
+	FindingAdvertInvalid FindingKind = "advert-invalid"
+	// FindingAdvertSignature indicates an advertisement has an invalid signature
+	// or was signed by an unexpected peer.
+	FindingAdvertSignature FindingKind = "advert-signature"
+	// FindingChainCycle indicates a PreviousID link points back to an
+	// advertisement already seen in the chain.
+	FindingChainCycle FindingKind = "chain-cycle"
+	// FindingEntriesInvalid indicates an entry chunk of an advertisement is
+	// missing or could not be decoded.
+	FindingEntriesInvalid FindingKind = "entries-invalid"
+	// FindingChunkLinkMissing indicates the chunk link table has no entry for a
+	// provider and context ID that is advertised by the chain.
+	FindingChunkLinkMissing FindingKind = "chunk-link-missing"
+	// FindingChunkLinkMismatch indicates the chunk link table does not match the
+	// entries of the latest advertisement for a provider and context ID.
+	FindingChunkLinkMismatch FindingKind = "chunk-link-mismatch"
+	// FindingChunkLinkStale indicates the chunk link table has an entry for a
+	// provider and context ID that was removed by the chain.
+	FindingChunkLinkStale FindingKind = "chunk-link-stale"
+	// FindingMetadataMissing indicates the metadata table has no entry for a
+	// provider and context ID that is advertised by the chain.
+	FindingMetadataMissing FindingKind = "metadata-missing"
+	// FindingMetadataMismatch indicates the metadata table does not match the
+	// metadata of the latest advertisement for a provider and context ID.
+	FindingMetadataMismatch FindingKind = "metadata-mismatch"
+	// FindingMetadataStale indicates the metadata table has an entry for a
+	// provider and context ID that was removed by the chain.
+	FindingMetadataStale FindingKind = "metadata-stale"
+	// FindingTableError indicates a provider/context ID table could not be read.
+	FindingTableError FindingKind = "table-error"
+)
+
+// Finding is an inconsistency found when verifying an advertisement chain.
+type Finding struct {
+	Kind FindingKind
+	// Advert is the advertisement the finding relates to. It is nil for
+	// findings about the head.
+	Advert ipld.Link
+	// Provider and ContextID identify the provider/context ID table entry the
+	// finding relates to, if any.
+	Provider  peer.ID
+	ContextID []byte
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (f Finding) String() string {
+	s := string(f.Kind)
+	if f.Advert != nil {
+		s += fmt.Sprintf(" advert=%s", f.Advert)
+	}
+	if f.Provider != "" {
+		s += fmt.Sprintf(" provider=%s contextID=%x", f.Provider, f.ContextID)
+	}
+	if f.Err != nil {
+		s += fmt.Sprintf(": %s", f.Err)
+	}
+	return s
+}
+
+// VerifyReport is the result of verifying an advertisement chain.
+type VerifyReport struct {
+	// Head is the advertisement the head points to, or nil if the store has no
+	// head.
+	Head ipld.Link
+	// Adverts is the number of advertisements walked.
+	Adverts int
+	// Entries is the number of multihashes read from entry chains. Entry chains
+	// shared by several advertisements are only read once.
+	Entries int
+	// Findings are the inconsistencies found, in the order they were found.
+	Findings []Finding
+}
+
+// OK returns true if no inconsistencies were found.
+func (r VerifyReport) OK() bool {
+	return len(r.Findings) == 0
+}
+
+// VerifyOption is an option configuring chain verification.
+type VerifyOption func(cfg *verifyConfig)
+
+type verifyConfig struct {
+	signer          peer.ID
+	providerSigners bool
+	providerSigned  bool
+	skipEntries     bool
+}
+
+// WithExpectedSigner requires the head and all advertisements to be signed by
+// the passed peer. If not configured, the head and advertisements may be signed
+// by any valid key, since publishers sign the advertisements of providers with
+// their own key, or with delegated provider keys.
+func WithExpectedSigner(signer peer.ID) VerifyOption {
+	return func(cfg *verifyConfig) {
+		cfg.signer = signer
+	}
+}
+
+// AllowProviderSigned additionally accepts advertisements signed by their own
+// provider when an expected signer is configured, as produced by publishers
+// that hold the keys of the providers they publish for.
+func AllowProviderSigned() VerifyOption {
+	return func(cfg *verifyConfig) {
+		cfg.providerSigners = true
+	}
+}
+
+// RequireProviderSigned requires advertisements to be signed by their own
+// provider, whether or not an expected signer is configured.
+func RequireProviderSigned() VerifyOption {
+	return func(cfg *verifyConfig) {
+		cfg.providerSigned = true
+	}
+}
+
+// WithoutEntries skips reading the entry chains of advertisements, which is
+// the most expensive part of verification for large chains.
+func WithoutEntries() VerifyOption {
+	return func(cfg *verifyConfig) {
+		cfg.skipEntries = true
+	}
+}
+
+// Verify walks the advertisement chain from the head back to the genesis
+// advertisement, checking that the head and each advertisement are correctly
+// signed, that every PreviousID link resolves, that all entry chunks are
+// present and decodable and that the chunk link and metadata tables agree with
+// the latest advertisement for each provider and context ID in the chain.
+//
+// Inconsistencies are returned as findings in the report. An error is only
+// returned when verification cannot proceed, e.g. the head cannot be read or
+// the context is canceled. A store without a head is reported as empty.
+//
+// Note: the tables cannot be enumerated, so entries in them for provider and
+// context IDs that do not appear in the chain are not detected.
+func Verify(ctx context.Context, s PublisherStore, opts ...VerifyOption) (VerifyReport, error) {
+	cfg := verifyConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	var report VerifyReport
+	hd, err := s.Head(ctx)
+	if err != nil {
+		if IsNotFound(err) {
+			return report, nil
+		}
+		return report, fmt.Errorf("reading head: %w", err)
+	}
+	report.Head = hd.Head
+
+	signer, err := hd.Validate()
+	if err != nil {
+		report.Findings = append(report.Findings, Finding{Kind: FindingHeadSignature, Err: err})
+	} else if cfg.signer != "" && signer != cfg.signer {
+		report.Findings = append(report.Findings, Finding{
+			Kind: FindingHeadSignature,
+			Err:  fmt.Errorf("signed by %s, expected %s", signer, cfg.signer),
+		})
+	}
+
+	v := verifier{
+		store:    s,
+		cfg:      cfg,
+		report:   &report,
+		adverts:  map[string]struct{}{},
+		entries:  map[string]struct{}{},
+		contexts: map[string]struct{}{},
+	}
+	for cur := hd.Head; cur != nil; {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if _, ok := v.adverts[cur.String()]; ok {
+			v.add(Finding{Kind: FindingChainCycle, Advert: cur})
+			break
+		}
+		v.adverts[cur.String()] = struct{}{}
+
+		ad, err := s.Advert(ctx, cur)
+		if err != nil {
+			if IsNotFound(err) {
+				v.add(Finding{Kind: FindingAdvertMissing, Advert: cur, Err: err})
+			} else {
+				v.add(Finding{Kind: FindingAdvertInvalid, Advert: cur, Err: err})
+			}
+			break
+		}
+		report.Adverts++
+
+		if err := v.advert(ctx, cur, ad); err != nil {
+			return report, err
+		}
+		cur = ad.PreviousID
+	}
+
+	log.Infow("Verified advertisement chain", "head", report.Head, "adverts", report.Adverts, "entries", report.Entries, "findings", len(report.Findings))
+	return report, nil
+}
+
+type verifier struct {
+	store  PublisherStore
+	cfg    verifyConfig
+	report *VerifyReport
+	// adverts are the advertisements seen so far, to detect cycles.
+	adverts map[string]struct{}
+	// entries are the entry chains read so far.
+	entries map[string]struct{}
+	// contexts are the provider and context IDs seen so far. Since the chain is
+	// walked from the head, the first advertisement seen for each is the latest.
+	contexts map[string]struct{}
+}
+
+func (v *verifier) add(f Finding) {
+	v.report.Findings = append(v.report.Findings, f)
+}
+
+func (v *verifier) advert(ctx context.Context, lnk ipld.Link, ad schema.Advertisement) error {
+	if err := ad.Validate(); err != nil {
+		v.add(Finding{Kind: FindingAdvertInvalid, Advert: lnk, Err: err})
+	}
+
+	provider, err := peer.Decode(ad.Provider)
+	if err != nil {
+		v.add(Finding{Kind: FindingAdvertInvalid, Advert: lnk, Err: fmt.Errorf("decoding provider: %w", err)})
+	}
+
+	signer, err := ad.VerifySignature()
+	if err != nil {
+		v.add(Finding{Kind: FindingAdvertSignature, Advert: lnk, Err: err})
+	} else if err := v.checkSigner(signer, provider); err != nil {
+		v.add(Finding{Kind: FindingAdvertSignature, Advert: lnk, Err: err})
+	}
+
+	if !v.cfg.skipEntries && !ad.IsRm {
+		v.readEntries(ctx, lnk, ad.Entries)
+	}
+
+	if provider == "" {
+		return nil
+	}
+	key := string(provider) + "/" + string(ad.ContextID)
+	if _, ok := v.contexts[key]; ok {
+		return nil
+	}
+	v.contexts[key] = struct{}{}
+	return v.tables(ctx, lnk, provider, ad)
+}
+
+// checkSigner returns an error if an advertisement of the provider must not be
+// signed by the signer.
+func (v *verifier) checkSigner(signer, provider peer.ID) error {
+	switch {
+	case v.cfg.providerSigned:
+		if signer != provider {
+			return fmt.Errorf("signed by %s, expected provider %s", signer, provider)
+		}
+	case v.cfg.signer != "":
+		if signer != v.cfg.signer && !(v.cfg.providerSigners && signer == provider) {
+			return fmt.Errorf("signed by %s, expected %s", signer, v.cfg.signer)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) readEntries(ctx context.Context, lnk ipld.Link, root ipld.Link) {
+	if root == nil || root == schema.NoEntries {
+		return
+	}
+	if _, ok := v.entries[root.String()]; ok {
+		return
+	}
+	v.entries[root.String()] = struct{}{}
+	for _, err := range v.store.Entries(ctx, root) {
+		if err != nil {
+			v.add(Finding{Kind: FindingEntriesInvalid, Advert: lnk, Err: err})
+			return
+		}
+		v.report.Entries++
+	}
+}
+
+// tables cross-checks the chunk link and metadata tables against the latest
+// advertisement for a provider and context ID.
+func (v *verifier) tables(ctx context.Context, lnk ipld.Link, provider peer.ID, ad schema.Advertisement) error {
+	finding := func(kind FindingKind, err error) {
+		v.add(Finding{Kind: kind, Advert: lnk, Provider: provider, ContextID: ad.ContextID, Err: err})
+	}
+
+	chunkLink, err := v.store.ChunkLinkForProviderAndContextID(ctx, provider, ad.ContextID)
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		return err
+	case err != nil && !IsNotFound(err):
+		finding(FindingTableError, fmt.Errorf("reading chunk link: %w", err))
+	case ad.IsRm && err == nil:
+		finding(FindingChunkLinkStale, nil)
+	case !ad.IsRm && err != nil:
+		finding(FindingChunkLinkMissing, nil)
+	case !ad.IsRm && !sameLink(chunkLink, ad.Entries):
+		finding(FindingChunkLinkMismatch, fmt.Errorf("table has %s, advert has %s", chunkLink, ad.Entries))
+	}
+
+	md, err := v.store.MetadataForProviderAndContextID(ctx, provider, ad.ContextID)
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		return err
+	case err != nil && !IsNotFound(err):
+		finding(FindingTableError, fmt.Errorf("reading metadata: %w", err))
+	case ad.IsRm && err == nil:
+		finding(FindingMetadataStale, nil)
+	case !ad.IsRm && err != nil:
+		finding(FindingMetadataMissing, nil)
+	case !ad.IsRm:
+		mdBytes, err := md.MarshalBinary()
+		if err != nil {
+			finding(FindingTableError, fmt.Errorf("encoding metadata: %w", err))
+		} else if !bytes.Equal(mdBytes, ad.Metadata) {
+			finding(FindingMetadataMismatch, nil)
+		}
+	}
+	return nil
+}
+
+func sameLink(a, b ipld.Link) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if ac, ok := a.(cidlink.Link); ok {
+		if bc, ok := b.(cidlink.Link); ok {
+			return ac.Cid.Equals(bc.Cid)
+		}
+	}
+	return a.String() == b.String()
+}
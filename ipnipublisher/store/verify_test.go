@@ -0,0 +1,191 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/storacha/go-libstoracha/ipnipublisher/publisher"
+	"github.com/storacha/go-libstoracha/ipnipublisher/store"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	pid, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+
+	type chain struct {
+		ds         datastore.Datastore
+		st         store.FullStore
+		ads        []ipld.Link
+		contextIDs []string
+	}
+
+	// newChain publishes n adverts, each for a distinct context ID.
+	newChain := func(t *testing.T, n int) chain {
+		ds := dssync.MutexWrap(datastore.NewMapDatastore())
+		st := store.FromDatastore(ds, store.WithEntryChunkSize(2))
+		p, err := publisher.New(priv, st)
+		require.NoError(t, err)
+
+		c := chain{ds: ds, st: st}
+		for range n {
+			contextID := testutil.RandomCID(t).String()
+			md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+			lnk, err := p.Publish(ctx, peer.AddrInfo{ID: pid}, contextID, slices.Values(testutil.RandomMultihashes(t, 5)), md)
+			require.NoError(t, err)
+			c.ads = append(c.ads, lnk)
+			c.contextIDs = append(c.contextIDs, contextID)
+		}
+		return c
+	}
+
+	kinds := func(r store.VerifyReport) []store.FindingKind {
+		var ks []store.FindingKind
+		for _, f := range r.Findings {
+			ks = append(ks, f.Kind)
+		}
+		return ks
+	}
+
+	t.Run("empty store", func(t *testing.T) {
+		report, err := store.Verify(ctx, store.FromDatastore(datastore.NewMapDatastore()))
+		require.NoError(t, err)
+		require.True(t, report.OK())
+		require.Nil(t, report.Head)
+		require.Zero(t, report.Adverts)
+	})
+
+	t.Run("intact chain", func(t *testing.T) {
+		c := newChain(t, 3)
+
+		report, err := store.Verify(ctx, c.st)
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+		require.Equal(t, c.ads[2], report.Head)
+		require.Equal(t, 3, report.Adverts)
+		require.Equal(t, 15, report.Entries)
+
+		report, err = store.Verify(ctx, c.st, store.WithExpectedSigner(pid), store.WithoutEntries())
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+		require.Zero(t, report.Entries)
+	})
+
+	t.Run("unexpected signer", func(t *testing.T) {
+		c := newChain(t, 2)
+
+		report, err := store.Verify(ctx, c.st, store.WithExpectedSigner(testutil.RandomPeer(t)))
+		require.NoError(t, err)
+		require.Equal(t, []store.FindingKind{
+			store.FindingHeadSignature,
+			store.FindingAdvertSignature,
+			store.FindingAdvertSignature,
+		}, kinds(report))
+	})
+
+	t.Run("distinct provider", func(t *testing.T) {
+		st := store.FromDatastore(dssync.MutexWrap(datastore.NewMapDatastore()))
+		p, err := publisher.New(priv, st)
+		require.NoError(t, err)
+		provider := testutil.RandomPeer(t)
+		md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+		_, err = p.Publish(ctx, peer.AddrInfo{ID: provider}, testutil.RandomCID(t).String(), slices.Values(testutil.RandomMultihashes(t, 5)), md)
+		require.NoError(t, err)
+
+		// the advert is signed by the publisher
+		report, err := store.Verify(ctx, st)
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+
+		report, err = store.Verify(ctx, st, store.WithExpectedSigner(pid))
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+
+		report, err = store.Verify(ctx, st, store.RequireProviderSigned())
+		require.NoError(t, err)
+		require.Equal(t, []store.FindingKind{store.FindingAdvertSignature}, kinds(report))
+	})
+
+	t.Run("missing advert", func(t *testing.T) {
+		c := newChain(t, 3)
+		require.NoError(t, c.ds.Delete(ctx, datastore.NewKey(c.ads[1].String())))
+
+		report, err := store.Verify(ctx, c.st)
+		require.NoError(t, err)
+		require.Equal(t, []store.FindingKind{store.FindingAdvertMissing}, kinds(report))
+		require.Equal(t, c.ads[1], report.Findings[0].Advert)
+		require.Equal(t, 1, report.Adverts)
+	})
+
+	t.Run("missing entry chunk", func(t *testing.T) {
+		c := newChain(t, 1)
+		ad, err := c.st.Advert(ctx, c.ads[0])
+		require.NoError(t, err)
+		require.NoError(t, c.ds.Delete(ctx, datastore.NewKey(ad.Entries.String())))
+
+		report, err := store.Verify(ctx, c.st)
+		require.NoError(t, err)
+		require.Equal(t, []store.FindingKind{store.FindingEntriesInvalid}, kinds(report))
+		require.Equal(t, c.ads[0], report.Findings[0].Advert)
+	})
+
+	t.Run("inconsistent tables", func(t *testing.T) {
+		c := newChain(t, 3)
+		require.NoError(t, c.st.DeleteChunkLinkForProviderAndContextID(ctx, pid, []byte(c.contextIDs[0])))
+		require.NoError(t, c.st.PutChunkLinkForProviderAndContextID(ctx, pid, []byte(c.contextIDs[1]), testutil.RandomCID(t)))
+		require.NoError(t, c.st.DeleteMetadataForProviderAndContextID(ctx, pid, []byte(c.contextIDs[1])))
+		other := metadata.Default.New(&metadata.IpfsGatewayHttp{}, metadata.Bitswap{})
+		require.NoError(t, c.st.PutMetadataForProviderAndContextID(ctx, pid, []byte(c.contextIDs[2]), other))
+
+		report, err := store.Verify(ctx, c.st)
+		require.NoError(t, err)
+		require.Equal(t, []store.FindingKind{
+			store.FindingMetadataMismatch,
+			store.FindingChunkLinkMismatch,
+			store.FindingMetadataMissing,
+			store.FindingChunkLinkMissing,
+		}, kinds(report))
+		require.Equal(t, pid, report.Findings[0].Provider)
+		require.Equal(t, []byte(c.contextIDs[2]), report.Findings[0].ContextID)
+	})
+
+	t.Run("stale tables after removal", func(t *testing.T) {
+		c := newChain(t, 1)
+		contextID := []byte(c.contextIDs[0])
+		ad, err := c.st.Advert(ctx, c.ads[0])
+		require.NoError(t, err)
+
+		ap, err := publisher.NewAdvertisementPublisher(priv, c.st)
+		require.NoError(t, err)
+		rm, err := publisher.GenerateAd(ctx, c.st, pid, nil, contextID, metadata.Default.New(), true, nil)
+		require.NoError(t, err)
+		require.NoError(t, ap.AddToBatch(rm))
+		_, err = ap.Commit(ctx)
+		require.NoError(t, err)
+
+		report, err := store.Verify(ctx, c.st)
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Findings)
+		require.Equal(t, 2, report.Adverts)
+
+		require.NoError(t, c.st.PutChunkLinkForProviderAndContextID(ctx, pid, contextID, ad.Entries))
+		md := metadata.Default.New(&metadata.IpfsGatewayHttp{})
+		require.NoError(t, c.st.PutMetadataForProviderAndContextID(ctx, pid, contextID, md))
+
+		report, err = store.Verify(ctx, c.st)
+		require.NoError(t, err)
+		require.Equal(t, []store.FindingKind{store.FindingChunkLinkStale, store.FindingMetadataStale}, kinds(report))
+	})
+}
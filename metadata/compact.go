@@ -0,0 +1,265 @@
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	ipnimd "github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+)
+
+// CompactMetadataContext is like [MetadataContext], but decodes the protocols
+// of this package wrapped in [Compact], so that they are encoded compactly
+// again when the metadata is marshaled.
+var CompactMetadataContext ipnimd.MetadataContext
+
+func init() {
+	mdctx := ipnimd.Default
+	for id, factory := range map[multicodec.Code]func() ipnimd.Protocol{
+		IndexClaimID:         func() ipnimd.Protocol { return &IndexClaimMetadata{} },
+		EqualsClaimID:        func() ipnimd.Protocol { return &EqualsClaimMetadata{} },
+		LocationCommitmentID: func() ipnimd.Protocol { return &LocationCommitmentMetadata{} },
+		PartitionClaimID:     func() ipnimd.Protocol { return &PartitionClaimMetadata{} },
+		RelationClaimID:      func() ipnimd.Protocol { return &RelationClaimMetadata{} },
+		InclusionClaimID:     func() ipnimd.Protocol { return &InclusionClaimMetadata{} },
+	} {
+		mdctx = mdctx.WithProtocol(id, func() ipnimd.Protocol { return &Compact{Protocol: factory()} })
+	}
+	CompactMetadataContext = mdctx
+}
+
+// Compact wraps a protocol of this package so that it is marshaled with
+// compact links: CIDv1s with a SHA2-256 multihash are encoded as bytes holding
+// the codec followed by the digest, dropping the CID version, the multihash
+// prefix and the CBOR link tag. Other CIDs are encoded as bytes holding the
+// full CID. This saves 6-7 bytes per link.
+//
+// Compactly encoded protocols are decoded by UnmarshalBinary of the plain
+// protocol types as well, so consumers using this package can read both
+// encodings. Consumers decoding with older versions can not.
+type Compact struct {
+	ipnimd.Protocol
+}
+
+var _ ipnimd.Protocol = (*Compact)(nil)
+
+// Unwrap returns the wrapped protocol if p is [Compact], and p otherwise.
+func Unwrap(p ipnimd.Protocol) ipnimd.Protocol {
+	if c, ok := p.(*Compact); ok {
+		return c.Protocol
+	}
+	return p
+}
+
+func (c *Compact) MarshalBinary() ([]byte, error) {
+	prototype, ok := nodePrototypes[c.ID()]
+	if !ok {
+		return c.Protocol.MarshalBinary()
+	}
+	buf := bytes.NewBuffer(varint.ToUvarint(uint64(c.ID())))
+	nd := bindnode.Wrap(c.Protocol, prototype.Type())
+	compact, err := compactLinks(nd.Representation())
+	if err != nil {
+		return nil, err
+	}
+	if err := dagcbor.Encode(compact, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Compact) UnmarshalBinary(data []byte) error {
+	return c.Protocol.UnmarshalBinary(data)
+}
+
+func (c *Compact) ReadFrom(r io.Reader) (int64, error) {
+	return c.Protocol.ReadFrom(r)
+}
+
+// GetClaim implements [HasClaim] for wrapped protocols that have a claim.
+func (c *Compact) GetClaim() cid.Cid {
+	if hc, ok := c.Protocol.(HasClaim); ok {
+		return hc.GetClaim()
+	}
+	return cid.Undef
+}
+
+// GetExpiration implements [HasExpiration] for wrapped protocols that expire.
+func (c *Compact) GetExpiration() int64 {
+	if he, ok := c.Protocol.(HasExpiration); ok {
+		return he.GetExpiration()
+	}
+	return 0
+}
+
+// compactCID encodes a CID as its codec and SHA2-256 digest if possible, and
+// as its bytes otherwise.
+func compactCID(c cid.Cid) []byte {
+	if c.Version() == 1 {
+		dmh, err := multihash.Decode(c.Hash())
+		if err == nil && dmh.Code == multihash.SHA2_256 && dmh.Length == 32 {
+			return append(varint.ToUvarint(c.Prefix().Codec), dmh.Digest...)
+		}
+	}
+	return c.Bytes()
+}
+
+// expandCID is the inverse of compactCID. Full CIDs are recognized by their
+// first byte, which is the CIDv1 version or the CIDv0 multihash code, neither
+// of which is a content codec.
+func expandCID(b []byte) (cid.Cid, error) {
+	if len(b) > 0 && (b[0] == 0x01 || b[0] == byte(multihash.SHA2_256)) {
+		_, c, err := cid.CidFromBytes(b)
+		return c, err
+	}
+	codec, n, err := varint.FromUvarint(b)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("decoding compact link codec: %w", err)
+	}
+	mh, err := multihash.Encode(b[n:], multihash.SHA2_256)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("decoding compact link digest: %w", err)
+	}
+	if len(b[n:]) != 32 {
+		return cid.Undef, fmt.Errorf("compact link digest has %d bytes, expected 32", len(b[n:]))
+	}
+	return cid.NewCidV1(codec, mh), nil
+}
+
+// compactLinks returns a copy of the node with all links replaced by bytes
+// holding the compact CID.
+func compactLinks(n datamodel.Node) (datamodel.Node, error) {
+	if n.Kind() != datamodel.Kind_Link {
+		return mapValues(n, func(_, v datamodel.Node) (datamodel.Node, error) {
+			return compactLinks(v)
+		})
+	}
+	l, err := n.AsLink()
+	if err != nil {
+		return nil, err
+	}
+	return basicnode.NewBytes(compactCID(l.(cidlink.Link).Cid)), nil
+}
+
+// expandLinks is the inverse of compactLinks for an untyped node of the schema
+// type. Only bytes in place of links of the type are expanded, so that bytes
+// fields are left as they are.
+func expandLinks(n datamodel.Node, t schema.Type) (datamodel.Node, error) {
+	switch t := t.(type) {
+	case *schema.TypeLink:
+		if n.Kind() != datamodel.Kind_Bytes {
+			return n, nil
+		}
+	case *schema.TypeStruct:
+		repr, ok := t.RepresentationStrategy().(schema.StructRepresentation_Map)
+		if !ok {
+			return n, nil
+		}
+		fields := map[string]schema.Type{}
+		for _, f := range t.Fields() {
+			fields[repr.GetFieldKey(f)] = f.Type()
+		}
+		return mapValues(n, func(k, v datamodel.Node) (datamodel.Node, error) {
+			if k == nil {
+				return v, nil
+			}
+			key, err := k.AsString()
+			if err != nil {
+				return nil, err
+			}
+			ft, ok := fields[key]
+			if !ok {
+				return v, nil
+			}
+			return expandLinks(v, ft)
+		})
+	case *schema.TypeList:
+		return mapValues(n, func(_, v datamodel.Node) (datamodel.Node, error) {
+			return expandLinks(v, t.ValueType())
+		})
+	case *schema.TypeMap:
+		return mapValues(n, func(_, v datamodel.Node) (datamodel.Node, error) {
+			return expandLinks(v, t.ValueType())
+		})
+	default:
+		return n, nil
+	}
+	b, err := n.AsBytes()
+	if err != nil {
+		return nil, err
+	}
+	c, err := expandCID(b)
+	if err != nil {
+		return nil, err
+	}
+	return basicnode.NewLink(cidlink.Link{Cid: c}), nil
+}
+
+// mapValues returns an untyped copy of a map or list node with its values
+// replaced by f, which is passed the key of map entries, or nil for list
+// values. Nodes of other kinds are returned as they are.
+func mapValues(n datamodel.Node, f func(k, v datamodel.Node) (datamodel.Node, error)) (datamodel.Node, error) {
+	switch n.Kind() {
+	case datamodel.Kind_Map:
+		nb := basicnode.Prototype.Map.NewBuilder()
+		ma, err := nb.BeginMap(n.Length())
+		if err != nil {
+			return nil, err
+		}
+		for it := n.MapIterator(); !it.Done(); {
+			k, v, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			v, err = f(k, v)
+			if err != nil {
+				return nil, err
+			}
+			if err := ma.AssembleKey().AssignNode(k); err != nil {
+				return nil, err
+			}
+			if err := ma.AssembleValue().AssignNode(v); err != nil {
+				return nil, err
+			}
+		}
+		if err := ma.Finish(); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	case datamodel.Kind_List:
+		nb := basicnode.Prototype.List.NewBuilder()
+		la, err := nb.BeginList(n.Length())
+		if err != nil {
+			return nil, err
+		}
+		for it := n.ListIterator(); !it.Done(); {
+			_, v, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			v, err = f(nil, v)
+			if err != nil {
+				return nil, err
+			}
+			if err := la.AssembleValue().AssignNode(v); err != nil {
+				return nil, err
+			}
+		}
+		if err := la.Finish(); err != nil {
+			return nil, err
+		}
+		return nb.Build(), nil
+	default:
+		return n, nil
+	}
+}
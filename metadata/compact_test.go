@@ -0,0 +1,93 @@
+package metadata_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	ipnimd "github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/metadata"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact(t *testing.T) {
+	randomCID := func(codec multicodec.Code) cid.Cid {
+		c := testutil.RandomCID(t).(cidlink.Link).Cid
+		return cid.NewCidV1(uint64(codec), c.Hash())
+	}
+	length := uint64(138)
+	shard := randomCID(multicodec.Car)
+	location := &metadata.LocationCommitmentMetadata{
+		Shard:      &shard,
+		Range:      &metadata.Range{Offset: 10, Length: &length},
+		Expiration: time.Now().Unix(),
+		Claim:      randomCID(multicodec.DagCbor),
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := (&metadata.Compact{Protocol: location}).MarshalBinary()
+		require.NoError(t, err)
+		plain, err := location.MarshalBinary()
+		require.NoError(t, err)
+		require.Less(t, len(data), len(plain))
+
+		decoded := metadata.LocationCommitmentMetadata{}
+		require.NoError(t, decoded.UnmarshalBinary(data))
+		require.Equal(t, *location, decoded)
+
+		md := metadata.CompactMetadataContext.New()
+		require.NoError(t, md.UnmarshalBinary(data))
+		c, ok := md.Get(metadata.LocationCommitmentID).(*metadata.Compact)
+		require.True(t, ok)
+		require.Equal(t, location, metadata.Unwrap(c))
+		require.Equal(t, location.Claim, c.GetClaim())
+		require.True(t, md.Equal(metadata.CompactMetadataContext.New(&metadata.Compact{Protocol: location})))
+
+		expiresAt, ok := metadata.ExpiresAt(md)
+		require.True(t, ok)
+		require.Equal(t, location.Expiration, expiresAt.Unix())
+	})
+
+	t.Run("other CIDs", func(t *testing.T) {
+		blake, err := multihash.Sum([]byte("blake"), multihash.BLAKE2B_MIN+31, -1)
+		require.NoError(t, err)
+		v0, err := cid.Decode("QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+		require.NoError(t, err)
+		for _, c := range []cid.Cid{cid.NewCidV1(cid.Raw, blake), v0} {
+			index := &metadata.IndexClaimMetadata{Index: c, Expiration: 1234, Claim: c}
+			data, err := (&metadata.Compact{Protocol: index}).MarshalBinary()
+			require.NoError(t, err)
+
+			decoded := metadata.IndexClaimMetadata{}
+			require.NoError(t, decoded.UnmarshalBinary(data))
+			require.Equal(t, *index, decoded)
+		}
+	})
+
+	t.Run("size budget", func(t *testing.T) {
+		report, err := metadata.ValidateSize(metadata.MetadataContext.New(location))
+		require.ErrorIs(t, err, metadata.ErrOverBudget)
+		require.True(t, report.OverBudget())
+		require.Equal(t, metadata.DefaultSizeBudget, report.Budget)
+		require.Len(t, report.Protocols, 1)
+		require.Equal(t, multicodec.Code(metadata.LocationCommitmentID), report.Protocols[0].Protocol)
+		require.Equal(t, report.Size, report.Protocols[0].Size)
+
+		report, err = metadata.ValidateSize(metadata.MetadataContext.New(location), metadata.WithWarnOnly())
+		require.NoError(t, err)
+		require.True(t, report.OverBudget())
+
+		report, err = metadata.ValidateSize(metadata.CompactMetadataContext.New(&metadata.Compact{Protocol: location}))
+		require.NoError(t, err)
+		require.False(t, report.OverBudget())
+
+		md := metadata.MetadataContext.New(location, &ipnimd.IpfsGatewayHttp{})
+		report, err = metadata.ValidateSize(md, metadata.WithSizeBudget(1000))
+		require.NoError(t, err)
+		require.Len(t, report.Protocols, 2)
+	})
+}
@@ -0,0 +1,386 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	ipnimd "github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+)
+
+var _ ipnimd.Protocol = (*Unknown)(nil)
+
+// Unknown is a metadata protocol that is not recognised by the decoder. The
+// payload is kept as it is, so that metadata containing it can be encoded
+// again without loss.
+type Unknown struct {
+	// Code is the multicodec of the protocol.
+	Code multicodec.Code
+	// Bytes is the encoded payload following the protocol ID.
+	Bytes []byte
+}
+
+func (u *Unknown) ID() multicodec.Code {
+	return u.Code
+}
+
+func (u *Unknown) MarshalBinary() ([]byte, error) {
+	return append(varint.ToUvarint(uint64(u.Code)), u.Bytes...), nil
+}
+
+func (u *Unknown) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	_, err := u.ReadFrom(r)
+	return err
+}
+
+// ReadFrom reads the protocol ID followed by the payload, which is expected to
+// be a single CBOR data item, like the payloads of the protocols in this
+// package, or empty at the end of the input, like the payload of Bitswap.
+// Payloads in other formats cannot be framed, since the length of the payload
+// is not encoded. See [Decoder.Decode] for how empty payloads followed by other
+// protocols are decoded.
+func (u *Unknown) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	v, err := varint.ReadUvarint(cr)
+	if err != nil {
+		return cr.readCount, err
+	}
+	u.Code = multicodec.Code(v)
+	var buf bytes.Buffer
+	if err := copyCBORItem(&buf, cr, 0, nil); err != nil {
+		if errors.Is(err, io.EOF) && buf.Len() == 0 {
+			u.Bytes = nil
+			return cr.readCount, nil
+		}
+		return cr.readCount, fmt.Errorf("reading payload of unknown protocol %s: %w", u.Code, err)
+	}
+	u.Bytes = buf.Bytes()
+	return cr.readCount, nil
+}
+
+// Decoder decodes metadata containing protocols it may not recognise.
+type Decoder struct {
+	protocols map[multicodec.Code]func() ipnimd.Protocol
+}
+
+// DecoderOption is an option configuring a [Decoder].
+type DecoderOption func(d *Decoder)
+
+// WithDecoderProtocol adds a protocol to those recognised by the decoder,
+// replacing any existing factory for the same ID.
+func WithDecoderProtocol(id multicodec.Code, factory func() ipnimd.Protocol) DecoderOption {
+	return func(d *Decoder) {
+		d.protocols[id] = factory
+	}
+}
+
+// DefaultDecoderProtocols are the protocols recognised by a decoder by default:
+// the protocols of this package, along with the IPNI transports.
+var DefaultDecoderProtocols = map[multicodec.Code]func() ipnimd.Protocol{
+	multicodec.TransportBitswap:             func() ipnimd.Protocol { return &ipnimd.Bitswap{} },
+	multicodec.TransportGraphsyncFilecoinv1: func() ipnimd.Protocol { return &ipnimd.GraphsyncFilecoinV1{} },
+	multicodec.TransportIpfsGatewayHttp:     func() ipnimd.Protocol { return &ipnimd.IpfsGatewayHttp{} },
+	multicodec.TransportFilecoinPieceHttp:   func() ipnimd.Protocol { return &ipnimd.FilecoinPieceHttp{} },
+	IndexClaimID:                            func() ipnimd.Protocol { return &IndexClaimMetadata{} },
+	EqualsClaimID:                           func() ipnimd.Protocol { return &EqualsClaimMetadata{} },
+	LocationCommitmentID:                    func() ipnimd.Protocol { return &LocationCommitmentMetadata{} },
+	PartitionClaimID:                        func() ipnimd.Protocol { return &PartitionClaimMetadata{} },
+	RelationClaimID:                         func() ipnimd.Protocol { return &RelationClaimMetadata{} },
+	InclusionClaimID:                        func() ipnimd.Protocol { return &InclusionClaimMetadata{} },
+}
+
+// NewDecoder creates a decoder recognising [DefaultDecoderProtocols] and any
+// protocols added by the options.
+func NewDecoder(opts ...DecoderOption) *Decoder {
+	d := &Decoder{protocols: maps.Clone(DefaultDecoderProtocols)}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Decode decodes the protocols in the metadata. Recognised protocols are
+// decoded into their structs, ignoring any fields they do not define, and
+// unrecognised protocols are returned as [Unknown].
+//
+// The length of a payload is not encoded, so the payload of an unrecognised
+// protocol is assumed to be either a single CBOR data item, or empty when at
+// the end of the metadata or followed by a recognised protocol. When the
+// metadata can be framed in more than one way, the framing with the fewest
+// unrecognised protocols is used, preferring CBOR payloads. Unrecognised
+// protocols with payloads in other formats cannot be decoded.
+func (d *Decoder) Decode(data []byte) ([]ipnimd.Protocol, error) {
+	if len(data) == 0 {
+		return nil, errors.New("at least one transport must be specified")
+	}
+	dec := &decoding{decoder: d, data: data, results: map[int]decoded{}}
+	res := dec.from(0)
+	return res.protocols, res.err
+}
+
+// decoding decodes metadata, remembering the protocols decoded from each
+// offset, since the metadata may be framed in more than one way when it
+// contains unrecognised protocols.
+type decoding struct {
+	decoder *Decoder
+	data    []byte
+	results map[int]decoded
+}
+
+// decoded are the protocols decoded from an offset to the end of the metadata.
+type decoded struct {
+	protocols []ipnimd.Protocol
+	unknown   int
+	err       error
+}
+
+// prepend returns the decoded protocols with p before them.
+func (res decoded) prepend(p ipnimd.Protocol) decoded {
+	protocols := append([]ipnimd.Protocol{p}, res.protocols...)
+	unknown := res.unknown
+	if _, ok := p.(*Unknown); ok {
+		unknown++
+	}
+	return decoded{protocols: protocols, unknown: unknown}
+}
+
+func (dec *decoding) from(offset int) decoded {
+	if offset == len(dec.data) {
+		return decoded{}
+	}
+	if res, ok := dec.results[offset]; ok {
+		return res
+	}
+	res := dec.decode(offset)
+	dec.results[offset] = res
+	return res
+}
+
+func (dec *decoding) decode(offset int) decoded {
+	data := dec.data[offset:]
+	v, n, err := varint.FromUvarint(data)
+	if err != nil {
+		return decoded{err: err}
+	}
+	code := multicodec.Code(v)
+	if factory, ok := dec.decoder.protocols[code]; ok {
+		proto := factory()
+		r := bytes.NewReader(data)
+		if _, err := proto.ReadFrom(r); err != nil {
+			return decoded{err: fmt.Errorf("decoding protocol %s: %w", code, err)}
+		}
+		rest := dec.from(len(dec.data) - r.Len())
+		if rest.err != nil {
+			return rest
+		}
+		return rest.prepend(proto)
+	}
+
+	var best decoded
+	var payload bytes.Buffer
+	err = copyCBORItem(&payload, bytes.NewReader(data[n:]), 0, nil)
+	if err == nil {
+		best = dec.from(offset + n + payload.Len())
+		if best.err == nil {
+			best = best.prepend(&Unknown{Code: code, Bytes: payload.Bytes()})
+		}
+	} else {
+		best = decoded{err: fmt.Errorf("decoding protocol %s: %w", code, err)}
+	}
+	if dec.recognisedAt(offset + n) {
+		empty := dec.from(offset + n)
+		if empty.err == nil {
+			empty = empty.prepend(&Unknown{Code: code})
+			if best.err != nil || empty.unknown < best.unknown {
+				best = empty
+			}
+		}
+	}
+	return best
+}
+
+// recognisedAt returns true if the metadata ends at the offset, or a protocol
+// recognised by the decoder starts at it.
+func (dec *decoding) recognisedAt(offset int) bool {
+	if offset == len(dec.data) {
+		return true
+	}
+	v, _, err := varint.FromUvarint(dec.data[offset:])
+	if err != nil {
+		return false
+	}
+	_, ok := dec.decoder.protocols[multicodec.Code(v)]
+	return ok
+}
+
+// DecodeMetadata decodes metadata with a default [Decoder], so that records
+// produced by newer versions of this package can be read. The returned metadata
+// is created from [MetadataContext].
+func DecodeMetadata(data []byte) (ipnimd.Metadata, error) {
+	protocols, err := NewDecoder().Decode(data)
+	if err != nil {
+		return ipnimd.Metadata{}, err
+	}
+	return MetadataContext.New(protocols...), nil
+}
+
+// knownFields returns an untyped copy of a map node without the keys that are
+// not fields of the struct type, so that newer encodings with additional fields
+// can be decoded. Nodes of other kinds or types are returned as they are.
+func knownFields(n datamodel.Node, t schema.Type) (datamodel.Node, error) {
+	st, ok := t.(*schema.TypeStruct)
+	if !ok || n.Kind() != datamodel.Kind_Map {
+		return n, nil
+	}
+	repr, ok := st.RepresentationStrategy().(schema.StructRepresentation_Map)
+	if !ok {
+		return n, nil
+	}
+	known := map[string]struct{}{}
+	for _, f := range st.Fields() {
+		known[repr.GetFieldKey(f)] = struct{}{}
+	}
+	nb := basicnode.Prototype.Map.NewBuilder()
+	ma, err := nb.BeginMap(-1)
+	if err != nil {
+		return nil, err
+	}
+	for it := n.MapIterator(); !it.Done(); {
+		k, v, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		key, err := k.AsString()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := known[key]; !ok {
+			continue
+		}
+		if err := ma.AssembleKey().AssignString(key); err != nil {
+			return nil, err
+		}
+		if err := ma.AssembleValue().AssignNode(v); err != nil {
+			return nil, err
+		}
+	}
+	if err := ma.Finish(); err != nil {
+		return nil, err
+	}
+	return nb.Build(), nil
+}
+
+// maxCBORDepth bounds the nesting of CBOR items read from metadata payloads.
+const maxCBORDepth = 64
+
+// cborCIDTag is the CBOR tag of links in DAG-CBOR.
+const cborCIDTag = 42
+
+// copyCBORItem copies a single CBOR data item from r to w, without
+// interpreting it. If sawBytes is not nil, it is set when the item contains a
+// byte string other than the bytes of a link, which is how compact links are
+// encoded (see [Compact]).
+func copyCBORItem(w *bytes.Buffer, r io.Reader, depth int, sawBytes *bool) error {
+	if depth > maxCBORDepth {
+		return errors.New("cbor item nested too deeply")
+	}
+	head, err := readBytes(w, r, 1)
+	if err != nil {
+		return err
+	}
+	major, info := head[0]>>5, head[0]&0x1f
+	if major == 7 && info == 31 {
+		return errCBORBreak
+	}
+	if info == 31 {
+		if major < 2 || major > 5 {
+			return fmt.Errorf("unexpected cbor indefinite length for major type %d", major)
+		}
+		// Indefinite length items are terminated by a break.
+		for {
+			if err := copyCBORItem(w, r, depth+1, sawBytes); err != nil {
+				if errors.Is(err, errCBORBreak) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		b, err := readBytes(w, r, 1<<(info-24))
+		if err != nil {
+			return err
+		}
+		for _, c := range b {
+			arg = arg<<8 | uint64(c)
+		}
+	default:
+		return fmt.Errorf("invalid cbor additional info %d", info)
+	}
+	switch major {
+	case 2, 3:
+		if major == 2 && sawBytes != nil {
+			*sawBytes = true
+		}
+		if arg > ipnimd.MaxMetadataSize {
+			return ipnimd.ErrTooLong
+		}
+		_, err = readBytes(w, r, int(arg))
+		return err
+	case 4, 5:
+		if major == 5 {
+			arg *= 2
+		}
+		if arg > ipnimd.MaxMetadataSize {
+			return ipnimd.ErrTooLong
+		}
+		return copyCBORItems(w, r, arg, depth+1, sawBytes)
+	case 6:
+		if arg == cborCIDTag {
+			// The bytes of a CID are not a compact link.
+			return copyCBORItems(w, r, 1, depth+1, nil)
+		}
+		return copyCBORItems(w, r, 1, depth+1, sawBytes)
+	default:
+		return nil
+	}
+}
+
+// copyCBORItems copies n CBOR data items from r to w.
+func copyCBORItems(w *bytes.Buffer, r io.Reader, n uint64, depth int, sawBytes *bool) error {
+	for range n {
+		if err := copyCBORItem(w, r, depth, sawBytes); err != nil {
+			if errors.Is(err, errCBORBreak) {
+				return errors.New("unexpected cbor break")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// errCBORBreak is returned when reading the break terminating an indefinite
+// length item.
+var errCBORBreak = errors.New("cbor break")
+
+// readBytes reads n bytes from r, writing them to w as well as returning them.
+func readBytes(w *bytes.Buffer, r io.Reader, n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	w.Write(b)
+	return b, nil
+}
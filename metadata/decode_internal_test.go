@@ -0,0 +1,93 @@
+package metadata
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/multiformats/go-varint"
+	"github.com/stretchr/testify/require"
+)
+
+// testCID returns the CID of raw data, since testutil depends on this package.
+func testCID(t *testing.T, data string) cid.Cid {
+	mh, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(cid.Raw, mh)
+}
+
+func TestCopyCBORItemBytes(t *testing.T) {
+	claim := testCID(t, "claim")
+	location := &LocationCommitmentMetadata{Expiration: 1234, Claim: claim}
+
+	for name, tc := range map[string]struct {
+		protocol interface{ MarshalBinary() ([]byte, error) }
+		compact  bool
+	}{
+		// links are tagged byte strings, which do not require the untyped decode
+		"links":         {location, false},
+		"compact links": {&Compact{Protocol: location}, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := tc.protocol.MarshalBinary()
+			require.NoError(t, err)
+			_, n, err := varint.FromUvarint(data)
+			require.NoError(t, err)
+
+			var payload bytes.Buffer
+			sawBytes := false
+			require.NoError(t, copyCBORItem(&payload, bytes.NewReader(data[n:]), 0, &sawBytes))
+			require.Equal(t, tc.compact, sawBytes)
+		})
+	}
+}
+
+func TestExpandLinks(t *testing.T) {
+	ts, err := ipld.LoadSchemaBytes([]byte(`
+		type Example struct {
+			data Bytes (rename "d")
+			link Link (rename "l")
+			links [Link] (rename "ls")
+		}
+	`))
+	require.NoError(t, err)
+	link := testCID(t, "link")
+
+	n, err := qp.BuildMap(basicnode.Prototype.Any, -1, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "d", qp.Bytes([]byte("opaque")))
+		qp.MapEntry(ma, "l", qp.Bytes(compactCID(link)))
+		qp.MapEntry(ma, "ls", qp.List(-1, func(la datamodel.ListAssembler) {
+			qp.ListEntry(la, qp.Bytes(compactCID(link)))
+		}))
+	})
+	require.NoError(t, err)
+
+	expanded, err := expandLinks(n, ts.TypeByName("Example"))
+	require.NoError(t, err)
+
+	d, err := expanded.LookupByString("d")
+	require.NoError(t, err)
+	b, err := d.AsBytes()
+	require.NoError(t, err)
+	require.Equal(t, []byte("opaque"), b)
+
+	l, err := expanded.LookupByString("l")
+	require.NoError(t, err)
+	lnk, err := l.AsLink()
+	require.NoError(t, err)
+	require.Equal(t, link, lnk.(cidlink.Link).Cid)
+
+	ls, err := expanded.LookupByString("ls")
+	require.NoError(t, err)
+	first, err := ls.LookupByIndex(0)
+	require.NoError(t, err)
+	lnk, err = first.AsLink()
+	require.NoError(t, err)
+	require.Equal(t, link, lnk.(cidlink.Link).Cid)
+}
@@ -0,0 +1,124 @@
+package metadata_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	ipnimd "github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-varint"
+	"github.com/storacha/go-libstoracha/metadata"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	claim := testutil.RandomCID(t).(cidlink.Link).Cid
+	location := &metadata.LocationCommitmentMetadata{Expiration: 1234, Claim: claim}
+
+	encode := func(t *testing.T, code multicodec.Code, build func(datamodel.MapAssembler)) []byte {
+		n, err := qp.BuildMap(basicnode.Prototype.Any, -1, build)
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		buf.Write(varint.ToUvarint(uint64(code)))
+		require.NoError(t, dagcbor.Encode(n, &buf))
+		return buf.Bytes()
+	}
+
+	t.Run("unknown protocols", func(t *testing.T) {
+		unknownID := multicodec.Code(0x3E00FF)
+		unknown := encode(t, unknownID, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "b", qp.Bytes([]byte("opaque")))
+			qp.MapEntry(ma, "l", qp.List(-1, func(la datamodel.ListAssembler) {
+				qp.ListEntry(la, qp.Link(cidlink.Link{Cid: claim}))
+				qp.ListEntry(la, qp.Int(-42))
+			}))
+		})
+		// indefinite length map containing an indefinite length string
+		indefinite := append(varint.ToUvarint(0x3E0100), 0xbf, 0x61, 'k', 0x7f, 0x61, 'a', 0x61, 'b', 0xff, 0xff)
+		bitswap, err := (&ipnimd.Bitswap{}).MarshalBinary()
+		require.NoError(t, err)
+		known, err := location.MarshalBinary()
+		require.NoError(t, err)
+		data := bytes.Join([][]byte{bitswap, known, unknown, indefinite}, nil)
+
+		md, err := metadata.DecodeMetadata(data)
+		require.NoError(t, err)
+		require.Equal(t, []multicodec.Code{multicodec.TransportBitswap, metadata.LocationCommitmentID, unknownID, 0x3E0100}, md.Protocols())
+		require.Equal(t, location, md.Get(metadata.LocationCommitmentID))
+		u, ok := md.Get(unknownID).(*metadata.Unknown)
+		require.True(t, ok)
+		require.Equal(t, unknown[varint.UvarintSize(uint64(unknownID)):], u.Bytes)
+
+		encoded, err := md.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, data, encoded)
+	})
+
+	t.Run("unknown protocols without payload", func(t *testing.T) {
+		known, err := location.MarshalBinary()
+		require.NoError(t, err)
+		// the varint of the location commitment ID is also a CBOR array, so the
+		// unknown protocol could be framed with it as its payload
+		data := bytes.Join([][]byte{varint.ToUvarint(0x3D0000), known, varint.ToUvarint(0x3E0100)}, nil)
+
+		protocols, err := metadata.NewDecoder().Decode(data)
+		require.NoError(t, err)
+		require.Equal(t, []ipnimd.Protocol{
+			&metadata.Unknown{Code: 0x3D0000},
+			location,
+			&metadata.Unknown{Code: 0x3E0100},
+		}, protocols)
+
+		md, err := metadata.DecodeMetadata(data)
+		require.NoError(t, err)
+		encoded, err := md.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, data, encoded)
+
+		u := metadata.Unknown{}
+		require.NoError(t, u.UnmarshalBinary(varint.ToUvarint(0x3E0100)))
+		require.Equal(t, metadata.Unknown{Code: 0x3E0100}, u)
+	})
+
+	t.Run("unknown fields", func(t *testing.T) {
+		data := encode(t, metadata.LocationCommitmentID, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "c", qp.Link(cidlink.Link{Cid: claim}))
+			qp.MapEntry(ma, "e", qp.Int(1234))
+			qp.MapEntry(ma, "n", qp.Bytes([]byte("new field")))
+		})
+		decoded := metadata.LocationCommitmentMetadata{}
+		require.NoError(t, decoded.UnmarshalBinary(data))
+		require.Equal(t, *location, decoded)
+
+		protocols, err := metadata.NewDecoder().Decode(data)
+		require.NoError(t, err)
+		require.Equal(t, []ipnimd.Protocol{location}, protocols)
+	})
+
+	t.Run("custom protocols", func(t *testing.T) {
+		data, err := location.MarshalBinary()
+		require.NoError(t, err)
+		d := metadata.NewDecoder(metadata.WithDecoderProtocol(metadata.LocationCommitmentID, func() ipnimd.Protocol {
+			return &metadata.Compact{Protocol: &metadata.LocationCommitmentMetadata{}}
+		}))
+		protocols, err := d.Decode(data)
+		require.NoError(t, err)
+		require.Equal(t, location, metadata.Unwrap(protocols[0]))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		unknown := encode(t, 0x3E00FF, func(ma datamodel.MapAssembler) {
+			qp.MapEntry(ma, "b", qp.Bytes([]byte("opaque")))
+		})
+		_, err := metadata.DecodeMetadata(unknown[:len(unknown)-1])
+		require.Error(t, err)
+		_, err = metadata.DecodeMetadata(nil)
+		require.Error(t, err)
+	})
+}
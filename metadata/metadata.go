@@ -48,6 +48,9 @@ the multihash used to lookup the record.
 
 Additionally, if Range parameter is present in the metadata, it should be
 translated into a range HTTP header when retrieving content.
+
+[ResolveRequests] builds the HTTP requests for the blob and claim following
+these rules.
 */
 package metadata
 
@@ -57,10 +60,13 @@ import (
 	_ "embed"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	"github.com/ipld/go-ipld-prime/node/bindnode"
 	"github.com/ipld/go-ipld-prime/schema"
 	ipnimd "github.com/ipni/go-libipni/metadata"
@@ -70,12 +76,18 @@ import (
 
 var (
 	_ ipnimd.Protocol = (*IndexClaimMetadata)(nil)
+	_ ipnimd.Protocol = (*PartitionClaimMetadata)(nil)
+	_ ipnimd.Protocol = (*RelationClaimMetadata)(nil)
+	_ ipnimd.Protocol = (*InclusionClaimMetadata)(nil)
 
 	//go:embed metadata.ipldsch
 	schemaBytes                []byte
 	indexClaimMetadata         schema.TypedPrototype
 	equalsClaimMetadata        schema.TypedPrototype
 	locationCommitmentMetadata schema.TypedPrototype
+	partitionClaimMetadata     schema.TypedPrototype
+	relationClaimMetadata      schema.TypedPrototype
+	inclusionClaimMetadata     schema.TypedPrototype
 )
 
 // metadata identifiers
@@ -90,6 +102,15 @@ const EqualsClaimID = 0x3E0001
 // LocationCommitmentID is the multicodec for location commitments
 const LocationCommitmentID = 0x3E0002
 
+// PartitionClaimID is the multicodec for partition claims
+const PartitionClaimID = 0x3E0003
+
+// RelationClaimID is the multicodec for relation claims
+const RelationClaimID = 0x3E0004
+
+// InclusionClaimID is the multicodec for inclusion claims
+const InclusionClaimID = 0x3E0005
+
 var nodePrototypes = map[multicodec.Code]schema.TypedPrototype{}
 
 func init() {
@@ -100,10 +121,16 @@ func init() {
 	indexClaimMetadata = bindnode.Prototype((*IndexClaimMetadata)(nil), typeSystem.TypeByName("IndexClaimMetadata"))
 	equalsClaimMetadata = bindnode.Prototype((*EqualsClaimMetadata)(nil), typeSystem.TypeByName("EqualsClaimMetadata"))
 	locationCommitmentMetadata = bindnode.Prototype((*LocationCommitmentMetadata)(nil), typeSystem.TypeByName("LocationCommitmentMetadata"))
+	partitionClaimMetadata = bindnode.Prototype((*PartitionClaimMetadata)(nil), typeSystem.TypeByName("PartitionClaimMetadata"))
+	relationClaimMetadata = bindnode.Prototype((*RelationClaimMetadata)(nil), typeSystem.TypeByName("RelationClaimMetadata"))
+	inclusionClaimMetadata = bindnode.Prototype((*InclusionClaimMetadata)(nil), typeSystem.TypeByName("InclusionClaimMetadata"))
 
 	nodePrototypes[IndexClaimID] = indexClaimMetadata
 	nodePrototypes[EqualsClaimID] = equalsClaimMetadata
 	nodePrototypes[LocationCommitmentID] = locationCommitmentMetadata
+	nodePrototypes[PartitionClaimID] = partitionClaimMetadata
+	nodePrototypes[RelationClaimID] = relationClaimMetadata
+	nodePrototypes[InclusionClaimID] = inclusionClaimMetadata
 }
 
 var MetadataContext ipnimd.MetadataContext
@@ -113,6 +140,9 @@ func init() {
 	mdctx = mdctx.WithProtocol(IndexClaimID, func() ipnimd.Protocol { return &IndexClaimMetadata{} })
 	mdctx = mdctx.WithProtocol(EqualsClaimID, func() ipnimd.Protocol { return &EqualsClaimMetadata{} })
 	mdctx = mdctx.WithProtocol(LocationCommitmentID, func() ipnimd.Protocol { return &LocationCommitmentMetadata{} })
+	mdctx = mdctx.WithProtocol(PartitionClaimID, func() ipnimd.Protocol { return &PartitionClaimMetadata{} })
+	mdctx = mdctx.WithProtocol(RelationClaimID, func() ipnimd.Protocol { return &RelationClaimMetadata{} })
+	mdctx = mdctx.WithProtocol(InclusionClaimID, func() ipnimd.Protocol { return &InclusionClaimMetadata{} })
 	MetadataContext = mdctx
 }
 
@@ -120,6 +150,32 @@ type HasClaim interface {
 	GetClaim() cid.Cid
 }
 
+// HasExpiration is implemented by metadata protocols that expire.
+type HasExpiration interface {
+	// GetExpiration returns the expiration as unix epoch in seconds, or zero if
+	// the metadata does not expire.
+	GetExpiration() int64
+}
+
+// ExpiresAt returns the earliest expiration of the protocols in the metadata,
+// and false if none of them expire.
+func ExpiresAt(md ipnimd.Metadata) (time.Time, bool) {
+	var earliest int64
+	for _, code := range md.Protocols() {
+		e, ok := md.Get(code).(HasExpiration)
+		if !ok || e.GetExpiration() == 0 {
+			continue
+		}
+		if earliest == 0 || e.GetExpiration() < earliest {
+			earliest = e.GetExpiration()
+		}
+	}
+	if earliest == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(earliest, 0), true
+}
+
 /*
 	 IndexClaimMetadata represents metadata for an index claim
 		Index claim metadata
@@ -142,6 +198,9 @@ func (i *IndexClaimMetadata) ReadFrom(r io.Reader) (n int64, err error) { return
 func (i *IndexClaimMetadata) GetClaim() cid.Cid {
 	return i.Claim
 }
+func (i *IndexClaimMetadata) GetExpiration() int64 {
+	return i.Expiration
+}
 
 // EqualsClaimMetadata represents metadata for an equals claim
 type EqualsClaimMetadata struct {
@@ -162,6 +221,9 @@ func (e *EqualsClaimMetadata) ReadFrom(r io.Reader) (n int64, err error) { retur
 func (e *EqualsClaimMetadata) GetClaim() cid.Cid {
 	return e.Claim
 }
+func (e *EqualsClaimMetadata) GetExpiration() int64 {
+	return e.Expiration
+}
 
 type Range struct {
 	Offset uint64
@@ -193,6 +255,85 @@ func (l *LocationCommitmentMetadata) ReadFrom(r io.Reader) (n int64, err error)
 func (l *LocationCommitmentMetadata) GetClaim() cid.Cid {
 	return l.Claim
 }
+func (l *LocationCommitmentMetadata) GetExpiration() int64 {
+	return l.Expiration
+}
+
+// PartitionClaimMetadata represents metadata for a partition claim
+type PartitionClaimMetadata struct {
+	// Blocks is an optional cid of an index of the blocks in the content graph
+	Blocks *cid.Cid
+	// Parts are the cids of the archives the content graph can be read from
+	Parts []cid.Cid
+	// Expiration as unix epoch in seconds
+	Expiration int64
+	// Claim indicates the cid of the claim - the claim should be fetchable by combining the http multiaddr of the provider with the claim cid
+	Claim cid.Cid
+}
+
+func (p *PartitionClaimMetadata) ID() multicodec.Code {
+	return PartitionClaimID
+}
+func (p *PartitionClaimMetadata) MarshalBinary() ([]byte, error)            { return marshalBinary(p) }
+func (p *PartitionClaimMetadata) UnmarshalBinary(data []byte) error         { return unmarshalBinary(p, data) }
+func (p *PartitionClaimMetadata) ReadFrom(r io.Reader) (n int64, err error) { return readFrom(p, r) }
+func (p *PartitionClaimMetadata) GetClaim() cid.Cid {
+	return p.Claim
+}
+func (p *PartitionClaimMetadata) GetExpiration() int64 {
+	return p.Expiration
+}
+
+// RelationClaimMetadata represents metadata for a relation claim
+type RelationClaimMetadata struct {
+	// Parts are the cids of the parts the content's blocks can be found in. The
+	// children and the inclusions of each part are only available from the
+	// claim itself, to keep the metadata compact.
+	Parts []cid.Cid
+	// Expiration as unix epoch in seconds
+	Expiration int64
+	// Claim indicates the cid of the claim - the claim should be fetchable by combining the http multiaddr of the provider with the claim cid
+	Claim cid.Cid
+}
+
+func (r *RelationClaimMetadata) ID() multicodec.Code {
+	return RelationClaimID
+}
+func (r *RelationClaimMetadata) MarshalBinary() ([]byte, error)             { return marshalBinary(r) }
+func (r *RelationClaimMetadata) UnmarshalBinary(data []byte) error          { return unmarshalBinary(r, data) }
+func (r *RelationClaimMetadata) ReadFrom(rd io.Reader) (n int64, err error) { return readFrom(r, rd) }
+func (r *RelationClaimMetadata) GetClaim() cid.Cid {
+	return r.Claim
+}
+func (r *RelationClaimMetadata) GetExpiration() int64 {
+	return r.Expiration
+}
+
+// InclusionClaimMetadata represents metadata for an inclusion claim
+type InclusionClaimMetadata struct {
+	// Includes represents the cid of the contents included in the content cid
+	// that was used for lookup
+	Includes cid.Cid
+	// Proof is an optional cid of a proof of the inclusion
+	Proof *cid.Cid
+	// Expiration as unix epoch in seconds
+	Expiration int64
+	// Claim indicates the cid of the claim - the claim should be fetchable by combining the http multiaddr of the provider with the claim cid
+	Claim cid.Cid
+}
+
+func (i *InclusionClaimMetadata) ID() multicodec.Code {
+	return InclusionClaimID
+}
+func (i *InclusionClaimMetadata) MarshalBinary() ([]byte, error)            { return marshalBinary(i) }
+func (i *InclusionClaimMetadata) UnmarshalBinary(data []byte) error         { return unmarshalBinary(i, data) }
+func (i *InclusionClaimMetadata) ReadFrom(r io.Reader) (n int64, err error) { return readFrom(i, r) }
+func (i *InclusionClaimMetadata) GetClaim() cid.Cid {
+	return i.Claim
+}
+func (i *InclusionClaimMetadata) GetExpiration() int64 {
+	return i.Expiration
+}
 
 type hasID[T any] interface {
 	*T
@@ -225,12 +366,45 @@ func readFrom[PT hasID[T], T any](val PT, r io.Reader) (int64, error) {
 		return cr.readCount, fmt.Errorf("transport id does not match %s: %s", val.ID(), id)
 	}
 
-	nb := nodePrototypes[val.ID()].Representation().NewBuilder()
-	err = dagcbor.Decode(nb, cr)
-	if err != nil {
+	// The payload is read on its own first, as it may be followed by other
+	// protocols, which the decoder would reject as trailing content.
+	var payload bytes.Buffer
+	compact := false
+	if err := copyCBORItem(&payload, cr, 0, &compact); err != nil {
 		return cr.readCount, err
 	}
-	nd := nb.Build()
+	proto := nodePrototypes[val.ID()]
+	var nd datamodel.Node
+	if !compact {
+		// Without compact links the payload is decoded straight into the typed
+		// node. This fails if the payload has fields the type does not define,
+		// in which case it is decoded again below without them.
+		nb := proto.Representation().NewBuilder()
+		if err := dagcbor.Decode(nb, bytes.NewReader(payload.Bytes())); err == nil {
+			nd = nb.Build()
+		}
+	}
+	if nd == nil {
+		// Decode untyped, so that unknown fields can be dropped and compactly
+		// encoded links (see [Compact]) expanded before building the typed node.
+		raw := basicnode.Prototype.Any.NewBuilder()
+		if err := dagcbor.Decode(raw, &payload); err != nil {
+			return cr.readCount, err
+		}
+		known, err := knownFields(raw.Build(), proto.Type())
+		if err != nil {
+			return cr.readCount, err
+		}
+		expanded, err := expandLinks(known, proto.Type())
+		if err != nil {
+			return cr.readCount, err
+		}
+		nb := proto.Representation().NewBuilder()
+		if err := datamodel.Copy(expanded, nb); err != nil {
+			return cr.readCount, err
+		}
+		nd = nb.Build()
+	}
 	read := bindnode.Unwrap(nd).(PT)
 	*val = *read
 	return cr.readCount, nil
@@ -3,7 +3,9 @@ package metadata_test
 import (
 	"testing"
 
+	"github.com/ipfs/go-cid"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	ipnimd "github.com/ipni/go-libipni/metadata"
 	"github.com/storacha/go-libstoracha/metadata"
 	"github.com/storacha/go-libstoracha/testutil"
 	"github.com/stretchr/testify/require"
@@ -98,3 +100,61 @@ func TestRoundTripLocationCommitmentMetadata(t *testing.T) {
 		require.Equal(t, meta0, meta1)
 	})
 }
+
+func TestRoundTripClaimMetadata(t *testing.T) {
+	randomCID := func() cid.Cid {
+		return testutil.RandomCID(t).(cidlink.Link).Cid
+	}
+	blocks := randomCID()
+	proof := randomCID()
+
+	for _, tc := range []struct {
+		name  string
+		meta0 ipnimd.Protocol
+		meta1 ipnimd.Protocol
+	}{
+		{
+			name:  "partition",
+			meta0: &metadata.PartitionClaimMetadata{Blocks: &blocks, Parts: []cid.Cid{randomCID(), randomCID()}, Expiration: 1234, Claim: randomCID()},
+			meta1: &metadata.PartitionClaimMetadata{},
+		},
+		{
+			name:  "partition without blocks",
+			meta0: &metadata.PartitionClaimMetadata{Parts: []cid.Cid{randomCID()}, Expiration: 1234, Claim: randomCID()},
+			meta1: &metadata.PartitionClaimMetadata{},
+		},
+		{
+			name:  "relation",
+			meta0: &metadata.RelationClaimMetadata{Parts: []cid.Cid{randomCID()}, Expiration: 1234, Claim: randomCID()},
+			meta1: &metadata.RelationClaimMetadata{},
+		},
+		{
+			name:  "inclusion",
+			meta0: &metadata.InclusionClaimMetadata{Includes: randomCID(), Proof: &proof, Expiration: 1234, Claim: randomCID()},
+			meta1: &metadata.InclusionClaimMetadata{},
+		},
+		{
+			name:  "inclusion without proof",
+			meta0: &metadata.InclusionClaimMetadata{Includes: randomCID(), Expiration: 1234, Claim: randomCID()},
+			meta1: &metadata.InclusionClaimMetadata{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bytes, err := tc.meta0.MarshalBinary()
+			require.NoError(t, err)
+
+			err = tc.meta1.UnmarshalBinary(bytes)
+			require.NoError(t, err)
+			require.Equal(t, tc.meta0, tc.meta1)
+
+			// decodable via the metadata context
+			md := metadata.MetadataContext.New()
+			require.NoError(t, md.UnmarshalBinary(bytes))
+			require.Equal(t, tc.meta0, md.Get(tc.meta0.ID()))
+
+			expiresAt, ok := metadata.ExpiresAt(md)
+			require.True(t, ok)
+			require.Equal(t, int64(1234), expiresAt.Unix())
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+
+	logging "github.com/ipfs/go-log/v2"
+	ipnimd "github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multicodec"
+)
+
+var log = logging.Logger("metadata")
+
+// DefaultSizeBudget is the maximum encoded size of metadata, in bytes, that
+// IPNI records should respect.
+const DefaultSizeBudget = 100
+
+// ErrOverBudget is returned when encoded metadata exceeds the size budget.
+var ErrOverBudget = errors.New("metadata exceeds size budget")
+
+// ProtocolSize is the encoded size of a metadata protocol.
+type ProtocolSize struct {
+	Protocol multicodec.Code
+	Size     int
+}
+
+// SizeReport describes the encoded size of metadata.
+type SizeReport struct {
+	// Protocols are the encoded sizes of each protocol, in encoding order.
+	Protocols []ProtocolSize
+	// Size is the total encoded size.
+	Size int
+	// Budget is the budget the size was checked against.
+	Budget int
+}
+
+// OverBudget returns true if the encoded size exceeds the budget.
+func (r SizeReport) OverBudget() bool {
+	return r.Size > r.Budget
+}
+
+// SizeOption is an option configuring metadata size validation.
+type SizeOption func(cfg *sizeConfig)
+
+type sizeConfig struct {
+	budget   int
+	warnOnly bool
+}
+
+// WithSizeBudget configures the maximum encoded size of metadata, in bytes.
+// If not configured, [DefaultSizeBudget] is used.
+func WithSizeBudget(budget int) SizeOption {
+	return func(cfg *sizeConfig) {
+		cfg.budget = budget
+	}
+}
+
+// WithWarnOnly logs a warning when metadata exceeds the budget, instead of
+// returning [ErrOverBudget].
+func WithWarnOnly() SizeOption {
+	return func(cfg *sizeConfig) {
+		cfg.warnOnly = true
+	}
+}
+
+// ValidateSize encodes the metadata and checks its size against the budget.
+// The report is returned even when the metadata exceeds the budget. Wrap
+// protocols in [Compact] to reduce their size.
+func ValidateSize(md ipnimd.Metadata, opts ...SizeOption) (SizeReport, error) {
+	cfg := sizeConfig{budget: DefaultSizeBudget}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	report := SizeReport{Budget: cfg.budget}
+	for _, code := range md.Protocols() {
+		data, err := md.Get(code).MarshalBinary()
+		if err != nil {
+			return report, fmt.Errorf("encoding %s metadata: %w", code, err)
+		}
+		report.Protocols = append(report.Protocols, ProtocolSize{Protocol: code, Size: len(data)})
+		report.Size += len(data)
+	}
+
+	if report.OverBudget() {
+		if cfg.warnOnly {
+			log.Warnw("Metadata exceeds size budget", "size", report.Size, "budget", report.Budget, "protocols", report.Protocols)
+			return report, nil
+		}
+		return report, fmt.Errorf("%w: %d bytes, budget %d bytes", ErrOverBudget, report.Size, report.Budget)
+	}
+	return report, nil
+}
@@ -0,0 +1,180 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/maurl"
+	ipnimd "github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/storacha/go-libstoracha/digestutil"
+)
+
+// URL template placeholders found in the HTTP multiaddrs of providers.
+const (
+	// BlobPlaceholder is replaced with the multibase encoded multihash of a blob.
+	BlobPlaceholder = "{blob}"
+	// BlobCIDPlaceholder is replaced with the CID of a blob.
+	BlobCIDPlaceholder = "{blobCID}"
+	// ClaimPlaceholder is replaced with the CID of a claim.
+	ClaimPlaceholder = "{claim}"
+)
+
+// ErrNoTemplateURL is returned when none of the provider addresses is an HTTP
+// URL containing the placeholders needed to build a request.
+var ErrNoTemplateURL = errors.New("no provider URL template")
+
+// ErrInvalidRange is returned when the byte range of a location commitment
+// cannot be requested with an HTTP Range header.
+var ErrInvalidRange = errors.New("invalid byte range")
+
+// TemplateURLs returns the HTTP URLs of the provider addresses whose path
+// contains at least one of the passed placeholders, in address order.
+func TemplateURLs(provider peer.AddrInfo, placeholders ...string) []*url.URL {
+	var urls []*url.URL
+	for _, addr := range provider.Addrs {
+		u, err := maurl.ToURL(addr)
+		if err != nil {
+			continue
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		for _, p := range placeholders {
+			if strings.Contains(u.Path, p) {
+				urls = append(urls, u)
+				break
+			}
+		}
+	}
+	return urls
+}
+
+// ExpandURL returns a copy of the template URL with every placeholder in its
+// path replaced with the corresponding value.
+func ExpandURL(template *url.URL, values map[string]string) *url.URL {
+	u := *template
+	for p, v := range values {
+		u.Path = strings.ReplaceAll(u.Path, p, v)
+	}
+	u.RawPath = ""
+	return &u
+}
+
+// BlobURL returns the URL the blob described by a location commitment can be
+// retrieved from. The digest is the multihash used to lookup the record, which
+// identifies the blob unless the metadata specifies a shard.
+func BlobURL(provider peer.AddrInfo, digest mh.Multihash, md *LocationCommitmentMetadata) (*url.URL, error) {
+	urls := TemplateURLs(provider, BlobPlaceholder, BlobCIDPlaceholder)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("resolving blob URL: %w", ErrNoTemplateURL)
+	}
+	blob := cid.NewCidV1(cid.Raw, digest)
+	if md.Shard != nil {
+		blob = *md.Shard
+	}
+	return ExpandURL(urls[0], map[string]string{
+		BlobPlaceholder:    digestutil.Format(blob.Hash()),
+		BlobCIDPlaceholder: blob.String(),
+	}), nil
+}
+
+// ClaimURL returns the URL the claim referenced by the metadata can be
+// retrieved from.
+func ClaimURL(provider peer.AddrInfo, md HasClaim) (*url.URL, error) {
+	urls := TemplateURLs(provider, ClaimPlaceholder)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("resolving claim URL: %w", ErrNoTemplateURL)
+	}
+	return ExpandURL(urls[0], map[string]string{
+		ClaimPlaceholder: md.GetClaim().String(),
+	}), nil
+}
+
+// Header returns the value of the HTTP Range header requesting the range. It
+// returns [ErrInvalidRange] for an empty range, which a Range header cannot
+// express, and for a range extending beyond the largest offset.
+func (r Range) Header() (string, error) {
+	if r.Length == nil {
+		return fmt.Sprintf("bytes=%d-", r.Offset), nil
+	}
+	if *r.Length == 0 {
+		return "", fmt.Errorf("%w: empty range at offset %d", ErrInvalidRange, r.Offset)
+	}
+	if *r.Length-1 > math.MaxUint64-r.Offset {
+		return "", fmt.Errorf("%w: range of %d bytes at offset %d overflows", ErrInvalidRange, *r.Length, r.Offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Offset, r.Offset+*r.Length-1), nil
+}
+
+// BlobRequest returns a GET request for the blob described by a location
+// commitment, with a Range header if the metadata specifies a byte range.
+func BlobRequest(ctx context.Context, provider peer.AddrInfo, digest mh.Multihash, md *LocationCommitmentMetadata) (*http.Request, error) {
+	u, err := BlobURL(provider, digest, md)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating blob request: %w", err)
+	}
+	if md.Range != nil {
+		rng, err := md.Range.Header()
+		if err != nil {
+			return nil, fmt.Errorf("creating blob request: %w", err)
+		}
+		req.Header.Set("Range", rng)
+	}
+	return req, nil
+}
+
+// ClaimRequest returns a GET request for the claim referenced by the metadata.
+func ClaimRequest(ctx context.Context, provider peer.AddrInfo, md HasClaim) (*http.Request, error) {
+	u, err := ClaimURL(provider, md)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating claim request: %w", err)
+	}
+	return req, nil
+}
+
+// Requests are the HTTP requests resolved for a metadata protocol.
+type Requests struct {
+	// Blob is the request for the blob, set for location commitments only.
+	Blob *http.Request
+	// Claim is the request for the claim, set for protocols referencing one.
+	Claim *http.Request
+}
+
+// ResolveRequests returns the HTTP requests for the blob and claim of a
+// metadata protocol looked up by digest from the provider. Protocols wrapped
+// in [Compact] are resolved as the protocol they wrap.
+func ResolveRequests(ctx context.Context, provider peer.AddrInfo, digest mh.Multihash, p ipnimd.Protocol) (Requests, error) {
+	var reqs Requests
+	p = Unwrap(p)
+	if lc, ok := p.(*LocationCommitmentMetadata); ok {
+		req, err := BlobRequest(ctx, provider, digest, lc)
+		if err != nil {
+			return Requests{}, err
+		}
+		reqs.Blob = req
+	}
+	if hc, ok := p.(HasClaim); ok {
+		req, err := ClaimRequest(ctx, provider, hc)
+		if err != nil {
+			return Requests{}, err
+		}
+		reqs.Claim = req
+	}
+	return reqs, nil
+}
@@ -0,0 +1,92 @@
+package metadata_test
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/maurl"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/storacha/go-libstoracha/digestutil"
+	"github.com/storacha/go-libstoracha/metadata"
+	"github.com/storacha/go-libstoracha/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveRequests(t *testing.T) {
+	base := testutil.Must(maurl.FromURL(testutil.Must(url.Parse("https://node.com"))(t)))(t)
+	path := func(p string) multiaddr.Multiaddr {
+		return multiaddr.Join(base, testutil.Must(multiaddr.NewMultiaddr("/http-path/"+url.PathEscape(p)))(t))
+	}
+	provider := peer.AddrInfo{
+		ID:    testutil.RandomPeer(t),
+		Addrs: []multiaddr.Multiaddr{path("blob/{blob}"), path("claim/{claim}")},
+	}
+	digest := testutil.RandomMultihash(t)
+	claim := testutil.RandomCID(t).(cidlink.Link).Cid
+
+	t.Run("location commitment", func(t *testing.T) {
+		length := uint64(100)
+		md := &metadata.LocationCommitmentMetadata{
+			Range: &metadata.Range{Offset: 20, Length: &length},
+			Claim: claim,
+		}
+		reqs, err := metadata.ResolveRequests(context.Background(), provider, digest, md)
+		require.NoError(t, err)
+		require.Equal(t, "https://node.com/blob/"+digestutil.Format(digest), reqs.Blob.URL.String())
+		require.Equal(t, "bytes=20-119", reqs.Blob.Header.Get("Range"))
+		require.Equal(t, "https://node.com/claim/"+claim.String(), reqs.Claim.URL.String())
+	})
+
+	t.Run("shard", func(t *testing.T) {
+		shard := cid.NewCidV1(cid.Raw, testutil.RandomMultihash(t))
+		md := &metadata.LocationCommitmentMetadata{
+			Shard: &shard,
+			Range: &metadata.Range{Offset: 20},
+			Claim: claim,
+		}
+		provider := peer.AddrInfo{ID: provider.ID, Addrs: []multiaddr.Multiaddr{path("piece/{blobCID}/{blob}")}}
+		req, err := metadata.BlobRequest(context.Background(), provider, digest, md)
+		require.NoError(t, err)
+		require.Equal(t, "https://node.com/piece/"+shard.String()+"/"+digestutil.Format(shard.Hash()), req.URL.String())
+		require.Equal(t, "bytes=20-", req.Header.Get("Range"))
+
+		_, err = metadata.ResolveRequests(context.Background(), provider, digest, md)
+		require.ErrorIs(t, err, metadata.ErrNoTemplateURL)
+	})
+
+	t.Run("invalid range", func(t *testing.T) {
+		empty := uint64(0)
+		md := &metadata.LocationCommitmentMetadata{
+			Range: &metadata.Range{Offset: 0, Length: &empty},
+			Claim: claim,
+		}
+		_, err := metadata.BlobRequest(context.Background(), provider, digest, md)
+		require.ErrorIs(t, err, metadata.ErrInvalidRange)
+
+		md.Range.Offset = 20
+		_, err = metadata.ResolveRequests(context.Background(), provider, digest, md)
+		require.ErrorIs(t, err, metadata.ErrInvalidRange)
+
+		length := uint64(math.MaxUint64)
+		_, err = metadata.Range{Offset: 2, Length: &length}.Header()
+		require.ErrorIs(t, err, metadata.ErrInvalidRange)
+
+		length = 1
+		rng, err := metadata.Range{Offset: 0, Length: &length}.Header()
+		require.NoError(t, err)
+		require.Equal(t, "bytes=0-0", rng)
+	})
+
+	t.Run("claim only", func(t *testing.T) {
+		md := &metadata.Compact{Protocol: &metadata.IndexClaimMetadata{Index: claim, Claim: claim}}
+		reqs, err := metadata.ResolveRequests(context.Background(), provider, digest, md)
+		require.NoError(t, err)
+		require.Nil(t, reqs.Blob)
+		require.Equal(t, "https://node.com/claim/"+claim.String(), reqs.Claim.URL.String())
+	})
+}